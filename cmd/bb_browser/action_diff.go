@@ -0,0 +1,261 @@
+package main
+
+import (
+	"context"
+	"fmt"
+	"log"
+	"net/http"
+	"sort"
+	"strings"
+
+	remoteexecution "github.com/bazelbuild/remote-apis/build/bazel/remote/execution/v2"
+	"github.com/buildbarn/bb-storage/pkg/digest"
+	"github.com/buildbarn/bb-storage/pkg/util"
+)
+
+// maximumSequenceDiffEdits is the maximum number of edits that are
+// computed when comparing lists of strings, such as command line
+// arguments. Lists that differ more are displayed as being replaced
+// entirely.
+const maximumSequenceDiffEdits = 1000
+
+// fieldDiff is a field of an Action or Command message whose value
+// differs between two actions.
+type fieldDiff struct {
+	Name string
+	Old  string
+	New  string
+}
+
+// keyValueDiff is a named value, such as an environment variable or a
+// platform property, that differs between two actions. Old is nil if
+// the value was added, while New is nil if the value was removed.
+type keyValueDiff struct {
+	Name string
+	Old  *string
+	New  *string
+}
+
+// sequenceDiffLine is an element of a list of strings, such as command
+// line arguments, annotated with whether it was removed or added.
+type sequenceDiffLine struct {
+	Value   string
+	Removed bool
+	Added   bool
+}
+
+// actionDiffInfo contains the information that we display on pages
+// comparing two actions, explaining why their digests differ.
+type actionDiffInfo struct {
+	OldActionDigest digest.Digest
+	NewActionDigest digest.Digest
+
+	Fields               []fieldDiff
+	Arguments            []sequenceDiffLine
+	EnvironmentVariables []keyValueDiff
+	PlatformProperties   []keyValueDiff
+	OutputPaths          []sequenceDiffLine
+	InputRoot            directoryDiffInfo
+}
+
+// diffStringSequences computes the differences between two lists of
+// strings. If the lists are identical, nil is returned.
+func diffStringSequences(oldValues, newValues []string) []sequenceDiffLine {
+	operations := diffSequences(
+		len(oldValues),
+		len(newValues),
+		func(oldIndex, newIndex int) bool { return oldValues[oldIndex] == newValues[newIndex] },
+		maximumSequenceDiffEdits)
+	lines := make([]sequenceDiffLine, 0, len(operations))
+	hasChanges := false
+	for _, operation := range operations {
+		switch operation.Type {
+		case diffOperationTypeEqual:
+			lines = append(lines, sequenceDiffLine{Value: oldValues[operation.OldIndex]})
+		case diffOperationTypeDelete:
+			lines = append(lines, sequenceDiffLine{Value: oldValues[operation.OldIndex], Removed: true})
+			hasChanges = true
+		case diffOperationTypeInsert:
+			lines = append(lines, sequenceDiffLine{Value: newValues[operation.NewIndex], Added: true})
+			hasChanges = true
+		}
+	}
+	if !hasChanges {
+		return nil
+	}
+	return lines
+}
+
+// diffKeyValues computes the differences between two sets of named
+// values, returning the entries that differ sorted by name.
+func diffKeyValues(oldValues, newValues map[string]string) []keyValueDiff {
+	var diffs []keyValueDiff
+	for name, oldValue := range oldValues {
+		if newValue, ok := newValues[name]; !ok {
+			diffs = append(diffs, keyValueDiff{Name: name, Old: &oldValue})
+		} else if oldValue != newValue {
+			diffs = append(diffs, keyValueDiff{Name: name, Old: &oldValue, New: &newValue})
+		}
+	}
+	for name, newValue := range newValues {
+		if _, ok := oldValues[name]; !ok {
+			diffs = append(diffs, keyValueDiff{Name: name, New: &newValue})
+		}
+	}
+	sort.Slice(diffs, func(i, j int) bool { return diffs[i].Name < diffs[j].Name })
+	return diffs
+}
+
+func getEnvironmentVariables(command *remoteexecution.Command) map[string]string {
+	environmentVariables := map[string]string{}
+	for _, environmentVariable := range command.EnvironmentVariables {
+		environmentVariables[environmentVariable.Name] = environmentVariable.Value
+	}
+	return environmentVariables
+}
+
+// getPlatformProperties returns the platform properties of an action.
+// Platform properties stored in the Command are only used if the
+// Action does not have any, as they have been deprecated. Properties
+// that are specified multiple times have their values joined.
+func getPlatformProperties(action *remoteexecution.Action, command *remoteexecution.Command) map[string]string {
+	properties := action.Platform.GetProperties()
+	if len(properties) == 0 {
+		properties = command.Platform.GetProperties()
+	}
+	values := map[string][]string{}
+	for _, property := range properties {
+		values[property.Name] = append(values[property.Name], property.Value)
+	}
+	platformProperties := make(map[string]string, len(values))
+	for name, value := range values {
+		platformProperties[name] = strings.Join(value, ", ")
+	}
+	return platformProperties
+}
+
+func formatTimeout(action *remoteexecution.Action) string {
+	if action.Timeout == nil {
+		return "none"
+	}
+	return action.Timeout.AsDuration().String()
+}
+
+func formatDoNotCache(action *remoteexecution.Action) string {
+	if action.DoNotCache {
+		return "yes"
+	}
+	return "no"
+}
+
+// getActionAndCommand loads an Action and its associated Command from
+// the Content Addressable Storage.
+func (s *BrowserService) getActionAndCommand(ctx context.Context, actionDigest digest.Digest) (*remoteexecution.Action, *remoteexecution.Command, error) {
+	actionMessage, err := s.messageCache.Get(ctx, actionDigest, &remoteexecution.Action{})
+	if err != nil {
+		return nil, nil, util.StatusWrapf(err, "Failed to obtain action %#v", actionDigest.String())
+	}
+	action := actionMessage.(*remoteexecution.Action)
+	commandDigest, err := actionDigest.GetDigestFunction().NewDigestFromProto(action.CommandDigest)
+	if err != nil {
+		return nil, nil, util.StatusWrapf(err, "Invalid command digest for action %#v", actionDigest.String())
+	}
+	commandMessage, err := s.messageCache.Get(ctx, commandDigest, &remoteexecution.Command{})
+	if err != nil {
+		return nil, nil, util.StatusWrapf(err, "Failed to obtain command %#v", commandDigest.String())
+	}
+	return action, commandMessage.(*remoteexecution.Command), nil
+}
+
+func (s *BrowserService) handleActionDiff(w http.ResponseWriter, req *http.Request) {
+	oldActionDigest, err := getDigestFromRequestVars(req, "hashA", "sizeBytesA")
+	if err != nil {
+		s.renderError(w, req, err)
+		return
+	}
+	newActionDigest, err := getDigestFromRequestVars(req, "hashB", "sizeBytesB")
+	if err != nil {
+		s.renderError(w, req, err)
+		return
+	}
+
+	ctx := extractContextFromRequest(req)
+	oldAction, oldCommand, err := s.getActionAndCommand(ctx, oldActionDigest)
+	if err != nil {
+		s.renderError(w, req, err)
+		return
+	}
+	newAction, newCommand, err := s.getActionAndCommand(ctx, newActionDigest)
+	if err != nil {
+		s.renderError(w, req, err)
+		return
+	}
+
+	actionDiffInfo := actionDiffInfo{
+		OldActionDigest:      oldActionDigest,
+		NewActionDigest:      newActionDigest,
+		Arguments:            diffStringSequences(oldCommand.Arguments, newCommand.Arguments),
+		EnvironmentVariables: diffKeyValues(getEnvironmentVariables(oldCommand), getEnvironmentVariables(newCommand)),
+		PlatformProperties:   diffKeyValues(getPlatformProperties(oldAction, oldCommand), getPlatformProperties(newAction, newCommand)),
+		OutputPaths:          diffStringSequences(oldCommand.OutputPaths, newCommand.OutputPaths),
+		InputRoot: directoryDiffInfo{
+			RootDirectory: "../../..",
+		},
+	}
+
+	// Compare scalar fields of the Action and Command messages.
+	for _, field := range []fieldDiff{
+		{
+			Name: "Command digest",
+			Old:  fmt.Sprintf("%s-%d", oldAction.CommandDigest.GetHash(), oldAction.CommandDigest.GetSizeBytes()),
+			New:  fmt.Sprintf("%s-%d", newAction.CommandDigest.GetHash(), newAction.CommandDigest.GetSizeBytes()),
+		},
+		{
+			Name: "Input root digest",
+			Old:  fmt.Sprintf("%s-%d", oldAction.InputRootDigest.GetHash(), oldAction.InputRootDigest.GetSizeBytes()),
+			New:  fmt.Sprintf("%s-%d", newAction.InputRootDigest.GetHash(), newAction.InputRootDigest.GetSizeBytes()),
+		},
+		{Name: "Timeout", Old: formatTimeout(oldAction), New: formatTimeout(newAction)},
+		{Name: "Do not cache", Old: formatDoNotCache(oldAction), New: formatDoNotCache(newAction)},
+		{Name: "Salt", Old: fmt.Sprintf("%x", oldAction.Salt), New: fmt.Sprintf("%x", newAction.Salt)},
+		{Name: "Working directory", Old: oldCommand.WorkingDirectory, New: newCommand.WorkingDirectory},
+	} {
+		if field.Old != field.New {
+			actionDiffInfo.Fields = append(actionDiffInfo.Fields, field)
+		}
+	}
+
+	// Compare the input roots of both actions recursively.
+	digestFunction := oldActionDigest.GetDigestFunction()
+	if !equalDigestProtos(oldAction.InputRootDigest, newAction.InputRootDigest) {
+		oldInputRootDigest, err := digestFunction.NewDigestFromProto(oldAction.InputRootDigest)
+		if err != nil {
+			s.renderError(w, req, err)
+			return
+		}
+		oldInputRoot, err := s.getDirectory(ctx, oldInputRootDigest)
+		if err != nil {
+			s.renderError(w, req, err)
+			return
+		}
+		newInputRootDigest, err := digestFunction.NewDigestFromProto(newAction.InputRootDigest)
+		if err != nil {
+			s.renderError(w, req, err)
+			return
+		}
+		newInputRoot, err := s.getDirectory(ctx, newInputRootDigest)
+		if err != nil {
+			s.renderError(w, req, err)
+			return
+		}
+		actionDiffInfo.InputRoot.Entries, actionDiffInfo.InputRoot.DirectoryLimitReached, err = s.diffDirectories(ctx, digestFunction, oldInputRoot, newInputRoot, s.getDirectory, s.getDirectory)
+		if err != nil {
+			s.renderError(w, req, err)
+			return
+		}
+	}
+
+	if err := s.templates.ExecuteTemplate(w, "page_action_diff.html", &actionDiffInfo); err != nil {
+		log.Print(err)
+	}
+}
@@ -0,0 +1,457 @@
+package main
+
+import (
+	"context"
+	"log"
+	"net/http"
+	"slices"
+	"sort"
+	"strings"
+	"unicode/utf8"
+
+	remoteexecution "github.com/bazelbuild/remote-apis/build/bazel/remote/execution/v2"
+	"github.com/buildbarn/bb-browser/pkg/proto/query"
+	"github.com/buildbarn/bb-storage/pkg/digest"
+	"github.com/buildbarn/bb-storage/pkg/filesystem/path"
+	"github.com/buildbarn/bb-storage/pkg/util"
+	"github.com/gorilla/mux"
+
+	"google.golang.org/grpc/codes"
+	"google.golang.org/grpc/status"
+)
+
+// maximumActionPathFilePreviewSizeBytes is the maximum size of files
+// whose contents are displayed on pages browsing the input root or
+// outputs of an action. Larger files need to be downloaded.
+const maximumActionPathFilePreviewSizeBytes = 100000
+
+// actionPathBreadcrumb is a single entry of the breadcrumb displayed
+// at the top of pages browsing the input root or outputs of an action.
+type actionPathBreadcrumb struct {
+	Name string
+	URL  string
+}
+
+// actionPathInfo contains the information that we display on pages
+// browsing the input root or outputs of an action by path. Unlike
+// directory pages, these pages retain the path that was followed to
+// reach a directory or file, so that links to them can be shared.
+//
+// Exactly one of Directory, File and Symlink is set.
+type actionPathInfo struct {
+	ActionDigest digest.Digest
+	Kind         string
+	Path         string
+	Breadcrumbs  []actionPathBreadcrumb
+	// The path of "${instance_name}/blobs/${digest_function}/"
+	// relative to the current page, so that relative links to
+	// other pages can be emitted.
+	RootDirectory string
+
+	DirectoryDigest digest.Digest
+	Directory       *remoteexecution.Directory
+	Listing         *directoryListing
+	getDirectory    directoryGetter
+
+	File          *remoteexecution.FileNode
+	FileContents  string
+	FileNotFound  bool
+	FileTooLarge  bool
+	FileIsNotUTF8 bool
+	Symlink       *remoteexecution.SymlinkNode
+}
+
+// HasDirectoryDigest returns whether the directory displayed on the
+// page is stored as a separate object. Directories containing outputs
+// that are not outputs themselves have no digest.
+func (pi *actionPathInfo) HasDirectoryDigest() bool {
+	return pi.DirectoryDigest != digest.BadDigest
+}
+
+func (pi *actionPathInfo) toProto() *query.ActionPathInfo {
+	return &query.ActionPathInfo{
+		ActionDigest:    getDigestProto(pi.ActionDigest),
+		Path:            pi.Path,
+		DirectoryDigest: getDigestProto(pi.DirectoryDigest),
+		Directory:       pi.Directory,
+		File:            pi.File,
+		Symlink:         pi.Symlink,
+	}
+}
+
+// actionPathResolver is called by handleActionPath to resolve a path
+// within the input root or outputs of an action, storing the
+// directory, file or symbolic link it refers to in actionPathInfo.
+type actionPathResolver func(ctx context.Context, info *actionPathInfo, components []string) error
+
+// resolveDirectoryPath resolves a path relative to a directory. Paths
+// are not permitted to traverse symbolic links, as their targets may
+// not be part of the directory hierarchy.
+func resolveDirectoryPath(ctx context.Context, info *actionPathInfo, directoryDigest digest.Digest, directory *remoteexecution.Directory, getDirectory directoryGetter, components []string) error {
+	digestFunction := directoryDigest.GetDigestFunction()
+	for i, name := range components {
+		currentPath := strings.Join(components[:i+1], "/")
+		if directoryNode, ok := findDirectoryNode(directory, name); ok {
+			childDigest, err := digestFunction.NewDigestFromProto(directoryNode.Digest)
+			if err != nil {
+				return util.StatusWrapf(err, "Invalid digest for directory %#v", currentPath)
+			}
+			if directory, err = getDirectory(ctx, childDigest); err != nil {
+				return util.StatusWrapf(err, "Failed to obtain directory %#v", currentPath)
+			}
+			directoryDigest = childDigest
+			continue
+		}
+
+		if i == len(components)-1 {
+			for _, fileNode := range directory.Files {
+				if fileNode.Name == name {
+					info.File = fileNode
+					return nil
+				}
+			}
+		}
+		for _, symlinkNode := range directory.Symlinks {
+			if symlinkNode.Name == name {
+				if i < len(components)-1 {
+					return status.Errorf(codes.InvalidArgument, "Path %#v resolves to a symbolic link, which cannot be traversed", currentPath)
+				}
+				info.Symlink = symlinkNode
+				return nil
+			}
+		}
+		return status.Errorf(codes.NotFound, "Path %#v does not exist", currentPath)
+	}
+
+	info.DirectoryDigest = directoryDigest
+	info.Directory = directory
+	info.getDirectory = getDirectory
+	return nil
+}
+
+func findDirectoryNode(directory *remoteexecution.Directory, name string) (*remoteexecution.DirectoryNode, bool) {
+	for _, directoryNode := range directory.Directories {
+		if directoryNode.Name == name {
+			return directoryNode, true
+		}
+	}
+	return nil, false
+}
+
+// resolveActionInputPath resolves a path within the input root of an
+// action.
+func (s *BrowserService) resolveActionInputPath(ctx context.Context, info *actionPathInfo, components []string) error {
+	actionMessage, err := s.messageCache.Get(ctx, info.ActionDigest, &remoteexecution.Action{})
+	if err != nil {
+		return util.StatusWrapf(err, "Failed to obtain action %#v", info.ActionDigest.String())
+	}
+	action := actionMessage.(*remoteexecution.Action)
+	inputRootDigest, err := info.ActionDigest.GetDigestFunction().NewDigestFromProto(action.InputRootDigest)
+	if err != nil {
+		return util.StatusWrap(err, "Invalid input root digest")
+	}
+	inputRoot, err := s.getDirectory(ctx, inputRootDigest)
+	if err != nil {
+		return util.StatusWrap(err, "Failed to obtain input root")
+	}
+	return resolveDirectoryPath(ctx, info, inputRootDigest, inputRoot, s.getDirectory, components)
+}
+
+// splitPath splits a slash separated path into its components,
+// ignoring empty components.
+func splitPath(p string) []string {
+	return strings.FieldsFunc(p, func(r rune) bool { return r == '/' })
+}
+
+// getOutputDirectoryDigest returns the digest of the object in which
+// the contents of an output directory are stored.
+func getOutputDirectoryDigest(outputDirectory *remoteexecution.OutputDirectory) *remoteexecution.Digest {
+	if outputDirectory.RootDirectoryDigest != nil {
+		return outputDirectory.RootDirectoryDigest
+	}
+	return outputDirectory.TreeDigest
+}
+
+// getActionResultSymlinks returns all symbolic links contained in an
+// ActionResult, including the ones stored in deprecated fields.
+func getActionResultSymlinks(actionResult *remoteexecution.ActionResult) []*remoteexecution.OutputSymlink {
+	symlinks := make([]*remoteexecution.OutputSymlink, 0, len(actionResult.OutputSymlinks)+len(actionResult.OutputFileSymlinks)+len(actionResult.OutputDirectorySymlinks))
+	symlinks = append(symlinks, actionResult.OutputSymlinks...)
+	symlinks = append(symlinks, actionResult.OutputFileSymlinks...)
+	return append(symlinks, actionResult.OutputDirectorySymlinks...)
+}
+
+// resolveActionOutputPath resolves a path within the outputs of an
+// action. Paths may refer to output files and symbolic links, to paths
+// within output directories, or to directories containing outputs.
+func (s *BrowserService) resolveActionOutputPath(ctx context.Context, info *actionPathInfo, components []string) error {
+	actionResultMessage, err := s.actionCache.Get(ctx, info.ActionDigest).ToProto(&remoteexecution.ActionResult{}, s.maximumMessageSizeBytes)
+	if err != nil {
+		return util.StatusWrapf(err, "Failed to obtain action result for action %#v", info.ActionDigest.String())
+	}
+	actionResult := actionResultMessage.(*remoteexecution.ActionResult)
+
+	if len(components) > 0 {
+		for _, outputFile := range actionResult.OutputFiles {
+			if slices.Equal(splitPath(outputFile.Path), components) {
+				info.File = &remoteexecution.FileNode{
+					Name:         components[len(components)-1],
+					Digest:       outputFile.Digest,
+					IsExecutable: outputFile.IsExecutable,
+				}
+				return nil
+			}
+		}
+		for _, outputSymlink := range getActionResultSymlinks(actionResult) {
+			if slices.Equal(splitPath(outputSymlink.Path), components) {
+				info.Symlink = &remoteexecution.SymlinkNode{
+					Name:   components[len(components)-1],
+					Target: outputSymlink.Target,
+				}
+				return nil
+			}
+		}
+	}
+	digestFunction := info.ActionDigest.GetDigestFunction()
+	for _, outputDirectory := range actionResult.OutputDirectories {
+		outputDirectoryComponents := splitPath(outputDirectory.Path)
+		if len(components) >= len(outputDirectoryComponents) && slices.Equal(components[:len(outputDirectoryComponents)], outputDirectoryComponents) {
+			rootDirectory, getDirectory, _, _, err := s.getOutputDirectory(ctx, digestFunction, outputDirectory)
+			if err != nil {
+				return err
+			}
+			rootDirectoryDigest, err := digestFunction.NewDigestFromProto(getOutputDirectoryDigest(outputDirectory))
+			if err != nil {
+				return util.StatusWrapf(err, "Invalid digest for output directory %#v", outputDirectory.Path)
+			}
+			return resolveDirectoryPath(ctx, info, rootDirectoryDigest, rootDirectory, getDirectory, components[len(outputDirectoryComponents):])
+		}
+	}
+
+	directory := getImplicitOutputDirectory(actionResult, components)
+	if directory == nil {
+		return status.Errorf(codes.NotFound, "Path %#v does not exist", strings.Join(components, "/"))
+	}
+	info.Directory = directory
+	return nil
+}
+
+// getImplicitOutputDirectory creates a Directory message containing
+// the outputs of an action that are located below a given path. This
+// permits browsing directories that contain outputs, but are not
+// outputs themselves. Child directories that are not outputs have no
+// digest. If no outputs are located below the path, nil is returned.
+func getImplicitOutputDirectory(actionResult *remoteexecution.ActionResult, components []string) *remoteexecution.Directory {
+	directories := map[string]*remoteexecution.DirectoryNode{}
+	files := map[string]*remoteexecution.FileNode{}
+	symlinks := map[string]*remoteexecution.SymlinkNode{}
+
+	// getChildName returns the name of the child of the directory
+	// through which an output is reachable, and whether the output
+	// is the child itself.
+	getChildName := func(outputPath string) (string, bool, bool) {
+		outputPathComponents := splitPath(outputPath)
+		if len(outputPathComponents) <= len(components) || !slices.Equal(outputPathComponents[:len(components)], components) {
+			return "", false, false
+		}
+		return outputPathComponents[len(components)], len(outputPathComponents) == len(components)+1, true
+	}
+	addImplicitDirectory := func(name string) {
+		if _, ok := directories[name]; !ok {
+			directories[name] = &remoteexecution.DirectoryNode{Name: name}
+		}
+	}
+
+	for _, outputDirectory := range actionResult.OutputDirectories {
+		if name, isChild, ok := getChildName(outputDirectory.Path); isChild {
+			directories[name] = &remoteexecution.DirectoryNode{
+				Name:   name,
+				Digest: getOutputDirectoryDigest(outputDirectory),
+			}
+		} else if ok {
+			addImplicitDirectory(name)
+		}
+	}
+	for _, outputFile := range actionResult.OutputFiles {
+		if name, isChild, ok := getChildName(outputFile.Path); isChild {
+			files[name] = &remoteexecution.FileNode{
+				Name:         name,
+				Digest:       outputFile.Digest,
+				IsExecutable: outputFile.IsExecutable,
+			}
+		} else if ok {
+			addImplicitDirectory(name)
+		}
+	}
+	for _, outputSymlink := range getActionResultSymlinks(actionResult) {
+		if name, isChild, ok := getChildName(outputSymlink.Path); isChild {
+			symlinks[name] = &remoteexecution.SymlinkNode{
+				Name:   name,
+				Target: outputSymlink.Target,
+			}
+		} else if ok {
+			addImplicitDirectory(name)
+		}
+	}
+	if len(directories) == 0 && len(files) == 0 && len(symlinks) == 0 {
+		return nil
+	}
+
+	var directory remoteexecution.Directory
+	for _, directoryNode := range directories {
+		directory.Directories = append(directory.Directories, directoryNode)
+	}
+	sort.Slice(directory.Directories, func(i, j int) bool { return directory.Directories[i].Name < directory.Directories[j].Name })
+	for _, fileNode := range files {
+		directory.Files = append(directory.Files, fileNode)
+	}
+	sort.Slice(directory.Files, func(i, j int) bool { return directory.Files[i].Name < directory.Files[j].Name })
+	for _, symlinkNode := range symlinks {
+		directory.Symlinks = append(directory.Symlinks, symlinkNode)
+	}
+	sort.Slice(directory.Symlinks, func(i, j int) bool { return directory.Symlinks[i].Name < directory.Symlinks[j].Name })
+	return &directory
+}
+
+func (s *BrowserService) handleActionInput(w http.ResponseWriter, req *http.Request) {
+	s.handleActionPath(w, req, "input", s.resolveActionInputPath, func(pi *actionPathInfo) *query.Response {
+		return &query.Response{Page: &query.Response_ActionInput{ActionInput: pi.toProto()}}
+	})
+}
+
+func (s *BrowserService) handleActionOutput(w http.ResponseWriter, req *http.Request) {
+	s.handleActionPath(w, req, "output", s.resolveActionOutputPath, func(pi *actionPathInfo) *query.Response {
+		return &query.Response{Page: &query.Response_ActionOutput{ActionOutput: pi.toProto()}}
+	})
+}
+
+// handleActionPath renders a page displaying a directory, file or
+// symbolic link contained in the input root or outputs of an action,
+// identified by its path. Directories are displayed with a trailing
+// slash, so that relative links to their children can be emitted.
+func (s *BrowserService) handleActionPath(w http.ResponseWriter, req *http.Request, kind string, resolve actionPathResolver, newResponse func(pi *actionPathInfo) *query.Response) {
+	actionDigest, err := getDigestFromRequest(req)
+	if err != nil {
+		s.renderError(w, req, err)
+		return
+	}
+	rawPath := mux.Vars(req)["path"]
+	components := splitPath(rawPath)
+	for _, component := range components {
+		if _, ok := path.NewComponent(component); !ok {
+			s.renderError(w, req, status.Errorf(codes.InvalidArgument, "Path contains invalid component %#v", component))
+			return
+		}
+	}
+
+	ctx := extractContextFromRequest(req)
+	info := actionPathInfo{
+		ActionDigest:    actionDigest,
+		Kind:            kind,
+		Path:            strings.Join(components, "/"),
+		DirectoryDigest: digest.BadDigest,
+	}
+	if err := resolve(ctx, &info, components); err != nil {
+		s.renderError(w, req, err)
+		return
+	}
+
+	isDirectoryURL := rawPath == "" || strings.HasSuffix(rawPath, "/")
+	if info.Directory != nil && !isDirectoryURL {
+		target := components[len(components)-1] + "/"
+		if req.URL.RawQuery != "" {
+			target += "?" + req.URL.RawQuery
+		}
+		http.Redirect(w, req, target, http.StatusMovedPermanently)
+		return
+	}
+	if info.Directory == nil && isDirectoryURL {
+		s.renderError(w, req, status.Errorf(codes.InvalidArgument, "Path %#v does not refer to a directory", info.Path))
+		return
+	}
+
+	// Compute relative links to all parent directories, the action
+	// page, and the root of all pages.
+	depth := len(components)
+	if info.Directory == nil {
+		depth--
+	}
+	info.Breadcrumbs = append(info.Breadcrumbs, actionPathBreadcrumb{
+		Name: kind,
+		URL:  getRelativeParentURL(depth),
+	})
+	for i, component := range components {
+		info.Breadcrumbs = append(info.Breadcrumbs, actionPathBreadcrumb{
+			Name: component,
+			URL:  getRelativeParentURL(depth - i - 1),
+		})
+	}
+	info.RootDirectory = strings.Repeat("../", depth+3)
+
+	if info.Directory != nil && isArchiveFormat(req.URL.Query().Get("format")) {
+		if !info.HasDirectoryDigest() {
+			s.renderError(w, req, status.Errorf(codes.InvalidArgument, "Directory %#v is not stored as a separate object, meaning it cannot be downloaded as an archive", info.Path))
+			return
+		}
+		s.generateArchive(ctx, w, req, info.DirectoryDigest, info.Directory, info.getDirectory)
+		return
+	}
+	if isJSONRequested(req) {
+		s.renderJSON(w, req, newResponse(&info))
+		return
+	}
+
+	if info.Directory != nil {
+		listingOptions, err := newDirectoryListingOptionsFromQuery(req.URL.Query())
+		if err != nil {
+			s.renderError(w, req, err)
+			return
+		}
+		info.Listing = newDirectoryListing(info.Directory, listingOptions, "", req.URL.Query())
+	} else if info.File != nil {
+		if err := s.loadActionPathFilePreview(ctx, &info); err != nil {
+			s.renderError(w, req, err)
+			return
+		}
+	}
+	if err := s.templates.ExecuteTemplate(w, "page_action_path.html", &info); err != nil {
+		log.Print(err)
+	}
+}
+
+// loadActionPathFilePreview loads the contents of a file, so that it
+// can be displayed inline. Contents are only displayed if the file is
+// small and contains text.
+func (s *BrowserService) loadActionPathFilePreview(ctx context.Context, info *actionPathInfo) error {
+	fileDigest, err := info.ActionDigest.GetDigestFunction().NewDigestFromProto(info.File.Digest)
+	if err != nil {
+		return util.StatusWrapf(err, "Invalid digest for file %#v", info.Path)
+	}
+	if fileDigest.GetSizeBytes() > maximumActionPathFilePreviewSizeBytes {
+		info.FileTooLarge = true
+		return nil
+	}
+	data, err := s.contentAddressableStorage.Get(ctx, fileDigest).ToByteSlice(maximumActionPathFilePreviewSizeBytes)
+	if err != nil {
+		if status.Code(err) == codes.NotFound {
+			info.FileNotFound = true
+			return nil
+		}
+		return util.StatusWrapf(err, "Failed to obtain file %#v", info.Path)
+	}
+	if !utf8.Valid(data) {
+		info.FileIsNotUTF8 = true
+		return nil
+	}
+	info.FileContents = string(data)
+	return nil
+}
+
+// getRelativeParentURL returns a relative URL pointing to a parent
+// directory of the current page.
+func getRelativeParentURL(levels int) string {
+	if levels <= 0 {
+		return "./"
+	}
+	return strings.Repeat("../", levels)
+}
@@ -0,0 +1,298 @@
+package main
+
+import (
+	"context"
+	"fmt"
+	"log"
+	"net/http"
+	"path"
+	"sort"
+	"strconv"
+
+	remoteexecution "github.com/bazelbuild/remote-apis/build/bazel/remote/execution/v2"
+	cas_proto "github.com/buildbarn/bb-remote-execution/pkg/proto/cas"
+	"github.com/buildbarn/bb-storage/pkg/digest"
+	"github.com/buildbarn/bb-storage/pkg/util"
+	"github.com/gorilla/mux"
+
+	"google.golang.org/grpc/codes"
+	"google.golang.org/grpc/status"
+)
+
+// outputDirectoryDiff is an output directory of an action whose
+// contents differ between two ActionResults.
+type outputDirectoryDiff struct {
+	Path    string
+	OldPath string
+	NewPath string
+	Diff    directoryDiffInfo
+}
+
+// actionResultDiffInfo contains the information that we display on
+// pages comparing two ActionResults, which can be used to determine
+// whether an action produces outputs deterministically.
+type actionResultDiffInfo struct {
+	OldPath         string
+	NewPath         string
+	OldActionDigest digest.Digest
+	NewActionDigest digest.Digest
+
+	Fields            []fieldDiff
+	Outputs           directoryDiffInfo
+	OutputDirectories []outputDirectoryDiff
+}
+
+// getActionResultDiffSide loads one side of an ActionResult diff, which
+// may either be the ActionResult stored in the Action Cache for a given
+// action, or a HistoricalExecuteResponse stored in the Content
+// Addressable Storage. In addition to the ActionResult, it returns the
+// digest of the action and the path of the page displaying it.
+func (s *BrowserService) getActionResultDiffSide(ctx context.Context, req *http.Request, pageType, hashVar, sizeBytesVar string) (digest.Digest, *remoteexecution.ActionResult, string, error) {
+	blobDigest, err := getDigestFromRequestVars(req, hashVar, sizeBytesVar)
+	if err != nil {
+		return digest.BadDigest, nil, "", err
+	}
+	pagePath := fmt.Sprintf("%s/%s-%d", pageType, blobDigest.GetHashString(), blobDigest.GetSizeBytes())
+	if pageType == "action" {
+		actionResultMessage, err := s.actionCache.Get(ctx, blobDigest).ToProto(&remoteexecution.ActionResult{}, s.maximumMessageSizeBytes)
+		if err != nil {
+			return digest.BadDigest, nil, "", util.StatusWrapf(err, "Failed to obtain action result for action %#v", blobDigest.String())
+		}
+		return blobDigest, actionResultMessage.(*remoteexecution.ActionResult), pagePath, nil
+	}
+
+	historicalExecuteResponseMessage, err := s.messageCache.Get(ctx, blobDigest, &cas_proto.HistoricalExecuteResponse{})
+	if err != nil {
+		return digest.BadDigest, nil, "", util.StatusWrapf(err, "Failed to obtain historical execute response %#v", blobDigest.String())
+	}
+	historicalExecuteResponse := historicalExecuteResponseMessage.(*cas_proto.HistoricalExecuteResponse)
+	actionDigest, err := blobDigest.GetDigestFunction().NewDigestFromProto(historicalExecuteResponse.ActionDigest)
+	if err != nil {
+		return digest.BadDigest, nil, "", util.StatusWrapf(err, "Invalid action digest for historical execute response %#v", blobDigest.String())
+	}
+	actionResult := historicalExecuteResponse.ExecuteResponse.GetResult()
+	if actionResult == nil {
+		return digest.BadDigest, nil, "", status.Errorf(codes.NotFound, "Historical execute response %#v does not contain an action result", blobDigest.String())
+	}
+	return actionDigest, actionResult, pagePath, nil
+}
+
+// formatLogDigest returns a textual representation of the digest of
+// the standard output or standard error of an action. Logs that are
+// stored inline are hashed, so that they can be compared against logs
+// stored in the Content Addressable Storage.
+func formatLogDigest(digestFunction digest.Function, logDigest *remoteexecution.Digest, rawLogBody []byte) string {
+	if logDigest != nil {
+		return fmt.Sprintf("%s-%d", logDigest.Hash, logDigest.SizeBytes)
+	}
+	if len(rawLogBody) == 0 {
+		return "none"
+	}
+	digestGenerator := digestFunction.NewGenerator(int64(len(rawLogBody)))
+	digestGenerator.Write(rawLogBody)
+	d := digestGenerator.Sum()
+	return fmt.Sprintf("%s-%d", d.GetHashString(), d.GetSizeBytes())
+}
+
+// getOutputDiffNodes creates an index of all output files and symbolic
+// links of an ActionResult by path, converted to nodes that can be
+// displayed as part of a directory diff. Symbolic links stored in the
+// deprecated fields used by older workers are included as well.
+func getOutputDiffNodes(actionResult *remoteexecution.ActionResult) map[string]*directoryDiffNode {
+	nodes := map[string]*directoryDiffNode{}
+	for _, outputFile := range actionResult.OutputFiles {
+		nodes[outputFile.Path] = &directoryDiffNode{
+			File: &remoteexecution.FileNode{
+				Name:         path.Base(outputFile.Path),
+				Digest:       outputFile.Digest,
+				IsExecutable: outputFile.IsExecutable,
+			},
+		}
+	}
+	for _, outputSymlink := range getActionResultSymlinks(actionResult) {
+		nodes[outputSymlink.Path] = &directoryDiffNode{
+			Symlink: &remoteexecution.SymlinkNode{
+				Name:   path.Base(outputSymlink.Path),
+				Target: outputSymlink.Target,
+			},
+		}
+	}
+	return nodes
+}
+
+// getOutputDirectory loads the root directory of an output directory.
+// Output directories are either stored as a Tree, or as a Directory
+// hierarchy whose root is referenced by the root directory digest. In
+// addition to the root directory, it returns a directoryGetter for
+// loading its children, the path of the page displaying it, and the
+// path of the page displaying the Tree, if any.
+func (s *BrowserService) getOutputDirectory(ctx context.Context, digestFunction digest.Function, outputDirectory *remoteexecution.OutputDirectory) (*remoteexecution.Directory, directoryGetter, string, string, error) {
+	if outputDirectory.RootDirectoryDigest != nil {
+		rootDirectoryDigest, err := digestFunction.NewDigestFromProto(outputDirectory.RootDirectoryDigest)
+		if err != nil {
+			return nil, nil, "", "", util.StatusWrapf(err, "Invalid root directory digest for output directory %#v", outputDirectory.Path)
+		}
+		rootDirectory, err := s.getDirectory(ctx, rootDirectoryDigest)
+		if err != nil {
+			return nil, nil, "", "", util.StatusWrapf(err, "Failed to obtain root directory of output directory %#v", outputDirectory.Path)
+		}
+		return rootDirectory, s.getDirectory, fmt.Sprintf("directory/%s-%d", rootDirectoryDigest.GetHashString(), rootDirectoryDigest.GetSizeBytes()), "", nil
+	}
+
+	treeDigest, err := digestFunction.NewDigestFromProto(outputDirectory.TreeDigest)
+	if err != nil {
+		return nil, nil, "", "", util.StatusWrapf(err, "Invalid tree digest for output directory %#v", outputDirectory.Path)
+	}
+	index, err := s.messageCache.GetTreeIndex(ctx, treeDigest)
+	if err != nil {
+		return nil, nil, "", "", util.StatusWrapf(err, "Failed to obtain tree of output directory %#v", outputDirectory.Path)
+	}
+	treePath := fmt.Sprintf("tree/%s-%d", treeDigest.GetHashString(), treeDigest.GetSizeBytes())
+	return index.root, index.getDirectory, treePath, treePath, nil
+}
+
+// equalOutputDirectories returns whether two output directories are
+// known to have identical contents, without loading them.
+func equalOutputDirectories(oldOutputDirectory, newOutputDirectory *remoteexecution.OutputDirectory) bool {
+	if oldOutputDirectory.RootDirectoryDigest != nil && newOutputDirectory.RootDirectoryDigest != nil {
+		return equalDigestProtos(oldOutputDirectory.RootDirectoryDigest, newOutputDirectory.RootDirectoryDigest)
+	}
+	return oldOutputDirectory.TreeDigest != nil && equalDigestProtos(oldOutputDirectory.TreeDigest, newOutputDirectory.TreeDigest)
+}
+
+func (s *BrowserService) handleActionResultDiff(w http.ResponseWriter, req *http.Request) {
+	digestFunction, err := getDigestFunctionFromRequest(req)
+	if err != nil {
+		s.renderError(w, req, err)
+		return
+	}
+
+	ctx := extractContextFromRequest(req)
+	oldActionDigest, oldActionResult, oldPath, err := s.getActionResultDiffSide(ctx, req, mux.Vars(req)["typeA"], "hashA", "sizeBytesA")
+	if err != nil {
+		s.renderError(w, req, err)
+		return
+	}
+	newActionDigest, newActionResult, newPath, err := s.getActionResultDiffSide(ctx, req, "historical_execute_response", "hashB", "sizeBytesB")
+	if err != nil {
+		s.renderError(w, req, err)
+		return
+	}
+
+	// Relative links are emitted against the root of the digest
+	// function, which is located at "../../../../..".
+	rootDirectory := "../../../../.."
+	actionResultDiffInfo := actionResultDiffInfo{
+		OldPath:         oldPath,
+		NewPath:         newPath,
+		OldActionDigest: oldActionDigest,
+		NewActionDigest: newActionDigest,
+		Outputs: directoryDiffInfo{
+			RootDirectory: rootDirectory,
+		},
+	}
+
+	for _, field := range []fieldDiff{
+		{Name: "Exit code", Old: strconv.FormatInt(int64(oldActionResult.ExitCode), 10), New: strconv.FormatInt(int64(newActionResult.ExitCode), 10)},
+		{
+			Name: "Standard output",
+			Old:  formatLogDigest(digestFunction, oldActionResult.StdoutDigest, oldActionResult.StdoutRaw),
+			New:  formatLogDigest(digestFunction, newActionResult.StdoutDigest, newActionResult.StdoutRaw),
+		},
+		{
+			Name: "Standard error",
+			Old:  formatLogDigest(digestFunction, oldActionResult.StderrDigest, oldActionResult.StderrRaw),
+			New:  formatLogDigest(digestFunction, newActionResult.StderrDigest, newActionResult.StderrRaw),
+		},
+	} {
+		if field.Old != field.New {
+			actionResultDiffInfo.Fields = append(actionResultDiffInfo.Fields, field)
+		}
+	}
+
+	// Compare output files and symbolic links by path.
+	oldNodes := getOutputDiffNodes(oldActionResult)
+	newNodes := getOutputDiffNodes(newActionResult)
+	for outputPath, oldNode := range oldNodes {
+		newNode := newNodes[outputPath]
+		if newNode == nil ||
+			(oldNode.File != nil && (newNode.File == nil || !equalDigestProtos(oldNode.File.Digest, newNode.File.Digest) || oldNode.File.IsExecutable != newNode.File.IsExecutable)) ||
+			(oldNode.Symlink != nil && (newNode.Symlink == nil || oldNode.Symlink.Target != newNode.Symlink.Target)) {
+			actionResultDiffInfo.Outputs.Entries = append(actionResultDiffInfo.Outputs.Entries, directoryDiffEntry{
+				Path: outputPath,
+				Old:  oldNode,
+				New:  newNode,
+			})
+		}
+	}
+	for outputPath, newNode := range newNodes {
+		if _, ok := oldNodes[outputPath]; !ok {
+			actionResultDiffInfo.Outputs.Entries = append(actionResultDiffInfo.Outputs.Entries, directoryDiffEntry{
+				Path: outputPath,
+				New:  newNode,
+			})
+		}
+	}
+	sort.Slice(actionResultDiffInfo.Outputs.Entries, func(i, j int) bool {
+		return actionResultDiffInfo.Outputs.Entries[i].Path < actionResultDiffInfo.Outputs.Entries[j].Path
+	})
+
+	// Compare the contents of output directories recursively.
+	oldOutputDirectories := map[string]*remoteexecution.OutputDirectory{}
+	for _, outputDirectory := range oldActionResult.OutputDirectories {
+		oldOutputDirectories[outputDirectory.Path] = outputDirectory
+	}
+	newOutputDirectories := map[string]*remoteexecution.OutputDirectory{}
+	for _, outputDirectory := range newActionResult.OutputDirectories {
+		newOutputDirectories[outputDirectory.Path] = outputDirectory
+	}
+	outputDirectoryPaths := make([]string, 0, len(oldOutputDirectories)+len(newOutputDirectories))
+	for outputPath := range oldOutputDirectories {
+		outputDirectoryPaths = append(outputDirectoryPaths, outputPath)
+	}
+	for outputPath := range newOutputDirectories {
+		if _, ok := oldOutputDirectories[outputPath]; !ok {
+			outputDirectoryPaths = append(outputDirectoryPaths, outputPath)
+		}
+	}
+	sort.Strings(outputDirectoryPaths)
+	for _, outputPath := range outputDirectoryPaths {
+		oldOutputDirectory, newOutputDirectory := oldOutputDirectories[outputPath], newOutputDirectories[outputPath]
+		if oldOutputDirectory != nil && newOutputDirectory != nil && equalOutputDirectories(oldOutputDirectory, newOutputDirectory) {
+			continue
+		}
+
+		outputDirectoryDiff := outputDirectoryDiff{
+			Path: outputPath,
+			Diff: directoryDiffInfo{
+				RootDirectory: rootDirectory,
+			},
+		}
+		var oldDirectory, newDirectory *remoteexecution.Directory
+		getOldDirectory, getNewDirectory := s.getDirectory, s.getDirectory
+		if oldOutputDirectory != nil {
+			oldDirectory, getOldDirectory, outputDirectoryDiff.OldPath, outputDirectoryDiff.Diff.OldTreePath, err = s.getOutputDirectory(ctx, digestFunction, oldOutputDirectory)
+			if err != nil {
+				s.renderError(w, req, err)
+				return
+			}
+		}
+		if newOutputDirectory != nil {
+			newDirectory, getNewDirectory, outputDirectoryDiff.NewPath, outputDirectoryDiff.Diff.NewTreePath, err = s.getOutputDirectory(ctx, digestFunction, newOutputDirectory)
+			if err != nil {
+				s.renderError(w, req, err)
+				return
+			}
+		}
+		outputDirectoryDiff.Diff.Entries, outputDirectoryDiff.Diff.DirectoryLimitReached, err = s.diffDirectories(ctx, digestFunction, oldDirectory, newDirectory, getOldDirectory, getNewDirectory)
+		if err != nil {
+			s.renderError(w, req, err)
+			return
+		}
+		actionResultDiffInfo.OutputDirectories = append(actionResultDiffInfo.OutputDirectories, outputDirectoryDiff)
+	}
+
+	if err := s.templates.ExecuteTemplate(w, "page_action_result_diff.html", &actionResultDiffInfo); err != nil {
+		log.Print(err)
+	}
+}
@@ -0,0 +1,268 @@
+package main
+
+import (
+	"archive/tar"
+	"archive/zip"
+	"fmt"
+	"io"
+	"os"
+	"time"
+)
+
+// archiveWriter is used by generateArchive() to write the contents of
+// a directory hierarchy into an archive. It abstracts away the
+// differences between the archive formats that may be downloaded.
+//
+// All paths are relative to the root of the archive, and are provided
+// in the order in which directories are traversed. Parent directories
+// are always written before their children.
+type archiveWriter interface {
+	WriteDirectory(directoryPath string) error
+	WriteSymlink(symlinkPath, target string) error
+	WriteFile(filePath string, sizeBytes int64, isExecutable bool) (io.Writer, error)
+	// WriteDuplicateFile writes a file whose contents and
+	// executable bit are identical to a file that was written
+	// previously. Archive formats that support hardlinks refer to
+	// the original file, so that its contents do not need to be
+	// stored twice. For other formats, a writer is returned to
+	// which the contents of the file need to be written again.
+	WriteDuplicateFile(filePath, originalPath string, sizeBytes int64, isExecutable bool) (io.Writer, error)
+	// WriteError terminates the entry that is currently being
+	// written, and appends a file to the archive that describes an
+	// error that occurred while generating it.
+	WriteError(err error) error
+	Close() error
+}
+
+// archiveErrorFileName is the name of the file that is appended to
+// archives whose generation failed halfway through. It is placed at
+// the root of the archive, so that users can easily spot it.
+const archiveErrorFileName = "BB_BROWSER_ERROR.txt"
+
+// getArchiveErrorFileContents returns the contents of the file that is
+// appended to archives whose generation failed halfway through.
+func getArchiveErrorFileContents(err error) string {
+	return fmt.Sprintf("This archive is incomplete, as an error occurred while generating it:\n\n%s\n\nThe last file preceding this one may have been truncated.\n", err)
+}
+
+// archiveMetadata contains the metadata that is attached to all
+// entries in an archive, as objects stored in the Content Addressable
+// Storage have no modification times or owners.
+type archiveMetadata struct {
+	ModificationTime time.Time
+	UserID           int
+	GroupID          int
+	UserName         string
+	GroupName        string
+}
+
+// reproducibleArchiveMetadata is the metadata that is attached to
+// entries in archives that are generated in reproducible mode. It
+// ensures that downloading the same directory twice yields archives
+// that are byte-for-byte identical.
+var reproducibleArchiveMetadata = archiveMetadata{
+	ModificationTime: time.Unix(0, 0),
+	UserName:         "root",
+	GroupName:        "root",
+}
+
+// tarArchiveWriter is an implementation of archiveWriter that writes
+// a tarball. Duplicate files are emitted as hardlinks.
+//
+// Entries in tarballs are prefixed with their size. The number of bytes
+// that still need to be written for the current file is tracked, so
+// that it can be padded when generating the archive fails.
+type tarArchiveWriter struct {
+	w              *tar.Writer
+	metadata       archiveMetadata
+	remainingBytes int64
+}
+
+func newTarArchiveWriter(w io.Writer, metadata archiveMetadata) archiveWriter {
+	return &tarArchiveWriter{
+		w:        tar.NewWriter(w),
+		metadata: metadata,
+	}
+}
+
+func (aw *tarArchiveWriter) writeHeader(header *tar.Header) error {
+	header.ModTime = aw.metadata.ModificationTime
+	header.Uid = aw.metadata.UserID
+	header.Gid = aw.metadata.GroupID
+	header.Uname = aw.metadata.UserName
+	header.Gname = aw.metadata.GroupName
+	return aw.w.WriteHeader(header)
+}
+
+func (aw *tarArchiveWriter) WriteDirectory(directoryPath string) error {
+	return aw.writeHeader(&tar.Header{
+		Typeflag: tar.TypeDir,
+		Name:     directoryPath,
+		Mode:     0o777,
+	})
+}
+
+func (aw *tarArchiveWriter) WriteSymlink(symlinkPath, target string) error {
+	return aw.writeHeader(&tar.Header{
+		Typeflag: tar.TypeSymlink,
+		Name:     symlinkPath,
+		Linkname: target,
+		Mode:     0o777,
+	})
+}
+
+func (aw *tarArchiveWriter) WriteFile(filePath string, sizeBytes int64, isExecutable bool) (io.Writer, error) {
+	mode := int64(0o666)
+	if isExecutable {
+		mode = 0o777
+	}
+	if err := aw.writeHeader(&tar.Header{
+		Typeflag: tar.TypeReg,
+		Name:     filePath,
+		Size:     sizeBytes,
+		Mode:     mode,
+	}); err != nil {
+		return nil, err
+	}
+	aw.remainingBytes = sizeBytes
+	return aw, nil
+}
+
+// Write the contents of the file that was last created through
+// WriteFile().
+func (aw *tarArchiveWriter) Write(p []byte) (int, error) {
+	n, err := aw.w.Write(p)
+	aw.remainingBytes -= int64(n)
+	return n, err
+}
+
+func (aw *tarArchiveWriter) WriteDuplicateFile(filePath, originalPath string, sizeBytes int64, isExecutable bool) (io.Writer, error) {
+	return nil, aw.writeHeader(&tar.Header{
+		Typeflag: tar.TypeLink,
+		Name:     filePath,
+		Linkname: originalPath,
+	})
+}
+
+func (aw *tarArchiveWriter) WriteError(err error) error {
+	if aw.remainingBytes > 0 {
+		if _, err := io.CopyN(aw, zeroReader{}, aw.remainingBytes); err != nil {
+			return err
+		}
+	}
+	contents := getArchiveErrorFileContents(err)
+	if err := aw.writeHeader(&tar.Header{
+		Typeflag: tar.TypeReg,
+		Name:     archiveErrorFileName,
+		Size:     int64(len(contents)),
+		Mode:     0o666,
+	}); err != nil {
+		return err
+	}
+	_, err = io.WriteString(aw.w, contents)
+	return err
+}
+
+func (aw *tarArchiveWriter) Close() error {
+	return aw.w.Close()
+}
+
+// zipArchiveWriter is an implementation of archiveWriter that writes a
+// ZIP archive. Entries are compressed and written to the output
+// directly, without buffering them.
+//
+// ZIP archives have no support for hardlinks. Duplicate files are
+// therefore emitted as regular files, whose contents are written
+// again. Symbolic links would be smaller, but cannot be extracted on
+// all platforms. File modes, including the executable bit and the type
+// of symbolic links, are stored in the external attributes of entries,
+// as is done by Info-ZIP. ZIP archives cannot store owners.
+type zipArchiveWriter struct {
+	w        *zip.Writer
+	metadata archiveMetadata
+}
+
+func newZIPArchiveWriter(w io.Writer, metadata archiveMetadata) archiveWriter {
+	return &zipArchiveWriter{
+		w:        zip.NewWriter(w),
+		metadata: metadata,
+	}
+}
+
+// minimumZIPModificationTime is the earliest modification time that
+// can be stored in ZIP archives, as MS-DOS timestamps start at 1980.
+var minimumZIPModificationTime = time.Date(1980, 1, 1, 0, 0, 0, 0, time.UTC)
+
+func (aw *zipArchiveWriter) createHeader(fh *zip.FileHeader) (io.Writer, error) {
+	if !aw.metadata.ModificationTime.Before(minimumZIPModificationTime) {
+		fh.Modified = aw.metadata.ModificationTime
+	}
+	return aw.w.CreateHeader(fh)
+}
+
+func (aw *zipArchiveWriter) WriteDirectory(directoryPath string) error {
+	fh := zip.FileHeader{
+		Name:   directoryPath + "/",
+		Method: zip.Store,
+	}
+	fh.SetMode(os.ModeDir | 0o777)
+	_, err := aw.createHeader(&fh)
+	return err
+}
+
+func (aw *zipArchiveWriter) WriteSymlink(symlinkPath, target string) error {
+	fh := zip.FileHeader{
+		Name:   symlinkPath,
+		Method: zip.Store,
+	}
+	fh.SetMode(os.ModeSymlink | 0o777)
+	w, err := aw.createHeader(&fh)
+	if err != nil {
+		return err
+	}
+	_, err = io.WriteString(w, target)
+	return err
+}
+
+func (aw *zipArchiveWriter) WriteFile(filePath string, sizeBytes int64, isExecutable bool) (io.Writer, error) {
+	fh := zip.FileHeader{
+		Name:   filePath,
+		Method: zip.Deflate,
+	}
+	if isExecutable {
+		fh.SetMode(0o777)
+	} else {
+		fh.SetMode(0o666)
+	}
+	return aw.createHeader(&fh)
+}
+
+func (aw *zipArchiveWriter) WriteDuplicateFile(filePath, originalPath string, sizeBytes int64, isExecutable bool) (io.Writer, error) {
+	return aw.WriteFile(filePath, sizeBytes, isExecutable)
+}
+
+func (aw *zipArchiveWriter) WriteError(err error) error {
+	// Entries in ZIP archives are not prefixed with their size, so
+	// the file that is currently being written can simply be left
+	// truncated.
+	contents := getArchiveErrorFileContents(err)
+	w, err := aw.WriteFile(archiveErrorFileName, 0, false)
+	if err != nil {
+		return err
+	}
+	_, err = io.WriteString(w, contents)
+	return err
+}
+
+func (aw *zipArchiveWriter) Close() error {
+	return aw.w.Close()
+}
+
+// zeroReader is an io.Reader that yields an infinite stream of zero
+// bytes. It is used to pad truncated entries in tarballs.
+type zeroReader struct{}
+
+func (zeroReader) Read(p []byte) (int, error) {
+	clear(p)
+	return len(p), nil
+}
@@ -0,0 +1,629 @@
+package main
+
+import (
+	"context"
+	"sort"
+	"sync"
+
+	remoteexecution "github.com/bazelbuild/remote-apis/build/bazel/remote/execution/v2"
+	"github.com/buildbarn/bb-storage/pkg/digest"
+	"github.com/buildbarn/bb-storage/pkg/filesystem/path"
+	"github.com/buildbarn/bb-storage/pkg/util"
+
+	"golang.org/x/sync/errgroup"
+	"golang.org/x/sync/semaphore"
+
+	"google.golang.org/grpc/codes"
+	"google.golang.org/grpc/status"
+)
+
+// archiveLookaheadEntries is the maximum number of entries that are
+// computed ahead of the entry that is currently being written into an
+// archive. Together with the memory budget, it bounds the amount of
+// state that is held while generating an archive.
+const archiveLookaheadEntries = 1000
+
+// archiveMissingBlob is a blob referenced by a directory hierarchy
+// that is not present in the Content Addressable Storage.
+type archiveMissingBlob struct {
+	Path   string
+	Digest digest.Digest
+}
+
+// archiveMissingBlobsInfo contains the information that we display on
+// pages explaining why a directory hierarchy cannot be downloaded as an
+// archive.
+type archiveMissingBlobsInfo struct {
+	MissingBlobs []archiveMissingBlob
+}
+
+// preflightArchive walks a directory hierarchy that is about to be
+// written into an archive, and checks that all blobs it references are
+// present in the Content Addressable Storage. This permits reporting
+// missing blobs and malformed directories before any part of the
+// archive has been sent.
+//
+// Directories are loaded one level at a time, in batches whose total
+// size fits in the memory budget. Directories are discarded as soon as
+// their children have been enumerated, meaning that only the digests of
+// the hierarchy are retained.
+func (s *BrowserService) preflightArchive(ctx context.Context, digestFunction digest.Function, rootDirectory *remoteexecution.Directory, getDirectory directoryGetter) ([]archiveMissingBlob, error) {
+	type directoryToLoad struct {
+		directoryPath *path.Trace
+		digest        digest.Digest
+	}
+	directoriesSeen := map[digest.Digest]struct{}{}
+	filePaths := map[digest.Digest]string{}
+	files := digest.NewSetBuilder()
+	enumerateDirectory := func(directory *remoteexecution.Directory, directoryPath *path.Trace, directoriesToLoad []directoryToLoad) ([]directoryToLoad, error) {
+		for _, directoryNode := range directory.Directories {
+			childName, ok := path.NewComponent(directoryNode.Name)
+			if !ok {
+				return nil, status.Errorf(codes.InvalidArgument, "Directory %#v in directory %#v has an invalid name", directoryNode.Name, directoryPath.GetUNIXString())
+			}
+			childPath := directoryPath.Append(childName)
+			childDigest, err := digestFunction.NewDigestFromProto(directoryNode.Digest)
+			if err != nil {
+				return nil, util.StatusWrapf(err, "Invalid digest for directory %#v", childPath.GetUNIXString())
+			}
+			if _, ok := directoriesSeen[childDigest]; !ok {
+				// Identical directories at other locations
+				// only need to be checked once.
+				directoriesSeen[childDigest] = struct{}{}
+				directoriesToLoad = append(directoriesToLoad, directoryToLoad{
+					directoryPath: childPath,
+					digest:        childDigest,
+				})
+			}
+		}
+		for _, symlinkNode := range directory.Symlinks {
+			if _, ok := path.NewComponent(symlinkNode.Name); !ok {
+				return nil, status.Errorf(codes.InvalidArgument, "Symbolic link %#v in directory %#v has an invalid name", symlinkNode.Name, directoryPath.GetUNIXString())
+			}
+		}
+		for _, fileNode := range directory.Files {
+			childName, ok := path.NewComponent(fileNode.Name)
+			if !ok {
+				return nil, status.Errorf(codes.InvalidArgument, "File %#v in directory %#v has an invalid name", fileNode.Name, directoryPath.GetUNIXString())
+			}
+			childPath := directoryPath.Append(childName)
+			childDigest, err := digestFunction.NewDigestFromProto(fileNode.Digest)
+			if err != nil {
+				return nil, util.StatusWrapf(err, "Invalid digest for file %#v", childPath.GetUNIXString())
+			}
+			if _, ok := filePaths[childDigest]; !ok {
+				filePaths[childDigest] = childPath.GetUNIXString()
+				files.Add(childDigest)
+			}
+		}
+		return directoriesToLoad, nil
+	}
+
+	var missingBlobs []archiveMissingBlob
+	currentLevel, err := enumerateDirectory(rootDirectory, nil, nil)
+	if err != nil {
+		return nil, err
+	}
+	for len(currentLevel) > 0 {
+		var nextLevel []directoryToLoad
+		for len(currentLevel) > 0 {
+			// Load a batch of directories concurrently. The
+			// first directory is always included, so that
+			// progress is made even if it exceeds the budget.
+			batchSizeBytes := currentLevel[0].digest.GetSizeBytes()
+			batchLength := 1
+			for batchLength < len(currentLevel) && batchSizeBytes+currentLevel[batchLength].digest.GetSizeBytes() <= s.archivePrefetchMemoryBudgetBytes {
+				batchSizeBytes += currentLevel[batchLength].digest.GetSizeBytes()
+				batchLength++
+			}
+			batch := currentLevel[:batchLength]
+			currentLevel = currentLevel[batchLength:]
+
+			directories := make([]*remoteexecution.Directory, len(batch))
+			errs := make([]error, len(batch))
+			var group errgroup.Group
+			group.SetLimit(s.archivePrefetchParallelism)
+			for i, d := range batch {
+				group.Go(func() error {
+					directories[i], errs[i] = getDirectory(ctx, d.digest)
+					return nil
+				})
+			}
+			group.Wait()
+			if ctx.Err() != nil {
+				return nil, util.StatusFromContext(ctx)
+			}
+
+			for i, d := range batch {
+				if err := errs[i]; err != nil {
+					if status.Code(err) == codes.NotFound {
+						missingBlobs = append(missingBlobs, archiveMissingBlob{
+							Path:   d.directoryPath.GetUNIXString(),
+							Digest: d.digest,
+						})
+						continue
+					}
+					return nil, util.StatusWrapf(err, "Failed to obtain directory %#v", d.directoryPath.GetUNIXString())
+				}
+				nextLevel, err = enumerateDirectory(directories[i], d.directoryPath, nextLevel)
+				if err != nil {
+					return nil, err
+				}
+				directories[i] = nil
+			}
+		}
+		currentLevel = nextLevel
+	}
+
+	missingFiles, err := s.contentAddressableStorage.FindMissing(ctx, files.Build())
+	if err != nil {
+		return nil, util.StatusWrap(err, "Failed to check for the existence of files")
+	}
+	for _, missingFile := range missingFiles.Items() {
+		missingBlobs = append(missingBlobs, archiveMissingBlob{
+			Path:   filePaths[missingFile],
+			Digest: missingFile,
+		})
+	}
+	sort.Slice(missingBlobs, func(i, j int) bool { return missingBlobs[i].Path < missingBlobs[j].Path })
+	return missingBlobs, nil
+}
+
+type archiveEntryType int
+
+const (
+	archiveEntryTypeDirectory archiveEntryType = iota
+	archiveEntryTypeSymlink
+	archiveEntryTypeFile
+	archiveEntryTypeDuplicateFile
+)
+
+// archiveEntry is a single entry that is written into an archive.
+// Entries are computed ahead of time, so that the contents of files
+// can be prefetched while preceding entries are being written.
+type archiveEntry struct {
+	entryType archiveEntryType
+	path      string
+	// The target of a symbolic link, or the path of the original
+	// file in case of a duplicate file.
+	target string
+	// The digest of a directory or file. Only the contents of
+	// files that are not duplicates are written into the archive.
+	digest       digest.Digest
+	isExecutable bool
+}
+
+// archiveWalker traverses a directory hierarchy in the order in which
+// it is written into archives, reporting entries one at a time. This
+// means that the hierarchy never needs to be held in memory in its
+// entirety.
+//
+// To prevent the traversal from being dominated by round trips to the
+// Content Addressable Storage, all child directories of a directory are
+// loaded concurrently when the directory is entered. They are retained
+// until they have been traversed, and are charged to the memory
+// budget. If the budget does not permit this, child directories are
+// loaded one at a time instead.
+type archiveWalker struct {
+	digestFunction     digest.Function
+	getDirectory       directoryGetter
+	parallelism        int
+	memory             *semaphore.Weighted
+	memoryBudgetBytes  int64
+	maximumDirectories int
+	handleEntry        func(entry *archiveEntry) error
+
+	heldBytes             int64
+	filesSeen             map[string]string
+	directoriesLoaded     int
+	directoryLimitReached bool
+}
+
+// walkArchiveEntries traverses a directory hierarchy, calling
+// handleEntry for every entry that is written into an archive. Memory
+// used by directories that are loaded ahead of time is acquired from
+// the provided semaphore, whose capacity must be equal to the memory
+// budget.
+//
+// If maximumDirectories is positive, no more than that number of
+// directories are loaded. Directories that are not loaded are still
+// reported, but their contents are omitted. The boolean return value
+// indicates whether this happened.
+func (s *BrowserService) walkArchiveEntries(ctx context.Context, digestFunction digest.Function, rootDirectory *remoteexecution.Directory, getDirectory directoryGetter, memory *semaphore.Weighted, maximumDirectories int, handleEntry func(entry *archiveEntry) error) (bool, error) {
+	aw := archiveWalker{
+		digestFunction:     digestFunction,
+		getDirectory:       getDirectory,
+		parallelism:        s.archivePrefetchParallelism,
+		memory:             memory,
+		memoryBudgetBytes:  s.archivePrefetchMemoryBudgetBytes,
+		maximumDirectories: maximumDirectories,
+		handleEntry:        handleEntry,
+		filesSeen:          map[string]string{},
+	}
+	err := aw.walkDirectory(ctx, rootDirectory, nil)
+	return aw.directoryLimitReached, err
+}
+
+// walkDirectory emits the entries of a directory. Child directories
+// are emitted first, followed by symbolic links and regular files.
+func (aw *archiveWalker) walkDirectory(ctx context.Context, directory *remoteexecution.Directory, directoryPath *path.Trace) error {
+	type childDirectory struct {
+		directoryPath *path.Trace
+		digest        digest.Digest
+		directory     *remoteexecution.Directory
+		err           error
+	}
+	children := make([]childDirectory, 0, len(directory.Directories))
+	for _, directoryNode := range directory.Directories {
+		childName, ok := path.NewComponent(directoryNode.Name)
+		if !ok {
+			return status.Errorf(codes.InvalidArgument, "Directory %#v in directory %#v has an invalid name", directoryNode.Name, directoryPath.GetUNIXString())
+		}
+		childPath := directoryPath.Append(childName)
+		childDigest, err := aw.digestFunction.NewDigestFromProto(directoryNode.Digest)
+		if err != nil {
+			return util.StatusWrapf(err, "Invalid digest for directory %#v", childPath.GetUNIXString())
+		}
+		children = append(children, childDirectory{
+			directoryPath: childPath,
+			digest:        childDigest,
+		})
+	}
+
+	// Only load as many child directories as permitted.
+	childrenToLoad := len(children)
+	if aw.maximumDirectories > 0 {
+		if remaining := aw.maximumDirectories - aw.directoriesLoaded; childrenToLoad > remaining {
+			childrenToLoad = remaining
+			aw.directoryLimitReached = true
+		}
+		aw.directoriesLoaded += childrenToLoad
+	}
+	var childrenSizeBytes int64
+	for _, child := range children[:childrenToLoad] {
+		childrenSizeBytes += child.digest.GetSizeBytes()
+	}
+
+	// Load child directories ahead of time if they fit in the
+	// memory budget. The budget is never exceeded by the walker
+	// itself, so that acquiring memory can only block on files that
+	// are prefetched, which are released as the archive is written.
+	preloaded := childrenToLoad > 1 && aw.heldBytes+childrenSizeBytes <= aw.memoryBudgetBytes
+	releaseChild := func(sizeBytes int64) {}
+	if preloaded {
+		if err := aw.memory.Acquire(ctx, childrenSizeBytes); err != nil {
+			return util.StatusFromContext(ctx)
+		}
+		aw.heldBytes += childrenSizeBytes
+		unreleasedBytes := childrenSizeBytes
+		defer func() {
+			aw.memory.Release(unreleasedBytes)
+			aw.heldBytes -= unreleasedBytes
+		}()
+		releaseChild = func(sizeBytes int64) {
+			aw.memory.Release(sizeBytes)
+			aw.heldBytes -= sizeBytes
+			unreleasedBytes -= sizeBytes
+		}
+
+		var group errgroup.Group
+		group.SetLimit(aw.parallelism)
+		for i := range children[:childrenToLoad] {
+			child := &children[i]
+			group.Go(func() error {
+				child.directory, child.err = aw.getDirectory(ctx, child.digest)
+				return nil
+			})
+		}
+		group.Wait()
+	}
+
+	// Emit child directories.
+	for i := range children {
+		child := &children[i]
+		childPathString := child.directoryPath.GetUNIXString()
+		if err := aw.handleEntry(&archiveEntry{
+			entryType: archiveEntryTypeDirectory,
+			path:      childPathString,
+			digest:    child.digest,
+		}); err != nil {
+			return err
+		}
+		if i >= childrenToLoad {
+			continue
+		}
+		childDirectory, err := child.directory, child.err
+		if !preloaded {
+			childDirectory, err = aw.getDirectory(ctx, child.digest)
+		}
+		if err != nil {
+			return util.StatusWrapf(err, "Failed to obtain directory %#v", childPathString)
+		}
+		child.directory = nil
+		err = aw.walkDirectory(ctx, childDirectory, child.directoryPath)
+		releaseChild(child.digest.GetSizeBytes())
+		if err != nil {
+			return err
+		}
+	}
+
+	// Emit symlinks.
+	for _, symlinkNode := range directory.Symlinks {
+		childName, ok := path.NewComponent(symlinkNode.Name)
+		if !ok {
+			return status.Errorf(codes.InvalidArgument, "Symbolic link %#v in directory %#v has an invalid name", symlinkNode.Name, directoryPath.GetUNIXString())
+		}
+		if err := aw.handleEntry(&archiveEntry{
+			entryType: archiveEntryTypeSymlink,
+			path:      directoryPath.Append(childName).GetUNIXString(),
+			target:    symlinkNode.Target,
+		}); err != nil {
+			return err
+		}
+	}
+
+	// Emit regular files.
+	for _, fileNode := range directory.Files {
+		childName, ok := path.NewComponent(fileNode.Name)
+		if !ok {
+			return status.Errorf(codes.InvalidArgument, "File %#v in directory %#v has an invalid name", fileNode.Name, directoryPath.GetUNIXString())
+		}
+		childPathString := directoryPath.Append(childName).GetUNIXString()
+
+		childDigest, err := aw.digestFunction.NewDigestFromProto(fileNode.Digest)
+		if err != nil {
+			return util.StatusWrapf(err, "Invalid digest for file %#v", childPathString)
+		}
+
+		childKey := childDigest.GetKey(digest.KeyWithoutInstance)
+		if fileNode.IsExecutable {
+			childKey += "+x"
+		} else {
+			childKey += "-x"
+		}
+
+		if linkPath, ok := aw.filesSeen[childKey]; ok {
+			// This file was already returned previously.
+			// Emit a link pointing to the first occurrence.
+			//
+			// Not only does this reduce the size of the
+			// archive, it also makes the directory more
+			// representative of what it looks like when
+			// executed through bb_worker.
+			err = aw.handleEntry(&archiveEntry{
+				entryType:    archiveEntryTypeDuplicateFile,
+				path:         childPathString,
+				target:       linkPath,
+				digest:       childDigest,
+				isExecutable: fileNode.IsExecutable,
+			})
+		} else {
+			// This is the first time we're returning this
+			// file. Actually add it to the archive.
+			aw.filesSeen[childKey] = childPathString
+			err = aw.handleEntry(&archiveEntry{
+				entryType:    archiveEntryTypeFile,
+				path:         childPathString,
+				digest:       childDigest,
+				isExecutable: fileNode.IsExecutable,
+			})
+		}
+		if err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// archivePrefetchedFile holds the contents of a file that is
+// prefetched from the Content Addressable Storage, so that it can be
+// written into an archive once all preceding entries have been
+// written. The done channel is closed once data or err is set.
+//
+// If the writer reaches a file before prefetching has started, it
+// claims the file and streams it into the archive directly. This
+// ensures that the writer never waits for memory that can only be
+// released by the writer itself.
+type archivePrefetchedFile struct {
+	done chan struct{}
+	data []byte
+	err  error
+
+	acquireCtx    context.Context
+	cancelAcquire context.CancelFunc
+
+	lock    sync.Mutex
+	started bool
+	claimed bool
+}
+
+// claim attempts to prevent a file from being prefetched. It returns
+// false if prefetching has already started, in which case the caller
+// needs to wait for it to complete.
+func (pf *archivePrefetchedFile) claim() bool {
+	pf.lock.Lock()
+	defer pf.lock.Unlock()
+	if pf.started {
+		return false
+	}
+	pf.claimed = true
+	pf.cancelAcquire()
+	return true
+}
+
+// start marks a file as being prefetched. It returns false if the
+// writer has already claimed it.
+func (pf *archivePrefetchedFile) start() bool {
+	pf.lock.Lock()
+	defer pf.lock.Unlock()
+	if pf.claimed {
+		return false
+	}
+	pf.started = true
+	return true
+}
+
+// archiveQueuedEntry is an entry that has been computed, but not yet
+// written into an archive. If computing further entries failed, err is
+// set instead.
+type archiveQueuedEntry struct {
+	entry          *archiveEntry
+	prefetchedFile *archivePrefetchedFile
+	err            error
+}
+
+// prefetchArchiveFiles loads the contents of files into memory in the
+// order in which they are written into an archive. The number of
+// concurrent requests and the total size of the files that are held
+// in memory are both bounded. Memory is released by the caller after
+// writing a file into the archive.
+func (s *BrowserService) prefetchArchiveFiles(ctx context.Context, wg *sync.WaitGroup, entries <-chan *archiveQueuedEntry, memory *semaphore.Weighted) {
+	parallelism := semaphore.NewWeighted(int64(s.archivePrefetchParallelism))
+	for queuedEntry := range entries {
+		prefetchedFile := queuedEntry.prefetchedFile
+		fileDigest := queuedEntry.entry.digest
+		sizeBytes := fileDigest.GetSizeBytes()
+		if memory.Acquire(prefetchedFile.acquireCtx, sizeBytes) != nil {
+			// Claimed by the writer, or generation of the
+			// archive was terminated.
+			continue
+		}
+		if !prefetchedFile.start() {
+			memory.Release(sizeBytes)
+			continue
+		}
+		if err := parallelism.Acquire(ctx, 1); err != nil {
+			memory.Release(sizeBytes)
+			prefetchedFile.err = util.StatusFromContext(ctx)
+			close(prefetchedFile.done)
+			continue
+		}
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			prefetchedFile.data, prefetchedFile.err = s.contentAddressableStorage.Get(ctx, fileDigest).ToByteSlice(int(sizeBytes))
+			parallelism.Release(1)
+			if prefetchedFile.err != nil {
+				memory.Release(sizeBytes)
+			}
+			close(prefetchedFile.done)
+		}()
+	}
+}
+
+// writeArchiveEntries writes the entries of a directory hierarchy into
+// an archive. Entries are computed while the archive is being written,
+// at most archiveLookaheadEntries ahead of the entry that is currently
+// being written. Files that fit in the memory budget are prefetched
+// concurrently, while larger files are streamed into the archive once
+// all preceding entries have been written. Either way, entries are
+// written in the order in which they are computed. The contents of
+// duplicate files are only streamed into the archive if the archive
+// format does not support hardlinks.
+func (s *BrowserService) writeArchiveEntries(ctx context.Context, w archiveWriter, digestFunction digest.Function, rootDirectory *remoteexecution.Directory, getDirectory directoryGetter) error {
+	// Terminate the traversal and prefetching when returning early,
+	// and wait for all pending requests to complete.
+	var wg sync.WaitGroup
+	defer wg.Wait()
+	ctx, cancel := context.WithCancel(ctx)
+	defer cancel()
+
+	memory := semaphore.NewWeighted(s.archivePrefetchMemoryBudgetBytes)
+	queuedEntries := make(chan *archiveQueuedEntry, archiveLookaheadEntries)
+	queuedFiles := make(chan *archiveQueuedEntry, archiveLookaheadEntries)
+	wg.Add(2)
+	go func() {
+		defer wg.Done()
+		defer close(queuedEntries)
+		defer close(queuedFiles)
+		enqueue := func(queue chan<- *archiveQueuedEntry, queuedEntry *archiveQueuedEntry) error {
+			select {
+			case queue <- queuedEntry:
+				return nil
+			case <-ctx.Done():
+				return util.StatusFromContext(ctx)
+			}
+		}
+		if _, err := s.walkArchiveEntries(ctx, digestFunction, rootDirectory, getDirectory, memory, 0, func(entry *archiveEntry) error {
+			queuedEntry := &archiveQueuedEntry{entry: entry}
+			if entry.entryType == archiveEntryTypeFile && entry.digest.GetSizeBytes() <= s.archivePrefetchMemoryBudgetBytes {
+				prefetchedFile := &archivePrefetchedFile{
+					done: make(chan struct{}),
+				}
+				prefetchedFile.acquireCtx, prefetchedFile.cancelAcquire = context.WithCancel(ctx)
+				queuedEntry.prefetchedFile = prefetchedFile
+			}
+			// Enqueue the entry for the writer before
+			// enqueueing it for prefetching, so that the
+			// writer can always claim files that the
+			// prefetcher is waiting on.
+			if err := enqueue(queuedEntries, queuedEntry); err != nil {
+				return err
+			}
+			if queuedEntry.prefetchedFile != nil {
+				return enqueue(queuedFiles, queuedEntry)
+			}
+			return nil
+		}); err != nil {
+			enqueue(queuedEntries, &archiveQueuedEntry{err: err})
+		}
+	}()
+	go func() {
+		defer wg.Done()
+		s.prefetchArchiveFiles(ctx, &wg, queuedFiles, memory)
+	}()
+
+	for queuedEntry := range queuedEntries {
+		if queuedEntry.err != nil {
+			return queuedEntry.err
+		}
+		entry := queuedEntry.entry
+		switch entry.entryType {
+		case archiveEntryTypeDirectory:
+			if err := w.WriteDirectory(entry.path); err != nil {
+				return err
+			}
+		case archiveEntryTypeSymlink:
+			if err := w.WriteSymlink(entry.path, entry.target); err != nil {
+				return err
+			}
+		case archiveEntryTypeFile:
+			sizeBytes := entry.digest.GetSizeBytes()
+			if prefetchedFile := queuedEntry.prefetchedFile; prefetchedFile != nil && !prefetchedFile.claim() {
+				<-prefetchedFile.done
+				prefetchedFile.cancelAcquire()
+				if prefetchedFile.err != nil {
+					return util.StatusWrapf(prefetchedFile.err, "Failed to obtain file %#v", entry.path)
+				}
+				fileWriter, err := w.WriteFile(entry.path, sizeBytes, entry.isExecutable)
+				if err != nil {
+					return err
+				}
+				if _, err := fileWriter.Write(prefetchedFile.data); err != nil {
+					return err
+				}
+				prefetchedFile.data = nil
+				memory.Release(sizeBytes)
+			} else {
+				fileWriter, err := w.WriteFile(entry.path, sizeBytes, entry.isExecutable)
+				if err != nil {
+					return err
+				}
+				if err := s.contentAddressableStorage.Get(ctx, entry.digest).IntoWriter(fileWriter); err != nil {
+					return util.StatusWrapf(err, "Failed to obtain file %#v", entry.path)
+				}
+			}
+		case archiveEntryTypeDuplicateFile:
+			fileWriter, err := w.WriteDuplicateFile(entry.path, entry.target, entry.digest.GetSizeBytes(), entry.isExecutable)
+			if err != nil {
+				return err
+			}
+			if fileWriter != nil {
+				if err := s.contentAddressableStorage.Get(ctx, entry.digest).IntoWriter(fileWriter); err != nil {
+					return util.StatusWrapf(err, "Failed to obtain file %#v", entry.path)
+				}
+			}
+		}
+	}
+	return nil
+}
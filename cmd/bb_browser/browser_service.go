@@ -1,21 +1,23 @@
 package main
 
 import (
-	"archive/tar"
 	"bufio"
 	"compress/gzip"
 	"context"
-	"errors"
 	"fmt"
 	"html/template"
 	"image/color"
 	"io"
 	"log"
 	"math/rand"
+	"mime"
 	"net/http"
+	"net/url"
 	"sort"
 	"strconv"
 	"strings"
+	"sync"
+	"time"
 	"unicode/utf8"
 
 	remoteexecution "github.com/bazelbuild/remote-apis/build/bazel/remote/execution/v2"
@@ -30,15 +32,17 @@ import (
 	"github.com/buildbarn/bb-storage/pkg/proto/fsac"
 	"github.com/buildbarn/bb-storage/pkg/proto/iscc"
 	"github.com/buildbarn/bb-storage/pkg/util"
+	"github.com/buildbarn/bb-storage/pkg/zstd"
 	"github.com/buildkite/terminal-to-html"
 	"github.com/gorilla/mux"
 	"github.com/kballard/go-shellquote"
+	"github.com/prometheus/client_golang/prometheus"
 
+	status_pb "google.golang.org/genproto/googleapis/rpc/status"
 	"google.golang.org/grpc/codes"
 	"google.golang.org/grpc/metadata"
 	"google.golang.org/grpc/status"
 	"google.golang.org/protobuf/encoding/protojson"
-	"google.golang.org/protobuf/proto"
 
 	"gonum.org/v1/plot"
 	"gonum.org/v1/plot/plotter"
@@ -54,27 +58,39 @@ func init() {
 	}
 }
 
-func getDigestFromRequest(req *http.Request) (digest.Digest, error) {
+func getDigestFunctionFromRequest(req *http.Request) (digest.Function, error) {
 	vars := mux.Vars(req)
 	instanceNameStr := strings.TrimSuffix(vars["instanceName"], "/")
 	instanceName, err := digest.NewInstanceName(instanceNameStr)
 	if err != nil {
-		return digest.BadDigest, util.StatusWrapf(err, "Invalid instance name %#v", instanceNameStr)
+		return digest.Function{}, util.StatusWrapf(err, "Invalid instance name %#v", instanceNameStr)
 	}
 	digestFunctionStr := vars["digestFunction"]
 	digestFunctionEnum, ok := digestFunctionStrings[digestFunctionStr]
 	if !ok {
-		return digest.BadDigest, status.Errorf(codes.InvalidArgument, "Unknown digest function %#v", digestFunctionStr)
+		return digest.Function{}, status.Errorf(codes.InvalidArgument, "Unknown digest function %#v", digestFunctionStr)
 	}
-	digestFunction, err := instanceName.GetDigestFunction(digestFunctionEnum, 0)
+	return instanceName.GetDigestFunction(digestFunctionEnum, 0)
+}
+
+// getDigestFromRequestVars obtains a digest from a request, using the
+// provided route variables to obtain the hash and size. This can be
+// used by pages that take multiple digests, such as diffs.
+func getDigestFromRequestVars(req *http.Request, hashVar, sizeBytesVar string) (digest.Digest, error) {
+	digestFunction, err := getDigestFunctionFromRequest(req)
 	if err != nil {
 		return digest.BadDigest, err
 	}
-	sizeBytes, err := strconv.ParseInt(vars["sizeBytes"], 10, 64)
+	vars := mux.Vars(req)
+	sizeBytes, err := strconv.ParseInt(vars[sizeBytesVar], 10, 64)
 	if err != nil {
-		return digest.BadDigest, util.StatusWrapf(err, "Invalid blob size %#v", vars["sizeBytes"])
+		return digest.BadDigest, util.StatusWrapf(err, "Invalid blob size %#v", vars[sizeBytesVar])
 	}
-	return digestFunction.NewDigest(vars["hash"], sizeBytes)
+	return digestFunction.NewDigest(vars[hashVar], sizeBytes)
+}
+
+func getDigestFromRequest(req *http.Request) (digest.Digest, error) {
+	return getDigestFromRequestVars(req, "hash", "sizeBytes")
 }
 
 // Generates a Context from an incoming HTTP request, forwarding any
@@ -98,28 +114,67 @@ type BrowserService struct {
 	actionCache                  blobstore.BlobAccess
 	initialSizeClassCache        blobstore.BlobAccess
 	fileSystemAccessCache        blobstore.BlobAccess
+	messageCache                 *messageCache
 	maximumMessageSizeBytes      int
+	maximumFileDiffSizeBytes     int
+	zstdPool                     zstd.Pool
 	templates                    *template.Template
 	bbClientdInstanceNamePatcher digest.InstanceNamePatcher
+
+	archivePrefetchParallelism       int
+	archivePrefetchMemoryBudgetBytes int64
+
+	maximumSearchDirectories int
+	maximumSearchResults     int
+	maximumGrepSizeBytes     int64
+	grepParallelism          int
+
+	maximumInlineLogSizeBytes int64
 }
 
 // NewBrowserService constructs a BrowserService that accesses storage
-// through a set of handles.
-func NewBrowserService(contentAddressableStorage, actionCache, initialSizeClassCache, fileSystemAccessCache blobstore.BlobAccess, maximumMessageSizeBytes int, templates *template.Template, bbClientdInstanceNamePatcher digest.InstanceNamePatcher, router *mux.Router) *BrowserService {
+// through a set of handles. Protobuf messages stored in the Content
+// Addressable Storage are read through a message cache.
+func NewBrowserService(contentAddressableStorage, actionCache, initialSizeClassCache, fileSystemAccessCache blobstore.BlobAccess, messageCache *messageCache, maximumMessageSizeBytes, maximumFileDiffSizeBytes int, zstdPool zstd.Pool, archivePrefetchParallelism int, archivePrefetchMemoryBudgetBytes int64, maximumSearchDirectories, maximumSearchResults int, maximumGrepSizeBytes int64, grepParallelism int, maximumInlineLogSizeBytes int64, templates *template.Template, bbClientdInstanceNamePatcher digest.InstanceNamePatcher, router *mux.Router) *BrowserService {
+	browserServicePrometheusMetrics.Do(func() {
+		prometheus.MustRegister(browserServiceFetchDurationSeconds)
+	})
+
 	s := &BrowserService{
 		contentAddressableStorage:    contentAddressableStorage,
 		actionCache:                  actionCache,
 		initialSizeClassCache:        initialSizeClassCache,
 		fileSystemAccessCache:        fileSystemAccessCache,
+		messageCache:                 messageCache,
 		maximumMessageSizeBytes:      maximumMessageSizeBytes,
+		maximumFileDiffSizeBytes:     maximumFileDiffSizeBytes,
+		zstdPool:                     zstdPool,
 		templates:                    templates,
 		bbClientdInstanceNamePatcher: bbClientdInstanceNamePatcher,
+
+		archivePrefetchParallelism:       archivePrefetchParallelism,
+		archivePrefetchMemoryBudgetBytes: archivePrefetchMemoryBudgetBytes,
+
+		maximumSearchDirectories: maximumSearchDirectories,
+		maximumSearchResults:     maximumSearchResults,
+		maximumGrepSizeBytes:     maximumGrepSizeBytes,
+		grepParallelism:          grepParallelism,
+
+		maximumInlineLogSizeBytes: maximumInlineLogSizeBytes,
 	}
 	router.HandleFunc("/", s.handleWelcome)
 	router.HandleFunc("/{instanceName:(?:.*?/)?}blobs/{digestFunction}/action/{hash}-{sizeBytes}/", s.handleAction)
+	router.HandleFunc("/{instanceName:(?:.*?/)?}blobs/{digestFunction}/action/{hash}-{sizeBytes}/input/{path:.*}", s.handleActionInput)
+	router.HandleFunc("/{instanceName:(?:.*?/)?}blobs/{digestFunction}/action/{hash}-{sizeBytes}/output/{path:.*}", s.handleActionOutput)
+	router.HandleFunc("/{instanceName:(?:.*?/)?}blobs/{digestFunction}/{pageType:action|historical_execute_response}/{hash}-{sizeBytes}/log/{log:stdout|stderr}/", s.handleActionLog)
+	router.HandleFunc("/{instanceName:(?:.*?/)?}blobs/{digestFunction}/action_diff/{hashA}-{sizeBytesA}/{hashB}-{sizeBytesB}/", s.handleActionDiff)
+	router.HandleFunc("/{instanceName:(?:.*?/)?}blobs/{digestFunction}/action_result_diff/{typeA:action|historical_execute_response}/{hashA}-{sizeBytesA}/historical_execute_response/{hashB}-{sizeBytesB}/", s.handleActionResultDiff)
 	router.HandleFunc("/{instanceName:(?:.*?/)?}blobs/{digestFunction}/command/{hash}-{sizeBytes}/", s.handleCommand)
 	router.HandleFunc("/{instanceName:(?:.*?/)?}blobs/{digestFunction}/directory/{hash}-{sizeBytes}/", s.handleDirectory)
+	router.HandleFunc("/{instanceName:(?:.*?/)?}blobs/{digestFunction}/directory_diff/{typeA:directory|tree}/{hashA}-{sizeBytesA}/{typeB:directory|tree}/{hashB}-{sizeBytesB}/", s.handleDirectoryDiff)
 	router.HandleFunc("/{instanceName:(?:.*?/)?}blobs/{digestFunction}/file/{hash}-{sizeBytes}/{name}", s.handleFile)
+	router.HandleFunc("/{instanceName:(?:.*?/)?}blobs/{digestFunction}/file_diff/{hashA}-{sizeBytesA}/{hashB}-{sizeBytesB}/", s.handleFileDiff)
+	router.HandleFunc("/{instanceName:(?:.*?/)?}blobs/{digestFunction}/log/{hash}-{sizeBytes}/", s.handleLog)
 	router.HandleFunc("/{instanceName:(?:.*?/)?}blobs/{digestFunction}/previous_execution_stats/{hash}-{sizeBytes}/", s.handlePreviousExecutionStats)
 	router.HandleFunc("/{instanceName:(?:.*?/)?}blobs/{digestFunction}/tree/{hash}-{sizeBytes}/{subdirectory:(?:.*/)?}", s.handleTree)
 	router.HandleFunc("/{instanceName:(?:.*?/)?}blobs/{digestFunction}/historical_execute_response/{hash}-{sizeBytes}/", s.handleHistoricalExecuteResponse)
@@ -134,8 +189,40 @@ var (
 	treeDirectoryComponent      = path.MustNewComponent("tree")
 )
 
-func (s *BrowserService) renderError(w http.ResponseWriter, err error) {
+// isJSONRequested returns whether the client requested a
+// machine-readable response, either by providing query parameter
+// "format=json" or by sending an "Accept: application/json" header. An
+// explicitly provided format always takes precedence over the Accept
+// header, so that tarballs and shell scripts can still be downloaded.
+// Recursive listings requested with "format=list_json" and search
+// results requested with "format=search_json" or "format=grep_json" are
+// also machine-readable.
+func isJSONRequested(req *http.Request) bool {
+	if format := req.URL.Query().Get("format"); format != "" {
+		return format == "json" || format == "list_json" || format == "search_json" || format == "grep_json"
+	}
+	for _, mediaRange := range strings.Split(req.Header.Get("Accept"), ",") {
+		if mediaType, _, err := mime.ParseMediaType(mediaRange); err == nil && mediaType == "application/json" {
+			return true
+		}
+	}
+	return false
+}
+
+func (s *BrowserService) renderError(w http.ResponseWriter, req *http.Request, err error) {
 	st := status.Convert(err)
+	if isJSONRequested(req) {
+		// Return the error as a google.rpc.Status message.
+		data, err := protojson.Marshal(st.Proto())
+		if err != nil {
+			log.Print(err)
+			panic(http.ErrAbortHandler)
+		}
+		w.Header().Set("Content-Type", "application/json")
+		w.WriteHeader(http_server.StatusCodeFromGRPCCode(st.Code()))
+		w.Write(data)
+		return
+	}
 	w.WriteHeader(http_server.StatusCodeFromGRPCCode(st.Code()))
 	w.Header().Set("X-Content-Type-Options", "nosniff")
 	if err := s.templates.ExecuteTemplate(w, "error.html", st); err != nil {
@@ -143,6 +230,35 @@ func (s *BrowserService) renderError(w http.ResponseWriter, err error) {
 	}
 }
 
+// renderJSON writes a response of a page in machine-readable form,
+// using the canonical Protobuf JSON mapping.
+func (s *BrowserService) renderJSON(w http.ResponseWriter, req *http.Request, response *query.Response) {
+	s.renderJSONWithStatusCode(w, req, http.StatusOK, response)
+}
+
+// renderJSONWithStatusCode is identical to renderJSON, except that it
+// permits returning a page that only partially loaded with an HTTP
+// status code indicating failure.
+func (s *BrowserService) renderJSONWithStatusCode(w http.ResponseWriter, req *http.Request, statusCode int, response *query.Response) {
+	data, err := protojson.Marshal(response)
+	if err != nil {
+		s.renderError(w, req, err)
+		return
+	}
+	w.Header().Set("Content-Type", "application/json")
+	w.WriteHeader(statusCode)
+	w.Write(data)
+}
+
+// getDigestProto converts a digest to its Protobuf representation,
+// returning nil if the digest is not set.
+func getDigestProto(d digest.Digest) *remoteexecution.Digest {
+	if d == digest.BadDigest {
+		return nil
+	}
+	return d.GetProto()
+}
+
 // getBBClientdBlobPath returns a relative path of the shape
 // "${instanceName}/blobs/${digestFunction}/${blobType}/${hash}-${sizeBytes}".
 // This corresponds to the pathname scheme that can be used to access
@@ -189,12 +305,24 @@ type commandInfo struct {
 	BBClientdPath string
 }
 
+func (ci *commandInfo) toProto() *query.CommandInfo {
+	if ci == nil {
+		return nil
+	}
+	return &query.CommandInfo{
+		Digest:        getDigestProto(ci.Digest),
+		Command:       ci.Command,
+		BbClientdPath: ci.BBClientdPath,
+	}
+}
+
 type directoryInfo struct {
 	Digest                           digest.Digest
 	Directory                        *remoteexecution.Directory
 	BBClientdPath                    string
 	FileSystemAccessProfileReference *query.FileSystemAccessProfileReference
 	BloomFilter                      *access.BloomFilterReader
+	Listing                          *directoryListing
 }
 
 // GetChildPathHashes returns path hashes for a file or directory
@@ -223,56 +351,181 @@ func (di *directoryInfo) GetChildFileSystemAccessProfileReference(pathHashes acc
 	}
 }
 
+func (di *directoryInfo) toProto() *query.DirectoryInfo {
+	if di == nil {
+		return nil
+	}
+	directoryInfo := &query.DirectoryInfo{
+		Digest:                           getDigestProto(di.Digest),
+		Directory:                        di.Directory,
+		BbClientdPath:                    di.BBClientdPath,
+		FileSystemAccessProfileReference: di.FileSystemAccessProfileReference,
+	}
+	if di.BloomFilter != nil {
+		for _, directoryNode := range di.Directory.Directories {
+			if pathHashes := di.GetChildPathHashes(directoryNode.Name); pathHashes != nil && di.BloomFilter.Contains(*pathHashes) {
+				directoryInfo.PrefetchedNames = append(directoryInfo.PrefetchedNames, directoryNode.Name)
+			}
+		}
+		for _, fileNode := range di.Directory.Files {
+			if pathHashes := di.GetChildPathHashes(fileNode.Name); pathHashes != nil && di.BloomFilter.Contains(*pathHashes) {
+				directoryInfo.PrefetchedNames = append(directoryInfo.PrefetchedNames, fileNode.Name)
+			}
+		}
+	}
+	return directoryInfo
+}
+
 type logInfo struct {
 	Name     string
 	Digest   digest.Digest
 	TooLarge bool
 	NotFound bool
+	Contents []byte
 	HTML     template.HTML
+
+	// The link to the page on which the log can be searched,
+	// relative to the page of the action.
+	SearchURL string
+}
+
+// HasDigest returns whether the log is stored in the Content
+// Addressable Storage. Logs that are only provided inline have no
+// digest.
+func (li *logInfo) HasDigest() bool {
+	return li.Digest != digest.BadDigest
+}
+
+func (li *logInfo) toProto() *query.LogInfo {
+	if li == nil {
+		return nil
+	}
+	return &query.LogInfo{
+		Name:     li.Name,
+		Digest:   getDigestProto(li.Digest),
+		TooLarge: li.TooLarge,
+		NotFound: li.NotFound,
+		Contents: li.Contents,
+	}
+}
+
+type actionInfo struct {
+	IsHistoricalExecuteResponse bool
+	ActionDigest                digest.Digest
+	Action                      *remoteexecution.Action
+
+	Command *commandInfo
+
+	ExecuteResponse *remoteexecution.ExecuteResponse
+	StdoutInfo      *logInfo
+	StderrInfo      *logInfo
+
+	InputRoot *directoryInfo
+
+	OutputDirectories []*remoteexecution.OutputDirectory
+	OutputSymlinks    []*remoteexecution.OutputSymlink
+	OutputFiles       []*remoteexecution.OutputFile
+	MissingPaths      []string
+
+	PreviousExecutionStats *previousExecutionStatsInfo
+
+	// Errors that occurred while loading sections of the page,
+	// keyed by section name.
+	Errors map[string]*status.Status
+}
+
+// Names of sections of action pages. Errors are reported separately for
+// each section, so that a failure to load one of them doesn't prevent
+// the other sections from being displayed.
+const (
+	actionSectionAction                  = "action"
+	actionSectionActionResult            = "action_result"
+	actionSectionStdout                  = "stdout"
+	actionSectionStderr                  = "stderr"
+	actionSectionCommand                 = "command"
+	actionSectionInputRoot               = "input_root"
+	actionSectionFileSystemAccessProfile = "file_system_access_profile"
+	actionSectionPreviousExecutionStats  = "previous_execution_stats"
+)
+
+// criticalActionSections are the sections of action pages that cause
+// the HTTP status code to indicate failure if they failed to load.
+var criticalActionSections = []string{
+	actionSectionAction,
+	actionSectionActionResult,
+}
+
+func (ai *actionInfo) toProto() *query.ActionInfo {
+	var errors map[string]*status_pb.Status
+	if len(ai.Errors) > 0 {
+		errors = make(map[string]*status_pb.Status, len(ai.Errors))
+		for section, st := range ai.Errors {
+			errors[section] = st.Proto()
+		}
+	}
+	return &query.ActionInfo{
+		ActionDigest:                getDigestProto(ai.ActionDigest),
+		IsHistoricalExecuteResponse: ai.IsHistoricalExecuteResponse,
+		Action:                      ai.Action,
+		Command:                     ai.Command.toProto(),
+		ExecuteResponse:             ai.ExecuteResponse,
+		Stdout:                      ai.StdoutInfo.toProto(),
+		Stderr:                      ai.StderrInfo.toProto(),
+		InputRoot:                   ai.InputRoot.toProto(),
+		MissingPaths:                ai.MissingPaths,
+		PreviousExecutionStats:      ai.PreviousExecutionStats.toProto(),
+		Errors:                      errors,
+	}
 }
 
 func (s *BrowserService) handleAction(w http.ResponseWriter, req *http.Request) {
 	digest, err := getDigestFromRequest(req)
 	if err != nil {
-		s.renderError(w, err)
+		s.renderError(w, req, err)
 		return
 	}
 
-	ctx := extractContextFromRequest(req)
-	var actionResult *remoteexecution.ActionResult
-	if m, err := s.actionCache.Get(ctx, digest).ToProto(
-		&remoteexecution.ActionResult{},
-		s.maximumMessageSizeBytes); err == nil {
-		actionResult = m.(*remoteexecution.ActionResult)
-	} else if status.Code(err) != codes.NotFound {
-		s.renderError(w, err)
-		return
-	}
-
-	s.handleActionCommon(w, req, digest, &remoteexecution.ExecuteResponse{
-		Result: actionResult,
-	}, false)
+	timings := newFetchTimings("action")
+	s.handleActionCommon(w, req, digest, func(ctx context.Context) (*remoteexecution.ExecuteResponse, error) {
+		defer timings.record("action_result", time.Now())
+		var actionResult *remoteexecution.ActionResult
+		if m, err := s.actionCache.Get(ctx, digest).ToProto(
+			&remoteexecution.ActionResult{},
+			s.maximumMessageSizeBytes); err == nil {
+			actionResult = m.(*remoteexecution.ActionResult)
+		} else if status.Code(err) != codes.NotFound {
+			return nil, err
+		}
+		return &remoteexecution.ExecuteResponse{
+			Result: actionResult,
+		}, nil
+	}, false, timings)
 }
 
 func (s *BrowserService) handleHistoricalExecuteResponse(w http.ResponseWriter, req *http.Request) {
 	digest, err := getDigestFromRequest(req)
 	if err != nil {
-		s.renderError(w, err)
+		s.renderError(w, req, err)
 		return
 	}
 	ctx := extractContextFromRequest(req)
-	m, err := s.contentAddressableStorage.Get(ctx, digest).ToProto(&cas_proto.HistoricalExecuteResponse{}, s.maximumMessageSizeBytes)
+	timings := newFetchTimings("historical_execute_response")
+	start := time.Now()
+	m, err := s.messageCache.Get(ctx, digest, &cas_proto.HistoricalExecuteResponse{})
+	timings.record("historical_execute_response", start)
 	if err != nil {
-		s.renderError(w, err)
+		s.renderError(w, req, err)
 		return
 	}
 	historicalExecuteResponse := m.(*cas_proto.HistoricalExecuteResponse)
 	actionDigest, err := digest.GetDigestFunction().NewDigestFromProto(historicalExecuteResponse.ActionDigest)
 	if err != nil {
-		s.renderError(w, err)
+		s.renderError(w, req, err)
 		return
 	}
-	s.handleActionCommon(w, req, actionDigest, historicalExecuteResponse.ExecuteResponse, true)
+	s.handleActionCommon(w, req, actionDigest, func(ctx context.Context) (*remoteexecution.ExecuteResponse, error) {
+		return historicalExecuteResponse.ExecuteResponse, nil
+	}, true, timings)
 }
 
 func (s *BrowserService) getLogInfoFromActionResult(ctx context.Context, name string, digestFunction digest.Function, logDigest *remoteexecution.Digest, rawLogBody []byte) (*logInfo, error) {
@@ -288,9 +541,10 @@ func (s *BrowserService) getLogInfoFromActionResult(ctx context.Context, name st
 	if len(rawLogBody) > 0 {
 		// Log body is small enough to be provided inline.
 		return &logInfo{
-			Name:   name,
-			Digest: blobDigest,
-			HTML:   template.HTML(terminal.Render(rawLogBody)),
+			Name:     name,
+			Digest:   blobDigest,
+			Contents: rawLogBody,
+			HTML:     template.HTML(terminal.Render(rawLogBody)),
 		}, nil
 	} else if logDigest != nil {
 		// Load the log from the Content Addressable Storage.
@@ -300,11 +554,10 @@ func (s *BrowserService) getLogInfoFromActionResult(ctx context.Context, name st
 }
 
 func (s *BrowserService) getLogInfoForDigest(ctx context.Context, name string, digest digest.Digest) (*logInfo, error) {
-	maximumLogSizeBytes := 100000
 	if size := digest.GetSizeBytes(); size == 0 {
 		// No log file present.
 		return nil, nil
-	} else if size > int64(maximumLogSizeBytes) {
+	} else if size > s.maximumInlineLogSizeBytes {
 		// Log file too large to show inline.
 		return &logInfo{
 			Name:     name,
@@ -313,13 +566,14 @@ func (s *BrowserService) getLogInfoForDigest(ctx context.Context, name string, d
 		}, nil
 	}
 
-	data, err := s.contentAddressableStorage.Get(ctx, digest).ToByteSlice(maximumLogSizeBytes)
+	data, err := s.contentAddressableStorage.Get(ctx, digest).ToByteSlice(int(s.maximumInlineLogSizeBytes))
 	if err == nil {
 		// Log found. Convert ANSI escape sequences to HTML.
 		return &logInfo{
-			Name:   name,
-			Digest: digest,
-			HTML:   template.HTML(terminal.Render(data)),
+			Name:     name,
+			Digest:   digest,
+			Contents: data,
+			HTML:     template.HTML(terminal.Render(data)),
 		}, nil
 	} else if status.Code(err) == codes.NotFound {
 		// Not found.
@@ -332,170 +586,282 @@ func (s *BrowserService) getLogInfoForDigest(ctx context.Context, name string, d
 	return nil, err
 }
 
-func (s *BrowserService) handleActionCommon(w http.ResponseWriter, req *http.Request, actionDigest digest.Digest, executeResponse *remoteexecution.ExecuteResponse, isHistoricalExecuteResponse bool) {
-	actionInfo := struct {
-		IsHistoricalExecuteResponse bool
-		ActionDigest                digest.Digest
-		Action                      *remoteexecution.Action
-
-		Command *commandInfo
-
-		ExecuteResponse *remoteexecution.ExecuteResponse
-		StdoutInfo      *logInfo
-		StderrInfo      *logInfo
-
-		InputRoot *directoryInfo
-
-		OutputDirectories []*remoteexecution.OutputDirectory
-		OutputSymlinks    []*remoteexecution.OutputSymlink
-		OutputFiles       []*remoteexecution.OutputFile
-		MissingPaths      []string
-
-		PreviousExecutionStats *previousExecutionStatsInfo
-	}{
+// handleActionCommon renders a page displaying an action and its
+// result. Independent objects are fetched concurrently, so that
+// rendering the page does not take the sum of all round trips to
+// storage. Objects that cannot be found are omitted from the page.
+//
+// Errors are reported for each section of the page individually. The
+// HTTP status code only indicates failure if the action or its result
+// could not be loaded.
+func (s *BrowserService) handleActionCommon(w http.ResponseWriter, req *http.Request, actionDigest digest.Digest, getExecuteResponse func(ctx context.Context) (*remoteexecution.ExecuteResponse, error), isHistoricalExecuteResponse bool, timings *fetchTimings) {
+	actionInfo := actionInfo{
 		IsHistoricalExecuteResponse: isHistoricalExecuteResponse,
 		ActionDigest:                actionDigest,
-		ExecuteResponse:             executeResponse,
+		Errors:                      map[string]*status.Status{},
 	}
 
 	ctx := extractContextFromRequest(req)
-	actionResult := executeResponse.GetResult()
 	digestFunction := actionDigest.GetDigestFunction()
-	if actionResult != nil {
-		actionInfo.OutputDirectories = actionResult.OutputDirectories
-		actionInfo.OutputSymlinks = actionResult.OutputSymlinks
-		actionInfo.OutputFiles = actionResult.OutputFiles
+	var errorsLock sync.Mutex
+	setError := func(section string, err error) {
+		errorsLock.Lock()
+		actionInfo.Errors[section] = status.Convert(err)
+		errorsLock.Unlock()
+	}
+	var wg sync.WaitGroup
 
-		var err error
-		actionInfo.StdoutInfo, err = s.getLogInfoFromActionResult(ctx, "Standard output", digestFunction, actionResult.StdoutDigest, actionResult.StdoutRaw)
+	// Fetch the action result and its logs.
+	var actionResult *remoteexecution.ActionResult
+	wg.Go(func() {
+		executeResponse, err := getExecuteResponse(ctx)
 		if err != nil {
-			s.renderError(w, err)
+			setError(actionSectionActionResult, err)
 			return
 		}
-		actionInfo.StderrInfo, err = s.getLogInfoFromActionResult(ctx, "Standard error", digestFunction, actionResult.StderrDigest, actionResult.StderrRaw)
-		if err != nil {
-			s.renderError(w, err)
+		actionInfo.ExecuteResponse = executeResponse
+		actionResult = executeResponse.GetResult()
+		if actionResult == nil {
 			return
 		}
-	}
-
-	actionMessage, err := s.contentAddressableStorage.Get(ctx, actionDigest).ToProto(&remoteexecution.Action{}, s.maximumMessageSizeBytes)
-	if err == nil {
-		action := actionMessage.(*remoteexecution.Action)
-		actionInfo.Action = action
+		actionInfo.OutputDirectories = actionResult.OutputDirectories
+		actionInfo.OutputSymlinks = actionResult.OutputSymlinks
+		actionInfo.OutputFiles = actionResult.OutputFiles
 
-		commandDigest, err := digestFunction.NewDigestFromProto(action.CommandDigest)
-		if err != nil {
-			s.renderError(w, err)
-			return
-		}
-		commandMessage, err := s.contentAddressableStorage.Get(ctx, commandDigest).ToProto(&remoteexecution.Command{}, s.maximumMessageSizeBytes)
-		if err == nil {
-			command := commandMessage.(*remoteexecution.Command)
-			actionInfo.Command = &commandInfo{
-				Digest:        commandDigest,
-				Command:       command,
-				BBClientdPath: formatBBClientdPath(s.getBBClientdBlobPath(commandDigest, commandDirectoryComponent)),
+		wg.Go(func() {
+			defer timings.record("stdout", time.Now())
+			stdoutInfo, err := s.getLogInfoFromActionResult(ctx, "Standard output", digestFunction, actionResult.StdoutDigest, actionResult.StdoutRaw)
+			if err != nil {
+				setError(actionSectionStdout, err)
+				return
 			}
-
-			foundPaths := map[string]struct{}{}
-			for _, outputDirectory := range actionInfo.OutputDirectories {
-				foundPaths[outputDirectory.Path] = struct{}{}
+			if stdoutInfo != nil {
+				stdoutInfo.SearchURL = "log/stdout/"
 			}
-			for _, outputSymlinks := range actionInfo.OutputSymlinks {
-				foundPaths[outputSymlinks.Path] = struct{}{}
+			actionInfo.StdoutInfo = stdoutInfo
+		})
+		wg.Go(func() {
+			defer timings.record("stderr", time.Now())
+			stderrInfo, err := s.getLogInfoFromActionResult(ctx, "Standard error", digestFunction, actionResult.StderrDigest, actionResult.StderrRaw)
+			if err != nil {
+				setError(actionSectionStderr, err)
+				return
 			}
-			for _, outputFiles := range actionInfo.OutputFiles {
-				foundPaths[outputFiles.Path] = struct{}{}
+			if stderrInfo != nil {
+				stderrInfo.SearchURL = "log/stderr/"
 			}
-			for _, outputPath := range command.OutputPaths {
-				if _, ok := foundPaths[outputPath]; !ok {
-					actionInfo.MissingPaths = append(actionInfo.MissingPaths, outputPath)
-				}
+			actionInfo.StderrInfo = stderrInfo
+		})
+	})
+
+	// Fetch the action, followed by the objects it references.
+	var command *remoteexecution.Command
+	var inputRootDigest digest.Digest
+	var inputRoot *remoteexecution.Directory
+	var reducedActionDigest digest.Digest
+	var profile *fsac.FileSystemAccessProfile
+	var profileErr error
+	wg.Go(func() {
+		start := time.Now()
+		actionMessage, err := s.messageCache.Get(ctx, actionDigest, &remoteexecution.Action{})
+		timings.record("action", start)
+		if err != nil {
+			if status.Code(err) != codes.NotFound {
+				setError(actionSectionAction, err)
 			}
-		} else if status.Code(err) != codes.NotFound {
-			s.renderError(w, err)
 			return
 		}
+		action := actionMessage.(*remoteexecution.Action)
+		actionInfo.Action = action
 
-		inputRootDigest, err := digestFunction.NewDigestFromProto(action.InputRootDigest)
-		if err != nil {
-			s.renderError(w, err)
-			return
+		if commandDigest, err := digestFunction.NewDigestFromProto(action.CommandDigest); err != nil {
+			setError(actionSectionCommand, err)
+		} else {
+			wg.Go(func() {
+				defer timings.record("command", time.Now())
+				commandMessage, err := s.messageCache.Get(ctx, commandDigest, &remoteexecution.Command{})
+				if err != nil {
+					if status.Code(err) != codes.NotFound {
+						setError(actionSectionCommand, err)
+					}
+					return
+				}
+				command = commandMessage.(*remoteexecution.Command)
+				actionInfo.Command = &commandInfo{
+					Digest:        commandDigest,
+					Command:       command,
+					BBClientdPath: formatBBClientdPath(s.getBBClientdBlobPath(commandDigest, commandDirectoryComponent)),
+				}
+			})
 		}
-		reducedActionDigest, err := blobstore.GetReducedActionDigest(actionDigest.GetDigestFunction(), action)
-		if err != nil {
-			s.renderError(w, err)
+
+		if inputRootDigest, err = digestFunction.NewDigestFromProto(action.InputRootDigest); err != nil {
+			setError(actionSectionInputRoot, err)
+		} else {
+			wg.Go(func() {
+				defer timings.record("input_root", time.Now())
+				directory, err := s.messageCache.GetDirectory(ctx, inputRootDigest)
+				if err != nil {
+					if status.Code(err) != codes.NotFound {
+						setError(actionSectionInputRoot, err)
+					}
+					return
+				}
+				inputRoot = directory
+			})
+		}
+
+		if reducedActionDigest, err = blobstore.GetReducedActionDigest(digestFunction, action); err != nil {
+			// Without a reduced action digest, no file system
+			// access profile can be obtained.
+			profileErr = err
+			setError(actionSectionFileSystemAccessProfile, err)
+			setError(actionSectionPreviousExecutionStats, err)
 			return
 		}
-		directoryMessage, err := s.contentAddressableStorage.Get(ctx, inputRootDigest).ToProto(&remoteexecution.Directory{}, s.maximumMessageSizeBytes)
-		if err == nil {
-			// Check whether a file system access profile exists for
-			// the current action. If so, download it, so that we
-			// can display which files in the root directory are
-			// being accessed.
-			var fileSystemAccessProfileReference *query.FileSystemAccessProfileReference
-			var bloomFilter *access.BloomFilterReader
-			if profileMessage, err := s.fileSystemAccessCache.Get(ctx, reducedActionDigest).ToProto(&fsac.FileSystemAccessProfile{}, s.maximumMessageSizeBytes); err == nil {
-				profile := profileMessage.(*fsac.FileSystemAccessProfile)
-				if bloomFilterReader, err := access.NewBloomFilterReader(profile.BloomFilter, profile.BloomFilterHashFunctions); err == nil {
-					fileSystemAccessProfileReference = &query.FileSystemAccessProfileReference{
-						Digest:             reducedActionDigest.GetProto(),
-						PathHashesBaseHash: access.RootPathHashes.GetBaseHash(),
-					}
-					bloomFilter = bloomFilterReader
-				} else {
-					log.Printf("Cannot read Bloom filter for %s: %s", reducedActionDigest.String(), err)
+		wg.Go(func() {
+			// Check whether a file system access profile
+			// exists for the current action. If so,
+			// download it, so that we can display which
+			// files in the root directory are being
+			// accessed. Errors are only reported if the
+			// input root is present.
+			defer timings.record("file_system_access_profile", time.Now())
+			profileMessage, err := s.fileSystemAccessCache.Get(ctx, reducedActionDigest).ToProto(&fsac.FileSystemAccessProfile{}, s.maximumMessageSizeBytes)
+			if err != nil {
+				profileErr = err
+				return
+			}
+			profile = profileMessage.(*fsac.FileSystemAccessProfile)
+		})
+		wg.Go(func() {
+			defer timings.record("previous_execution_stats", time.Now())
+			previousExecutionStatsInfo, err := s.getPreviousExecutionStatsInfo(ctx, reducedActionDigest)
+			if err != nil {
+				if status.Code(err) != codes.NotFound {
+					setError(actionSectionPreviousExecutionStats, err)
 				}
-			} else if status.Code(err) != codes.NotFound {
-				s.renderError(w, err)
 				return
 			}
+			actionInfo.PreviousExecutionStats = previousExecutionStatsInfo
+		})
+	})
+
+	wg.Wait()
+	timings.setHeader(w)
+
+	// Let references to lines of source files printed by compilers
+	// and test frameworks link to the files in the input root.
+	if inputRoot != nil {
+		linker := newDiagnosticLinker(s.getDirectory, inputRootDigest, inputRoot, command.GetWorkingDirectory())
+		var logInfos []*logInfo
+		for _, logInfo := range []*logInfo{actionInfo.StdoutInfo, actionInfo.StderrInfo} {
+			if logInfo != nil && logInfo.HTML != "" {
+				linker.addPathsFromHTML(logInfo.HTML)
+				logInfos = append(logInfos, logInfo)
+			}
+		}
+		if err := linker.resolvePaths(ctx); err != nil {
+			setError(actionSectionInputRoot, util.StatusWrap(err, "Failed to link paths printed in logs to files in the input root"))
+		} else {
+			for _, logInfo := range logInfos {
+				logInfo.HTML = linker.linkHTML(logInfo.HTML)
+			}
+		}
+	}
+
+	if command != nil {
+		foundPaths := map[string]struct{}{}
+		for _, outputDirectory := range actionInfo.OutputDirectories {
+			foundPaths[outputDirectory.Path] = struct{}{}
+		}
+		for _, outputSymlinks := range actionInfo.OutputSymlinks {
+			foundPaths[outputSymlinks.Path] = struct{}{}
+		}
+		for _, outputFiles := range actionInfo.OutputFiles {
+			foundPaths[outputFiles.Path] = struct{}{}
+		}
+		for _, outputPath := range command.OutputPaths {
+			if _, ok := foundPaths[outputPath]; !ok {
+				actionInfo.MissingPaths = append(actionInfo.MissingPaths, outputPath)
+			}
+		}
+	}
 
-			actionInfo.InputRoot = &directoryInfo{
-				Digest:                           inputRootDigest,
-				Directory:                        directoryMessage.(*remoteexecution.Directory),
-				BBClientdPath:                    formatBBClientdPath(s.getBBClientdBlobPath(inputRootDigest, directoryDirectoryComponent)),
-				FileSystemAccessProfileReference: fileSystemAccessProfileReference,
-				BloomFilter:                      bloomFilter,
+	if inputRoot != nil {
+		var fileSystemAccessProfileReference *query.FileSystemAccessProfileReference
+		var bloomFilter *access.BloomFilterReader
+		if profileErr == nil {
+			if bloomFilterReader, err := access.NewBloomFilterReader(profile.BloomFilter, profile.BloomFilterHashFunctions); err == nil {
+				fileSystemAccessProfileReference = &query.FileSystemAccessProfileReference{
+					Digest:             reducedActionDigest.GetProto(),
+					PathHashesBaseHash: access.RootPathHashes.GetBaseHash(),
+				}
+				bloomFilter = bloomFilterReader
+			} else {
+				log.Printf("Cannot read Bloom filter for %s: %s", reducedActionDigest.String(), err)
 			}
-		} else if status.Code(err) != codes.NotFound {
-			s.renderError(w, err)
-			return
+		} else if status.Code(profileErr) != codes.NotFound {
+			setError(actionSectionFileSystemAccessProfile, profileErr)
 		}
-		previousExecutionStatsInfo, err := s.getPreviousExecutionStatsInfo(ctx, reducedActionDigest)
-		if err == nil {
-			actionInfo.PreviousExecutionStats = previousExecutionStatsInfo
-		} else if status.Code(err) != codes.NotFound {
-			s.renderError(w, err)
-			return
+
+		// Only display the first page of the input root on the
+		// action page. Links to other pages of the listing point
+		// to the directory page of the input root.
+		listingQuery := url.Values{}
+		if fileSystemAccessProfileReference != nil {
+			listingQuery.Set("file_system_access_profile", protojson.MarshalOptions{}.Format(fileSystemAccessProfileReference))
+		}
+		listingOptions, _ := newDirectoryListingOptionsFromQuery(url.Values{})
+		actionInfo.InputRoot = &directoryInfo{
+			Digest:                           inputRootDigest,
+			Directory:                        inputRoot,
+			BBClientdPath:                    formatBBClientdPath(s.getBBClientdBlobPath(inputRootDigest, directoryDirectoryComponent)),
+			FileSystemAccessProfileReference: fileSystemAccessProfileReference,
+			BloomFilter:                      bloomFilter,
+			Listing: newDirectoryListing(
+				inputRoot,
+				listingOptions,
+				fmt.Sprintf("../../directory/%s-%d/", inputRootDigest.GetHashString(), inputRootDigest.GetSizeBytes()),
+				listingQuery),
 		}
-	} else if status.Code(err) != codes.NotFound {
-		s.renderError(w, err)
-		return
 	}
 
-	if actionMessage == nil && actionResult == nil {
-		s.renderError(w, status.Error(codes.NotFound, "Could not find an action or action result"))
+	statusCode := http.StatusOK
+	for _, section := range criticalActionSections {
+		if st, ok := actionInfo.Errors[section]; ok {
+			statusCode = http_server.StatusCodeFromGRPCCode(st.Code())
+			break
+		}
+	}
+	if statusCode == http.StatusOK && actionInfo.Action == nil && actionResult == nil {
+		s.renderError(w, req, status.Error(codes.NotFound, "Could not find an action or action result"))
 		return
 	}
 
-	if err := s.templates.ExecuteTemplate(w, "page_action.html", actionInfo); err != nil {
-		log.Print(err)
+	if isJSONRequested(req) {
+		s.renderJSONWithStatusCode(w, req, statusCode, &query.Response{
+			Page: &query.Response_Action{Action: actionInfo.toProto()},
+		})
+	} else {
+		w.WriteHeader(statusCode)
+		if err := s.templates.ExecuteTemplate(w, "page_action.html", actionInfo); err != nil {
+			log.Print(err)
+		}
 	}
 }
 
 func (s *BrowserService) handleCommand(w http.ResponseWriter, req *http.Request) {
 	digest, err := getDigestFromRequest(req)
 	if err != nil {
-		s.renderError(w, err)
+		s.renderError(w, req, err)
 		return
 	}
 
 	ctx := extractContextFromRequest(req)
-	commandMessage, err := s.contentAddressableStorage.Get(ctx, digest).ToProto(&remoteexecution.Command{}, s.maximumMessageSizeBytes)
+	commandMessage, err := s.messageCache.Get(ctx, digest, &remoteexecution.Command{})
 	if err != nil {
-		s.renderError(w, err)
+		s.renderError(w, req, err)
 		return
 	}
 	command := commandMessage.(*remoteexecution.Command)
@@ -512,170 +878,203 @@ func (s *BrowserService) handleCommand(w http.ResponseWriter, req *http.Request)
 			panic(http.ErrAbortHandler)
 		}
 	} else {
-		if err := s.templates.ExecuteTemplate(w, "page_command.html", commandInfo{
+		commandInfo := commandInfo{
 			Digest:        digest,
 			Command:       command,
 			BBClientdPath: formatBBClientdPath(s.getBBClientdBlobPath(digest, commandDirectoryComponent)),
-		}); err != nil {
+		}
+		if isJSONRequested(req) {
+			s.renderJSON(w, req, &query.Response{
+				Page: &query.Response_Command{Command: commandInfo.toProto()},
+			})
+		} else if err := s.templates.ExecuteTemplate(w, "page_command.html", commandInfo); err != nil {
 			log.Print(err)
 		}
 	}
 }
 
-func (s *BrowserService) generateTarballDirectory(ctx context.Context, w *tar.Writer, digestFunction digest.Function, directory *remoteexecution.Directory, directoryPath *path.Trace, getDirectory func(context.Context, digest.Digest) (*remoteexecution.Directory, error), filesSeen map[string]string) error {
-	// Emit child directories.
-	for _, directoryNode := range directory.Directories {
-		childName, ok := path.NewComponent(directoryNode.Name)
-		if !ok {
-			return status.Errorf(codes.InvalidArgument, "Directory %#v in directory %#v has an invalid name", directoryNode.Name, directoryPath.GetUNIXString())
-		}
-		childPath := directoryPath.Append(childName)
+// getDirectory loads a Directory message from the Content Addressable
+// Storage.
+func (s *BrowserService) getDirectory(ctx context.Context, digest digest.Digest) (*remoteexecution.Directory, error) {
+	return s.messageCache.GetDirectory(ctx, digest)
+}
 
-		if err := w.WriteHeader(&tar.Header{
-			Typeflag: tar.TypeDir,
-			Name:     childPath.GetUNIXString(),
-			Mode:     0o777,
-		}); err != nil {
-			return err
-		}
-		childDigest, err := digestFunction.NewDigestFromProto(directoryNode.Digest)
-		if err != nil {
-			return err
-		}
-		childDirectory, err := getDirectory(ctx, childDigest)
-		if err != nil {
-			return err
-		}
-		if err := s.generateTarballDirectory(ctx, w, digestFunction, childDirectory, childPath, getDirectory, filesSeen); err != nil {
-			return err
+// getArchiveFileExtension returns the file extension of archives that
+// are generated using a given format and compression, as provided
+// through the "format" and "compression" query parameters.
+func getArchiveFileExtension(format, compression string) (string, error) {
+	switch format {
+	case "tar":
+		switch compression {
+		case "none":
+			return ".tar", nil
+		case "", "gzip":
+			return ".tar.gz", nil
+		case "zstd":
+			return ".tar.zst", nil
+		default:
+			return "", status.Errorf(codes.InvalidArgument, "Unknown compression %#v", compression)
 		}
+	case "zip":
+		return ".zip", nil
+	default:
+		return "", status.Errorf(codes.InvalidArgument, "Unknown archive format %#v", format)
 	}
+}
 
-	// Emit symlinks.
-	for _, symlinkNode := range directory.Symlinks {
-		childName, ok := path.NewComponent(symlinkNode.Name)
-		if !ok {
-			return status.Errorf(codes.InvalidArgument, "Symbolic link %#v in directory %#v has an invalid name", symlinkNode.Name, directoryPath.GetUNIXString())
-		}
-		childPath := directoryPath.Append(childName)
-
-		if err := w.WriteHeader(&tar.Header{
-			Typeflag: tar.TypeSymlink,
-			Name:     childPath.GetUNIXString(),
-			Linkname: symlinkNode.Target,
-			Mode:     0o777,
-		}); err != nil {
-			return err
+// generateArchive writes the contents of a directory hierarchy into
+// an archive that is returned as the HTTP response. Supported formats
+// are "tar" and "zip". Tarballs may be compressed using gzip (the
+// default), zstd, or be left uncompressed.
+//
+// By default, entries in the archive use the current time as their
+// modification time. In reproducible mode, fixed timestamps and owners
+// are used instead.
+//
+// Unless disabled, a preflight check is performed to ensure that all
+// blobs are present before the archive is sent. Errors that occur
+// while the archive is being sent are reported by appending a file
+// describing the error to the archive.
+func (s *BrowserService) generateArchive(ctx context.Context, w http.ResponseWriter, req *http.Request, digest digest.Digest, directory *remoteexecution.Directory, getDirectory directoryGetter) {
+	query := req.URL.Query()
+	metadata := archiveMetadata{
+		ModificationTime: time.Now(),
+	}
+	if reproducible := query.Get("reproducible"); reproducible != "" {
+		if isReproducible, err := strconv.ParseBool(reproducible); err != nil {
+			s.renderError(w, req, status.Errorf(codes.InvalidArgument, "Invalid reproducible flag %#v", reproducible))
+			return
+		} else if isReproducible {
+			metadata = reproducibleArchiveMetadata
 		}
 	}
 
-	// Emit regular files.
-	for _, fileNode := range directory.Files {
-		childName, ok := path.NewComponent(fileNode.Name)
-		if !ok {
-			return status.Errorf(codes.InvalidArgument, "File %#v in directory %#v has an invalid name", fileNode.Name, directoryPath.GetUNIXString())
+	preflight := true
+	if preflightFlag := query.Get("preflight"); preflightFlag != "" {
+		var err error
+		if preflight, err = strconv.ParseBool(preflightFlag); err != nil {
+			s.renderError(w, req, status.Errorf(codes.InvalidArgument, "Invalid preflight flag %#v", preflightFlag))
+			return
 		}
-		childPath := directoryPath.Append(childName)
-		childPathString := childPath.GetUNIXString()
+	}
+	// Validate the format prior to loading any directories, so that
+	// invalid requests don't cause the directory hierarchy to be
+	// traversed.
+	format, compression := query.Get("format"), query.Get("compression")
+	fileExtension, err := getArchiveFileExtension(format, compression)
+	if err != nil {
+		s.renderError(w, req, err)
+		return
+	}
 
-		childDigest, err := digestFunction.NewDigestFromProto(fileNode.Digest)
+	digestFunction := digest.GetDigestFunction()
+	if preflight {
+		missingBlobs, err := s.preflightArchive(ctx, digestFunction, directory, getDirectory)
 		if err != nil {
-			return err
+			s.renderError(w, req, err)
+			return
 		}
-
-		childKey := childDigest.GetKey(digest.KeyWithoutInstance)
-		if fileNode.IsExecutable {
-			childKey += "+x"
-		} else {
-			childKey += "-x"
-		}
-
-		if linkPath, ok := filesSeen[childKey]; ok {
-			// This file was already returned previously.
-			// Emit a hardlink pointing to the first
-			// occurrence.
-			//
-			// Not only does this reduce the size of the
-			// tarball, it also makes the directory more
-			// representative of what it looks like when
-			// executed through bb_worker.
-			if err := w.WriteHeader(&tar.Header{
-				Typeflag: tar.TypeLink,
-				Name:     childPathString,
-				Linkname: linkPath,
-			}); err != nil {
-				return err
-			}
-		} else {
-			// This is the first time we're returning this
-			// file. Actually add it to the archive.
-			mode := int64(0o666)
-			if fileNode.IsExecutable {
-				mode = 0o777
-			}
-			if err := w.WriteHeader(&tar.Header{
-				Typeflag: tar.TypeReg,
-				Name:     childPathString,
-				Size:     fileNode.Digest.SizeBytes,
-				Mode:     mode,
+		if len(missingBlobs) > 0 {
+			w.WriteHeader(http.StatusNotFound)
+			if err := s.templates.ExecuteTemplate(w, "page_archive_missing_blobs.html", &archiveMissingBlobsInfo{
+				MissingBlobs: missingBlobs,
 			}); err != nil {
-				return err
+				log.Print(err)
 			}
+			return
+		}
+	}
 
-			if err := s.contentAddressableStorage.Get(ctx, childDigest).IntoWriter(w); err != nil {
-				return err
+	// Only set headers once the writers have been created, so that
+	// errors are not returned as if they were the archive.
+	var archiveWriter archiveWriter
+	var closers []io.Closer
+	var contentType string
+	switch format {
+	case "tar":
+		switch compression {
+		case "none":
+			contentType = "application/x-tar"
+			archiveWriter = newTarArchiveWriter(w, metadata)
+			closers = append(closers, archiveWriter)
+		case "", "gzip":
+			contentType = "application/gzip"
+			gzipWriter := gzip.NewWriter(w)
+			archiveWriter = newTarArchiveWriter(gzipWriter, metadata)
+			closers = append(closers, archiveWriter, gzipWriter)
+		case "zstd":
+			zstdEncoder, err := s.zstdPool.NewEncoder(ctx, w)
+			if err != nil {
+				s.renderError(w, req, err)
+				return
 			}
-
-			filesSeen[childKey] = childPathString
+			contentType = "application/zstd"
+			archiveWriter = newTarArchiveWriter(zstdEncoder, metadata)
+			closers = append(closers, archiveWriter, zstdEncoder)
 		}
+	case "zip":
+		contentType = "application/zip"
+		archiveWriter = newZIPArchiveWriter(w, metadata)
+		closers = append(closers, archiveWriter)
 	}
-	return nil
-}
-
-func (s *BrowserService) generateTarball(ctx context.Context, w http.ResponseWriter, digest digest.Digest, directory *remoteexecution.Directory, getDirectory func(context.Context, digest.Digest) (*remoteexecution.Directory, error)) {
-	w.Header().Set("Content-Disposition", fmt.Sprintf("attachment; filename=\"%s.tar.gz\"", digest.GetHashString()))
-	w.Header().Set("Content-Type", "application/gzip")
-	gzipWriter := gzip.NewWriter(w)
-	tarWriter := tar.NewWriter(gzipWriter)
-	filesSeen := map[string]string{}
-	if err := s.generateTarballDirectory(ctx, tarWriter, digest.GetDigestFunction(), directory, nil, getDirectory, filesSeen); err != nil {
-		// TODO(edsch): Any way to propagate this to the client?
-		log.Print(err)
-		panic(http.ErrAbortHandler)
+	w.Header().Set("Content-Disposition", fmt.Sprintf("attachment; filename=\"%s%s\"", digest.GetHashString(), fileExtension))
+	w.Header().Set("Content-Type", contentType)
+
+	writeErr := s.writeArchiveEntries(ctx, archiveWriter, digestFunction, directory, getDirectory)
+	if writeErr != nil {
+		// Part of the archive may already have been sent, meaning
+		// that an error page can no longer be returned. Append a
+		// file describing the error, so that users don't end up
+		// with an incomplete archive without any explanation.
+		log.Print(writeErr)
+		writeErr = archiveWriter.WriteError(writeErr)
 	}
-	if err := tarWriter.Close(); err != nil {
-		log.Print(err)
-		panic(http.ErrAbortHandler)
+	// Close all writers, even if closing one of them fails, so that
+	// pooled zstd encoders are always released.
+	for _, closer := range closers {
+		if err := closer.Close(); err != nil && writeErr == nil {
+			writeErr = err
+		}
 	}
-	if err := gzipWriter.Close(); err != nil {
-		log.Print(err)
+	if writeErr != nil {
+		log.Print(writeErr)
 		panic(http.ErrAbortHandler)
 	}
 }
 
+// isArchiveFormat returns whether the format requested through the
+// "format" query parameter causes a directory to be downloaded as an
+// archive.
+func isArchiveFormat(format string) bool {
+	return format == "tar" || format == "zip"
+}
+
 func (s *BrowserService) handleDirectory(w http.ResponseWriter, req *http.Request) {
 	directoryDigest, err := getDigestFromRequest(req)
 	if err != nil {
-		s.renderError(w, err)
+		s.renderError(w, req, err)
 		return
 	}
 
 	ctx := extractContextFromRequest(req)
-	directoryMessage, err := s.contentAddressableStorage.Get(ctx, directoryDigest).ToProto(&remoteexecution.Directory{}, s.maximumMessageSizeBytes)
+	directory, err := s.messageCache.GetDirectory(ctx, directoryDigest)
 	if err != nil {
-		s.renderError(w, err)
+		s.renderError(w, req, err)
 		return
 	}
-	directory := directoryMessage.(*remoteexecution.Directory)
 
-	if req.URL.Query().Get("format") == "tar" {
-		s.generateTarball(ctx, w, directoryDigest, directory, func(ctx context.Context, digest digest.Digest) (*remoteexecution.Directory, error) {
-			directoryMessage, err := s.contentAddressableStorage.Get(ctx, digest).ToProto(&remoteexecution.Directory{}, s.maximumMessageSizeBytes)
-			if err != nil {
-				return nil, err
-			}
-			return directoryMessage.(*remoteexecution.Directory), nil
+	if format := req.URL.Query().Get("format"); isArchiveFormat(format) {
+		s.generateArchive(ctx, w, req, directoryDigest, directory, s.getDirectory)
+	} else if isRecursiveListingFormat(format) {
+		s.generateRecursiveListing(ctx, w, req, directoryDigest, directory, s.getDirectory, "../../")
+	} else if isDiskUsageFormat(format) {
+		s.generateDiskUsage(ctx, w, req, directoryDigest, directory, s.getDirectory)
+	} else if isSearchFormat(format) {
+		s.generateSearch(ctx, w, req, directoryDigest, directory, s.getDirectory, "../../", func(result *searchResult) string {
+			return fmt.Sprintf("../../directory/%s-%d/", result.Digest.GetHashString(), result.Digest.GetSizeBytes())
 		})
+	} else if isGrepFormat(format) {
+		s.generateGrep(ctx, w, req, directoryDigest, directory, s.getDirectory, "../../")
 	} else {
 		var fileSystemAccessProfileReference *query.FileSystemAccessProfileReference
 		var bloomFilter *access.BloomFilterReader
@@ -686,36 +1085,50 @@ func (s *BrowserService) handleDirectory(w http.ResponseWriter, req *http.Reques
 			// display file usage for the current directory.
 			var profileReference query.FileSystemAccessProfileReference
 			if err := protojson.Unmarshal([]byte(profileReferenceJSON), &profileReference); err != nil {
-				s.renderError(w, err)
+				s.renderError(w, req, err)
 				return
 			}
 			profileDigest, err := directoryDigest.GetDigestFunction().NewDigestFromProto(profileReference.Digest)
 			if err != nil {
-				s.renderError(w, err)
+				s.renderError(w, req, err)
 				return
 			}
 			profileMessage, err := s.fileSystemAccessCache.Get(ctx, profileDigest).ToProto(&fsac.FileSystemAccessProfile{}, s.maximumMessageSizeBytes)
 			if err != nil {
-				s.renderError(w, err)
+				s.renderError(w, req, err)
 				return
 			}
 			profile := profileMessage.(*fsac.FileSystemAccessProfile)
 			bloomFilterReader, err := access.NewBloomFilterReader(profile.BloomFilter, profile.BloomFilterHashFunctions)
 			if err != nil {
-				s.renderError(w, err)
+				s.renderError(w, req, err)
 				return
 			}
 			fileSystemAccessProfileReference = &profileReference
 			bloomFilter = bloomFilterReader
 		}
 
-		if err := s.templates.ExecuteTemplate(w, "page_directory.html", &directoryInfo{
+		directoryInfo := directoryInfo{
 			Digest:                           directoryDigest,
 			Directory:                        directory,
 			BBClientdPath:                    formatBBClientdPath(s.getBBClientdBlobPath(directoryDigest, directoryDirectoryComponent)),
 			FileSystemAccessProfileReference: fileSystemAccessProfileReference,
 			BloomFilter:                      bloomFilter,
-		}); err != nil {
+		}
+		if isJSONRequested(req) {
+			s.renderJSON(w, req, &query.Response{
+				Page: &query.Response_Directory{Directory: directoryInfo.toProto()},
+			})
+			return
+		}
+
+		listingOptions, err := newDirectoryListingOptionsFromQuery(req.URL.Query())
+		if err != nil {
+			s.renderError(w, req, err)
+			return
+		}
+		directoryInfo.Listing = newDirectoryListing(directory, listingOptions, "", req.URL.Query())
+		if err := s.templates.ExecuteTemplate(w, "page_directory.html", &directoryInfo); err != nil {
 			log.Print(err)
 		}
 	}
@@ -724,7 +1137,16 @@ func (s *BrowserService) handleDirectory(w http.ResponseWriter, req *http.Reques
 func (s *BrowserService) handleFile(w http.ResponseWriter, req *http.Request) {
 	digest, err := getDigestFromRequest(req)
 	if err != nil {
-		s.renderError(w, err)
+		s.renderError(w, req, err)
+		return
+	}
+
+	switch format := req.URL.Query().Get("format"); {
+	case format == "html" && digest.GetSizeBytes() <= maximumFileViewerSizeBytes:
+		s.generateFileViewer(w, req, digest)
+		return
+	case format == "hex":
+		s.generateHexDump(w, req, digest, mux.Vars(req)["name"])
 		return
 	}
 
@@ -738,7 +1160,7 @@ func (s *BrowserService) handleFile(w http.ResponseWriter, req *http.Request) {
 	var first [4096]byte
 	n, err := r.Read(first[:])
 	if err != nil && err != io.EOF {
-		s.renderError(w, err)
+		s.renderError(w, req, err)
 		return
 	}
 
@@ -761,6 +1183,16 @@ type previousExecutionStatsInfo struct {
 	ScatterPlot         template.HTML
 }
 
+func (pi *previousExecutionStatsInfo) toProto() *query.PreviousExecutionStatsInfo {
+	if pi == nil {
+		return nil
+	}
+	return &query.PreviousExecutionStatsInfo{
+		ReducedActionDigest: getDigestProto(pi.ReducedActionDigest),
+		Stats:               pi.Stats,
+	}
+}
+
 func (s *BrowserService) getPreviousExecutionStatsInfo(ctx context.Context, reducedActionDigest digest.Digest) (*previousExecutionStatsInfo, error) {
 	previousExecutionStatsMessage, err := s.initialSizeClassCache.Get(ctx, reducedActionDigest).
 		ToProto(&iscc.PreviousExecutionStats{}, s.maximumMessageSizeBytes)
@@ -882,60 +1314,63 @@ func (l sizeClassList) Swap(i, j int) {
 func (s *BrowserService) handlePreviousExecutionStats(w http.ResponseWriter, req *http.Request) {
 	digest, err := getDigestFromRequest(req)
 	if err != nil {
-		s.renderError(w, err)
+		s.renderError(w, req, err)
 		return
 	}
 
 	ctx := extractContextFromRequest(req)
 	statsInfo, err := s.getPreviousExecutionStatsInfo(ctx, digest)
 	if err != nil {
-		s.renderError(w, err)
+		s.renderError(w, req, err)
 		return
 	}
 
-	if err := s.templates.ExecuteTemplate(w, "page_previous_execution_stats.html", statsInfo); err != nil {
+	if isJSONRequested(req) {
+		s.renderJSON(w, req, &query.Response{
+			Page: &query.Response_PreviousExecutionStats{PreviousExecutionStats: statsInfo.toProto()},
+		})
+	} else if err := s.templates.ExecuteTemplate(w, "page_previous_execution_stats.html", statsInfo); err != nil {
 		log.Print(err)
 	}
 }
 
+type treeInfo struct {
+	TreeDigest         digest.Digest
+	Path               string
+	DirectoryDigest    digest.Digest
+	Directory          *remoteexecution.Directory
+	HasParentDirectory bool
+	BBClientdPath      string
+	RootDirectory      string
+	Listing            *directoryListing
+}
+
+func (ti *treeInfo) toProto() *query.TreeInfo {
+	return &query.TreeInfo{
+		TreeDigest:      getDigestProto(ti.TreeDigest),
+		Path:            ti.Path,
+		DirectoryDigest: getDigestProto(ti.DirectoryDigest),
+		Directory:       ti.Directory,
+		BbClientdPath:   ti.BBClientdPath,
+	}
+}
+
 func (s *BrowserService) handleTree(w http.ResponseWriter, req *http.Request) {
 	treeDigest, err := getDigestFromRequest(req)
 	if err != nil {
-		s.renderError(w, err)
+		s.renderError(w, req, err)
 		return
 	}
 
 	ctx := extractContextFromRequest(req)
-	treeMessage, err := s.contentAddressableStorage.Get(ctx, treeDigest).ToProto(&remoteexecution.Tree{}, s.maximumMessageSizeBytes)
+	index, err := s.messageCache.GetTreeIndex(ctx, treeDigest)
 	if err != nil {
-		s.renderError(w, err)
+		s.renderError(w, req, err)
 		return
 	}
-	tree := treeMessage.(*remoteexecution.Tree)
-	treeInfo := struct {
-		Directory          *remoteexecution.Directory
-		HasParentDirectory bool
-		BBClientdPath      string
-		RootDirectory      string
-	}{
-		Directory: tree.Root,
-	}
-
-	// Construct map of all child directories.
-	digestFunction := treeDigest.GetDigestFunction()
-	children := map[string]*remoteexecution.Directory{}
-	for _, child := range tree.Children {
-		data, err := proto.Marshal(child)
-		if err != nil {
-			s.renderError(w, err)
-			return
-		}
-		digestGenerator := digestFunction.NewGenerator(int64(len(data)))
-		if _, err := digestGenerator.Write(data); err != nil {
-			s.renderError(w, err)
-			return
-		}
-		children[digestGenerator.Sum().GetKey(digest.KeyWithoutInstance)] = child
+	treeInfo := treeInfo{
+		TreeDigest: treeDigest,
+		Directory:  index.root,
 	}
 
 	// In case additional directory components are provided, we need
@@ -945,6 +1380,7 @@ func (s *BrowserService) handleTree(w http.ResponseWriter, req *http.Request) {
 	// other pages.
 	bbClientdPath := s.getBBClientdBlobPath(treeDigest, treeDirectoryComponent)
 	directoryDigest := treeDigest
+	var directoryPath *path.Trace
 	rootDirectory, scopeWalker := path.EmptyBuilder.Join(path.VoidScopeWalker)
 	rootDirectoryWalker, _ := scopeWalker.OnRelative()
 	for _, component := range strings.FieldsFunc(
@@ -952,54 +1388,55 @@ func (s *BrowserService) handleTree(w http.ResponseWriter, req *http.Request) {
 		func(r rune) bool { return r == '/' }) {
 		pathComponent, ok := path.NewComponent(component)
 		if !ok {
-			s.renderError(w, status.Errorf(codes.InvalidArgument, "Path contains invalid component %#v"))
+			s.renderError(w, req, status.Errorf(codes.InvalidArgument, "Path contains invalid component %#v", component))
 			return
 		}
 		bbClientdPath = bbClientdPath.Append(pathComponent)
+		directoryPath = directoryPath.Append(pathComponent)
 		rootDirectoryWalker, _ = rootDirectoryWalker.OnUp()
 
-		// Find child with matching name.
-		childNode := func() *remoteexecution.DirectoryNode {
-			for _, directoryNode := range treeInfo.Directory.Directories {
-				if component == directoryNode.Name {
-					return directoryNode
-				}
-			}
-			return nil
-		}()
-		if childNode == nil {
-			s.renderError(w, status.Error(codes.NotFound, "Subdirectory in tree not found"))
-			return
-		}
-
-		// Find corresponding child directory message.
-		directoryDigest, err = digestFunction.NewDigestFromProto(childNode.Digest)
+		// Find child directory with matching name.
+		directoryDigest, treeInfo.Directory, err = index.getChildDirectory(ctx, treeInfo.Directory, pathComponent)
 		if err != nil {
-			s.renderError(w, err)
-			return
-		}
-		childDirectory, ok := children[directoryDigest.GetKey(digest.KeyWithoutInstance)]
-		if !ok {
-			s.renderError(w, status.Error(codes.InvalidArgument, "Failed to find child node in tree"))
+			s.renderError(w, req, err)
 			return
 		}
 		treeInfo.HasParentDirectory = true
-		treeInfo.Directory = childDirectory
+	}
+	if directoryPath != nil {
+		// The root directory is embedded in the Tree message,
+		// meaning only child directories have a digest.
+		treeInfo.Path = directoryPath.GetUNIXString()
+		treeInfo.DirectoryDigest = directoryDigest
+	} else {
+		treeInfo.DirectoryDigest = digest.BadDigest
 	}
 	treeInfo.BBClientdPath = formatBBClientdPath(bbClientdPath)
 	treeInfo.RootDirectory = rootDirectory.GetUNIXString()
 
-	if req.URL.Query().Get("format") == "tar" {
-		s.generateTarball(
-			ctx, w, directoryDigest, treeInfo.Directory,
-			func(ctx context.Context, directoryDigest digest.Digest) (*remoteexecution.Directory, error) {
-				childDirectory, ok := children[directoryDigest.GetKey(digest.KeyWithoutInstance)]
-				if !ok {
-					return nil, errors.New("Failed to find child node in tree")
-				}
-				return childDirectory, nil
-			})
+	if format := req.URL.Query().Get("format"); isArchiveFormat(format) {
+		s.generateArchive(ctx, w, req, directoryDigest, treeInfo.Directory, index.getDirectory)
+	} else if isRecursiveListingFormat(format) {
+		s.generateRecursiveListing(ctx, w, req, directoryDigest, treeInfo.Directory, index.getDirectory, treeInfo.RootDirectory+"/../../")
+	} else if isDiskUsageFormat(format) {
+		s.generateDiskUsage(ctx, w, req, directoryDigest, treeInfo.Directory, index.getDirectory)
+	} else if isSearchFormat(format) {
+		s.generateSearch(ctx, w, req, directoryDigest, treeInfo.Directory, index.getDirectory, treeInfo.RootDirectory+"/../../", func(result *searchResult) string {
+			return result.Path + "/"
+		})
+	} else if isGrepFormat(format) {
+		s.generateGrep(ctx, w, req, directoryDigest, treeInfo.Directory, index.getDirectory, treeInfo.RootDirectory+"/../../")
+	} else if isJSONRequested(req) {
+		s.renderJSON(w, req, &query.Response{
+			Page: &query.Response_Tree{Tree: treeInfo.toProto()},
+		})
 	} else {
+		listingOptions, err := newDirectoryListingOptionsFromQuery(req.URL.Query())
+		if err != nil {
+			s.renderError(w, req, err)
+			return
+		}
+		treeInfo.Listing = newDirectoryListing(treeInfo.Directory, listingOptions, "", req.URL.Query())
 		if err := s.templates.ExecuteTemplate(w, "page_tree.html", &treeInfo); err != nil {
 			log.Print(err)
 		}
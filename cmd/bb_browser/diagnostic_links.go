@@ -0,0 +1,274 @@
+package main
+
+import (
+	"context"
+	"fmt"
+	"html"
+	"html/template"
+	"net/url"
+	"path"
+	"regexp"
+	"strings"
+
+	remoteexecution "github.com/bazelbuild/remote-apis/build/bazel/remote/execution/v2"
+	"github.com/buildbarn/bb-storage/pkg/digest"
+	"github.com/buildbarn/bb-storage/pkg/util"
+	"golang.org/x/sync/errgroup"
+
+	"google.golang.org/grpc/codes"
+	"google.golang.org/grpc/status"
+)
+
+// maximumDiagnosticPaths is the maximum number of distinct paths
+// printed in the logs of an action that are resolved against the
+// action's input root. Resolving paths may require loading directories
+// from the Content Addressable Storage, so this bounds the amount of
+// work done for logs containing many diagnostics.
+const maximumDiagnosticPaths = 100
+
+// diagnosticPathResolutionParallelism is the maximum number of paths
+// printed in the logs of an action that are resolved concurrently.
+const diagnosticPathResolutionParallelism = 10
+
+// diagnosticPattern matches references to lines of source files, as
+// printed by compilers and test frameworks. The first alternative
+// matches the "File "foo/bar.py", line 123" form used by Python's
+// tracebacks. The second alternative matches "foo/bar.cc:123" and
+// "foo/bar.cc:123:4", as printed by GCC, Clang, rustc, the Go
+// toolchain, javac and most test frameworks.
+var diagnosticPattern = regexp.MustCompile(`File "([^"]+)", line (\d+)|((?:[\w.+@~-]*/)*[\w+@~-][\w.+@~-]*\.[\w+]+):(\d+)(?::\d+)?`)
+
+// getDiagnosticPathIndex returns the index of the submatch of
+// diagnosticPattern containing the path, depending on which
+// alternative of the pattern matched. The line number is stored in the
+// submatch that follows it.
+func getDiagnosticPathIndex(match []int) int {
+	if match[2] < 0 {
+		return 6
+	}
+	return 2
+}
+
+// diagnosticLinker rewrites logs of an action that have been converted
+// to HTML, so that references to lines of files contained in the
+// action's input root link to the contents of those files.
+//
+// Paths are first collected from all logs by calling addPathsFromHTML(),
+// so that they can be resolved concurrently by calling resolvePaths().
+// Only then are the logs rewritten by calling linkHTML().
+type diagnosticLinker struct {
+	getDirectory     directoryGetter
+	inputRootDigest  digest.Digest
+	inputRoot        *remoteexecution.Directory
+	workingDirectory string
+
+	// The paths printed in the logs, in the order in which they
+	// were encountered, and the links to the files they reference.
+	// Paths that could not be resolved map to the empty string.
+	paths    []string
+	fileURLs map[string]string
+}
+
+func newDiagnosticLinker(getDirectory directoryGetter, inputRootDigest digest.Digest, inputRoot *remoteexecution.Directory, workingDirectory string) *diagnosticLinker {
+	return &diagnosticLinker{
+		getDirectory:     getDirectory,
+		inputRootDigest:  inputRootDigest,
+		inputRoot:        inputRoot,
+		workingDirectory: workingDirectory,
+		fileURLs:         map[string]string{},
+	}
+}
+
+// getCandidatePaths returns the paths relative to the input root that
+// a path printed by an action may refer to. Relative paths are
+// resolved against the working directory of the action, followed by
+// the input root itself. As the location at which the input root was
+// placed on the worker is unknown, absolute paths are tried with
+// leading components removed, as long as the first remaining component
+// is present in the input root.
+func (dl *diagnosticLinker) getCandidatePaths(p string) []string {
+	var candidates []string
+	addCandidate := func(candidate string) {
+		candidate = path.Clean(candidate)
+		if candidate != "." && candidate != ".." && !strings.HasPrefix(candidate, "../") {
+			candidates = append(candidates, candidate)
+		}
+	}
+	if path.IsAbs(p) {
+		components := splitPath(p)
+		for i := range components {
+			if dl.isInInputRoot(components[i]) {
+				addCandidate(strings.Join(components[i:], "/"))
+			}
+		}
+		return candidates
+	}
+	addCandidate(path.Join(dl.workingDirectory, p))
+	if dl.workingDirectory != "" {
+		addCandidate(p)
+	}
+	return candidates
+}
+
+// isInInputRoot returns whether the top level directory of the input
+// root contains an entry with a given name.
+func (dl *diagnosticLinker) isInInputRoot(name string) bool {
+	if _, ok := findDirectoryNode(dl.inputRoot, name); ok {
+		return true
+	}
+	for _, fileNode := range dl.inputRoot.Files {
+		if fileNode.Name == name {
+			return true
+		}
+	}
+	return false
+}
+
+// getFileURL returns a link to the contents of the file in the input
+// root that is referenced by a path printed by an action. The empty
+// string is returned if the path does not refer to a file in the input
+// root.
+func (dl *diagnosticLinker) getFileURL(ctx context.Context, p string) (string, error) {
+	for _, candidate := range dl.getCandidatePaths(p) {
+		var info actionPathInfo
+		if err := resolveDirectoryPath(ctx, &info, dl.inputRootDigest, dl.inputRoot, dl.getDirectory, splitPath(candidate)); err != nil {
+			// Paths that don't exist, or that traverse
+			// symbolic links, are not linked.
+			if code := status.Code(err); code == codes.NotFound || code == codes.InvalidArgument {
+				continue
+			}
+			return "", err
+		}
+		if info.File == nil {
+			continue
+		}
+		fileDigest, err := dl.inputRootDigest.GetDigestFunction().NewDigestFromProto(info.File.Digest)
+		if err != nil {
+			continue
+		}
+		return fmt.Sprintf("../../file/%s-%d/%s", fileDigest.GetHashString(), fileDigest.GetSizeBytes(), url.PathEscape(info.File.Name)), nil
+	}
+	return "", nil
+}
+
+// addPathsFromHTML collects the paths printed in a log that has been
+// converted to HTML, so that they can be resolved by resolvePaths().
+func (dl *diagnosticLinker) addPathsFromHTML(logHTML template.HTML) {
+	splitLogHTML(logHTML, func(piece string, isLinkableText bool) {
+		if !isLinkableText {
+			return
+		}
+		text := html.UnescapeString(piece)
+		for _, match := range diagnosticPattern.FindAllStringSubmatchIndex(text, -1) {
+			pathIndex := getDiagnosticPathIndex(match)
+			p := text[match[pathIndex]:match[pathIndex+1]]
+			if _, ok := dl.fileURLs[p]; !ok && len(dl.paths) < maximumDiagnosticPaths {
+				dl.paths = append(dl.paths, p)
+				dl.fileURLs[p] = ""
+			}
+		}
+	})
+}
+
+// resolvePaths resolves all paths collected by addPathsFromHTML()
+// against the input root. Paths are resolved concurrently, as every
+// path may require loading directories from the Content Addressable
+// Storage.
+func (dl *diagnosticLinker) resolvePaths(ctx context.Context) error {
+	fileURLs := make([]string, len(dl.paths))
+	group, groupCtx := errgroup.WithContext(ctx)
+	group.SetLimit(diagnosticPathResolutionParallelism)
+	for i, p := range dl.paths {
+		group.Go(func() error {
+			fileURL, err := dl.getFileURL(groupCtx, p)
+			if err != nil {
+				return util.StatusWrapf(err, "Failed to resolve path %#v", p)
+			}
+			fileURLs[i] = fileURL
+			return nil
+		})
+	}
+	if err := group.Wait(); err != nil {
+		return err
+	}
+	for i, p := range dl.paths {
+		dl.fileURLs[p] = fileURLs[i]
+	}
+	return nil
+}
+
+// linkText adds links to a piece of text contained in a log, which has
+// already been escaped for use in HTML.
+func (dl *diagnosticLinker) linkText(sb *strings.Builder, escapedText string) {
+	text := html.UnescapeString(escapedText)
+	matches := diagnosticPattern.FindAllStringSubmatchIndex(text, -1)
+	if len(matches) == 0 {
+		sb.WriteString(escapedText)
+		return
+	}
+	previousEnd := 0
+	for _, match := range matches {
+		// Obtain the path and line number from whichever
+		// alternative of the pattern matched.
+		pathIndex := getDiagnosticPathIndex(match)
+		fileURL := dl.fileURLs[text[match[pathIndex]:match[pathIndex+1]]]
+		if fileURL == "" {
+			continue
+		}
+		line := text[match[pathIndex+2]:match[pathIndex+3]]
+		sb.WriteString(template.HTMLEscapeString(text[previousEnd:match[0]]))
+		fmt.Fprintf(sb, `<a href="%s?format=html#L%s">%s</a>`, template.HTMLEscapeString(fileURL), line, template.HTMLEscapeString(text[match[0]:match[1]]))
+		previousEnd = match[1]
+	}
+	sb.WriteString(template.HTMLEscapeString(text[previousEnd:]))
+}
+
+// splitLogHTML splits a log that has been converted to HTML into tags
+// and the text between them. The provided function is called for every
+// piece, indicating whether it is text that is not already part of a
+// link.
+func splitLogHTML(logHTML template.HTML, handlePiece func(piece string, isLinkableText bool)) {
+	remaining := string(logHTML)
+	linkDepth := 0
+	for len(remaining) > 0 {
+		if remaining[0] == '<' {
+			tagEnd := strings.IndexByte(remaining, '>')
+			if tagEnd < 0 {
+				handlePiece(remaining, false)
+				break
+			}
+			tag := remaining[:tagEnd+1]
+			if strings.HasPrefix(tag, "<a ") || tag == "<a>" {
+				linkDepth++
+			} else if tag == "</a>" && linkDepth > 0 {
+				linkDepth--
+			}
+			handlePiece(tag, false)
+			remaining = remaining[tagEnd+1:]
+			continue
+		}
+
+		textEnd := strings.IndexByte(remaining, '<')
+		if textEnd < 0 {
+			textEnd = len(remaining)
+		}
+		handlePiece(remaining[:textEnd], linkDepth == 0)
+		remaining = remaining[textEnd:]
+	}
+}
+
+// linkHTML adds links to all references to lines of files in the input
+// root contained in a log that has been converted to HTML. Only text
+// between tags is rewritten. Text that is already part of a link is
+// left alone.
+func (dl *diagnosticLinker) linkHTML(logHTML template.HTML) template.HTML {
+	var sb strings.Builder
+	splitLogHTML(logHTML, func(piece string, isLinkableText bool) {
+		if isLinkableText {
+			dl.linkText(&sb, piece)
+		} else {
+			sb.WriteString(piece)
+		}
+	})
+	return template.HTML(sb.String())
+}
@@ -0,0 +1,178 @@
+package main
+
+// diffOperationType indicates whether an element of a sequence is
+// retained, removed or added by a diffOperation.
+type diffOperationType int
+
+const (
+	diffOperationTypeEqual diffOperationType = iota
+	diffOperationTypeDelete
+	diffOperationTypeInsert
+)
+
+// diffOperation is a single step of an edit script that transforms an
+// old sequence into a new sequence. OldIndex is only valid for
+// operations of type equal and delete, while NewIndex is only valid
+// for operations of type equal and insert.
+type diffOperation struct {
+	Type     diffOperationType
+	OldIndex int
+	NewIndex int
+}
+
+// diffSequences computes a minimal edit script that transforms an old
+// sequence into a new sequence, using Eugene Myers' O(ND) difference
+// algorithm. Elements are compared by calling a callback with the
+// indices of elements in both sequences.
+//
+// The amount of memory used by this algorithm grows quadratically with
+// the number of edits. If the number of edits exceeds maximumEdits,
+// the sequences are considered to be completely different, and an edit
+// script is returned that deletes all old elements and inserts all new
+// ones.
+func diffSequences(oldLength, newLength int, equal func(oldIndex, newIndex int) bool, maximumEdits int) []diffOperation {
+	operations := make([]diffOperation, 0, max(oldLength, newLength))
+
+	// Common prefixes and suffixes are frequent and can be skipped
+	// cheaply, reducing the size of the problem that needs to be
+	// solved by the main algorithm.
+	prefixLength := 0
+	for prefixLength < oldLength && prefixLength < newLength && equal(prefixLength, prefixLength) {
+		operations = append(operations, diffOperation{
+			Type:     diffOperationTypeEqual,
+			OldIndex: prefixLength,
+			NewIndex: prefixLength,
+		})
+		prefixLength++
+	}
+	suffixLength := 0
+	for suffixLength < oldLength-prefixLength && suffixLength < newLength-prefixLength && equal(oldLength-suffixLength-1, newLength-suffixLength-1) {
+		suffixLength++
+	}
+
+	operations = diffSequencesMiddle(
+		operations,
+		prefixLength, oldLength-suffixLength,
+		prefixLength, newLength-suffixLength,
+		equal,
+		maximumEdits)
+
+	for i := suffixLength; i > 0; i-- {
+		operations = append(operations, diffOperation{
+			Type:     diffOperationTypeEqual,
+			OldIndex: oldLength - i,
+			NewIndex: newLength - i,
+		})
+	}
+	return operations
+}
+
+func diffSequencesMiddle(operations []diffOperation, oldStart, oldEnd, newStart, newEnd int, equal func(oldIndex, newIndex int) bool, maximumEdits int) []diffOperation {
+	n, m := oldEnd-oldStart, newEnd-newStart
+	maximumDepth := min(n+m, maximumEdits)
+
+	// v[offset+k] contains the furthest reaching x coordinate on
+	// diagonal k. Snapshots of v are stored for every depth, so
+	// that the edit script can be reconstructed afterwards. Only
+	// the diagonals that can be reached at a given depth are
+	// stored, causing memory usage to be proportional to the
+	// square of the number of edits.
+	offset := maximumDepth + 1
+	v := make([]int, 2*maximumDepth+3)
+	var trace [][]int32
+	for d := 0; d <= maximumDepth; d++ {
+		if d > 0 {
+			snapshot := make([]int32, 0, 2*d-1)
+			for k := -(d - 1); k <= d-1; k++ {
+				snapshot = append(snapshot, int32(v[offset+k]))
+			}
+			trace = append(trace, snapshot)
+		}
+		for k := -d; k <= d; k += 2 {
+			var x int
+			if k == -d || (k != d && v[offset+k-1] < v[offset+k+1]) {
+				x = v[offset+k+1]
+			} else {
+				x = v[offset+k-1] + 1
+			}
+			y := x - k
+			for x < n && y < m && equal(oldStart+x, newStart+y) {
+				x++
+				y++
+			}
+			v[offset+k] = x
+			if x >= n && y >= m {
+				return append(operations, diffSequencesBacktrack(trace, d, n, m, oldStart, newStart)...)
+			}
+		}
+	}
+
+	// Too many edits. Consider the sequences to be unrelated.
+	for i := oldStart; i < oldEnd; i++ {
+		operations = append(operations, diffOperation{
+			Type:     diffOperationTypeDelete,
+			OldIndex: i,
+		})
+	}
+	for i := newStart; i < newEnd; i++ {
+		operations = append(operations, diffOperation{
+			Type:     diffOperationTypeInsert,
+			NewIndex: i,
+		})
+	}
+	return operations
+}
+
+func diffSequencesBacktrack(trace [][]int32, depth, n, m, oldStart, newStart int) []diffOperation {
+	reversed := make([]diffOperation, 0, max(n, m))
+	x, y := n, m
+	for d := depth; d > 0; d-- {
+		snapshot := trace[d-1]
+		previous := func(k int) int { return int(snapshot[k+d-1]) }
+		k := x - y
+		var previousK int
+		if k == -d || (k != d && previous(k-1) < previous(k+1)) {
+			previousK = k + 1
+		} else {
+			previousK = k - 1
+		}
+		previousX := previous(previousK)
+		previousY := previousX - previousK
+		for x > previousX && y > previousY {
+			x--
+			y--
+			reversed = append(reversed, diffOperation{
+				Type:     diffOperationTypeEqual,
+				OldIndex: oldStart + x,
+				NewIndex: newStart + y,
+			})
+		}
+		if x == previousX {
+			reversed = append(reversed, diffOperation{
+				Type:     diffOperationTypeInsert,
+				NewIndex: newStart + previousY,
+			})
+		} else {
+			reversed = append(reversed, diffOperation{
+				Type:     diffOperationTypeDelete,
+				OldIndex: oldStart + previousX,
+			})
+		}
+		x, y = previousX, previousY
+	}
+	for x > 0 && y > 0 {
+		x--
+		y--
+		reversed = append(reversed, diffOperation{
+			Type:     diffOperationTypeEqual,
+			OldIndex: oldStart + x,
+			NewIndex: newStart + y,
+		})
+	}
+
+	operations := make([]diffOperation, 0, len(reversed))
+	for i := len(reversed) - 1; i >= 0; i-- {
+		operations = append(operations, reversed[i])
+	}
+	return operations
+}
@@ -0,0 +1,181 @@
+package main
+
+import (
+	"math/rand"
+	"strings"
+	"testing"
+)
+
+// formatDiffOperations converts an edit script to a string, where
+// every element is prefixed with "=", "-" or "+", depending on whether
+// it is retained, removed or added.
+func formatDiffOperations(t *testing.T, oldSequence, newSequence string, operations []diffOperation) string {
+	var sb strings.Builder
+	oldIndex, newIndex := 0, 0
+	for _, operation := range operations {
+		switch operation.Type {
+		case diffOperationTypeEqual:
+			if operation.OldIndex != oldIndex || operation.NewIndex != newIndex || oldSequence[oldIndex] != newSequence[newIndex] {
+				t.Fatalf("Invalid equal operation %v at old index %d and new index %d", operation, oldIndex, newIndex)
+			}
+			sb.WriteByte('=')
+			sb.WriteByte(oldSequence[oldIndex])
+			oldIndex++
+			newIndex++
+		case diffOperationTypeDelete:
+			if operation.OldIndex != oldIndex {
+				t.Fatalf("Invalid delete operation %v at old index %d", operation, oldIndex)
+			}
+			sb.WriteByte('-')
+			sb.WriteByte(oldSequence[oldIndex])
+			oldIndex++
+		case diffOperationTypeInsert:
+			if operation.NewIndex != newIndex {
+				t.Fatalf("Invalid insert operation %v at new index %d", operation, newIndex)
+			}
+			sb.WriteByte('+')
+			sb.WriteByte(newSequence[newIndex])
+			newIndex++
+		default:
+			t.Fatalf("Invalid operation type %d", operation.Type)
+		}
+	}
+	if oldIndex != len(oldSequence) || newIndex != len(newSequence) {
+		t.Fatalf("Edit script only covers %d old and %d new elements", oldIndex, newIndex)
+	}
+	return sb.String()
+}
+
+func diffStrings(oldSequence, newSequence string, maximumEdits int) []diffOperation {
+	return diffSequences(len(oldSequence), len(newSequence), func(oldIndex, newIndex int) bool {
+		return oldSequence[oldIndex] == newSequence[newIndex]
+	}, maximumEdits)
+}
+
+func TestDiffSequences(t *testing.T) {
+	for _, testCase := range []struct {
+		name               string
+		oldSequence        string
+		newSequence        string
+		maximumEdits       int
+		expectedOperations string
+	}{
+		{
+			name:               "BothEmpty",
+			maximumEdits:       10,
+			expectedOperations: "",
+		},
+		{
+			name:               "OldEmpty",
+			newSequence:        "abc",
+			maximumEdits:       10,
+			expectedOperations: "+a+b+c",
+		},
+		{
+			name:               "NewEmpty",
+			oldSequence:        "abc",
+			maximumEdits:       10,
+			expectedOperations: "-a-b-c",
+		},
+		{
+			name:               "Identical",
+			oldSequence:        "abc",
+			newSequence:        "abc",
+			maximumEdits:       0,
+			expectedOperations: "=a=b=c",
+		},
+		{
+			name:               "Replaced",
+			oldSequence:        "abc",
+			newSequence:        "aXc",
+			maximumEdits:       10,
+			expectedOperations: "=a-b+X=c",
+		},
+		{
+			name:               "Inserted",
+			oldSequence:        "ac",
+			newSequence:        "abbc",
+			maximumEdits:       10,
+			expectedOperations: "=a+b+b=c",
+		},
+		{
+			name:               "Myers",
+			oldSequence:        "abcabba",
+			newSequence:        "cbabac",
+			maximumEdits:       10,
+			expectedOperations: "-a-b=c+b=a=b-b=a+c",
+		},
+		{
+			// When the number of edits exceeds the limit, all
+			// elements apart from the common prefix and suffix
+			// should be replaced.
+			name:               "TooManyEdits",
+			oldSequence:        "xabcabbay",
+			newSequence:        "xcbabacy",
+			maximumEdits:       4,
+			expectedOperations: "=x-a-b-c-a-b-b-a+c+b+a+b+a+c=y",
+		},
+		{
+			name:               "ExactlyMaximumEdits",
+			oldSequence:        "abcabba",
+			newSequence:        "cbabac",
+			maximumEdits:       5,
+			expectedOperations: "-a-b=c+b=a=b-b=a+c",
+		},
+	} {
+		t.Run(testCase.name, func(t *testing.T) {
+			operations := diffStrings(testCase.oldSequence, testCase.newSequence, testCase.maximumEdits)
+			if formatted := formatDiffOperations(t, testCase.oldSequence, testCase.newSequence, operations); formatted != testCase.expectedOperations {
+				t.Fatalf("Expected edit script %#v, got %#v", testCase.expectedOperations, formatted)
+			}
+		})
+	}
+}
+
+// getLongestCommonSubsequenceLength computes the length of the longest
+// common subsequence of two strings using dynamic programming.
+func getLongestCommonSubsequenceLength(a, b string) int {
+	lengths := make([][]int, len(a)+1)
+	for i := range lengths {
+		lengths[i] = make([]int, len(b)+1)
+	}
+	for i := 1; i <= len(a); i++ {
+		for j := 1; j <= len(b); j++ {
+			if a[i-1] == b[j-1] {
+				lengths[i][j] = lengths[i-1][j-1] + 1
+			} else {
+				lengths[i][j] = max(lengths[i-1][j], lengths[i][j-1])
+			}
+		}
+	}
+	return lengths[len(a)][len(b)]
+}
+
+func TestDiffSequencesMinimal(t *testing.T) {
+	// Edit scripts computed for random sequences should be valid
+	// and minimal, meaning that the number of retained elements
+	// is equal to the length of the longest common subsequence.
+	r := rand.New(rand.NewSource(1))
+	randomString := func() string {
+		b := make([]byte, r.Intn(30))
+		for i := range b {
+			b[i] = "abcd"[r.Intn(4)]
+		}
+		return string(b)
+	}
+	for i := 0; i < 1000; i++ {
+		oldSequence, newSequence := randomString(), randomString()
+		operations := diffStrings(oldSequence, newSequence, len(oldSequence)+len(newSequence))
+		formatDiffOperations(t, oldSequence, newSequence, operations)
+
+		equalCount := 0
+		for _, operation := range operations {
+			if operation.Type == diffOperationTypeEqual {
+				equalCount++
+			}
+		}
+		if expectedCount := getLongestCommonSubsequenceLength(oldSequence, newSequence); equalCount != expectedCount {
+			t.Fatalf("Diff of %#v and %#v retains %d elements, while the longest common subsequence has length %d", oldSequence, newSequence, equalCount, expectedCount)
+		}
+	}
+}
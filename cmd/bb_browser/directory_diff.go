@@ -0,0 +1,366 @@
+package main
+
+import (
+	"context"
+	"fmt"
+	"log"
+	"net/http"
+	"sort"
+
+	remoteexecution "github.com/bazelbuild/remote-apis/build/bazel/remote/execution/v2"
+	"github.com/buildbarn/bb-storage/pkg/digest"
+	"github.com/buildbarn/bb-storage/pkg/filesystem/path"
+	"github.com/gorilla/mux"
+
+	"golang.org/x/sync/errgroup"
+
+	"google.golang.org/grpc/codes"
+	"google.golang.org/grpc/status"
+)
+
+// directoryGetter is a callback that is used to load the contents of
+// directories. Directories may either be stored in the Content
+// Addressable Storage as separate objects, or be part of a Tree.
+type directoryGetter func(ctx context.Context, digest digest.Digest) (*remoteexecution.Directory, error)
+
+// directoryDiffNode is one side of an entry in a directory diff.
+// Exactly one of the fields is set.
+type directoryDiffNode struct {
+	Directory *remoteexecution.DirectoryNode
+	File      *remoteexecution.FileNode
+	Symlink   *remoteexecution.SymlinkNode
+}
+
+// directoryDiffEntry is a file, directory or symbolic link that
+// differs between two directory hierarchies. Old is nil if the entry
+// was added, while New is nil if the entry was removed.
+type directoryDiffEntry struct {
+	Path string
+	Old  *directoryDiffNode
+	New  *directoryDiffNode
+}
+
+// IsAdded returns whether the entry is only present in the new
+// directory hierarchy.
+func (e *directoryDiffEntry) IsAdded() bool {
+	return e.Old == nil
+}
+
+// IsRemoved returns whether the entry is only present in the old
+// directory hierarchy.
+func (e *directoryDiffEntry) IsRemoved() bool {
+	return e.New == nil
+}
+
+// directoryDiffInfo contains a list of differences between two
+// directory hierarchies, and the path of the page relative to
+// "${instance_name}/blobs/${digest_function}", so that relative links
+// to files and directories can be emitted.
+//
+// Directories contained in a Tree cannot be displayed through the
+// "directory" page. For these, OldTreePath and NewTreePath contain the
+// path of the "tree" page of the Tree containing the directory
+// hierarchy.
+type directoryDiffInfo struct {
+	Entries               []directoryDiffEntry
+	DirectoryLimitReached bool
+	RootDirectory         string
+	OldTreePath           string
+	NewTreePath           string
+}
+
+// directoryDiffPageInfo contains the information that we display on
+// pages comparing two directory hierarchies.
+type directoryDiffPageInfo struct {
+	OldPath string
+	NewPath string
+	Diff    directoryDiffInfo
+}
+
+// getDirectoryDiffNodes creates an index of all children of a
+// directory by name, so that they can be compared against the children
+// of another directory.
+func getDirectoryDiffNodes(directory *remoteexecution.Directory) map[string]*directoryDiffNode {
+	nodes := map[string]*directoryDiffNode{}
+	for _, directoryNode := range directory.GetDirectories() {
+		nodes[directoryNode.Name] = &directoryDiffNode{Directory: directoryNode}
+	}
+	for _, fileNode := range directory.GetFiles() {
+		nodes[fileNode.Name] = &directoryDiffNode{File: fileNode}
+	}
+	for _, symlinkNode := range directory.GetSymlinks() {
+		nodes[symlinkNode.Name] = &directoryDiffNode{Symlink: symlinkNode}
+	}
+	return nodes
+}
+
+// directoryDiffDirectory is a pair of directories at the same path in
+// two directory hierarchies that are being compared. Either side may
+// be absent, in which case it is treated as if it were empty.
+type directoryDiffDirectory struct {
+	directoryPath *path.Trace
+	oldDigest     *digest.Digest
+	newDigest     *digest.Digest
+	oldDirectory  *remoteexecution.Directory
+	newDirectory  *remoteexecution.Directory
+	loaded        bool
+	items         []directoryDiffItem
+}
+
+// directoryDiffItem is a child of a directoryDiffDirectory, consisting
+// of an entry that is reported, a pair of child directories that need
+// to be compared, or both. Items are stored in the order in which they
+// are reported.
+type directoryDiffItem struct {
+	entry *directoryDiffEntry
+	child *directoryDiffDirectory
+}
+
+// compare computes the differences between the children of a pair of
+// directories. Pairs of child directories that need to be compared are
+// returned, so that they can be loaded by the caller.
+func (d *directoryDiffDirectory) compare(digestFunction digest.Function) ([]*directoryDiffDirectory, error) {
+	oldNodes := getDirectoryDiffNodes(d.oldDirectory)
+	newNodes := getDirectoryDiffNodes(d.newDirectory)
+	names := make([]string, 0, len(oldNodes)+len(newNodes))
+	for name := range oldNodes {
+		names = append(names, name)
+	}
+	for name := range newNodes {
+		if _, ok := oldNodes[name]; !ok {
+			names = append(names, name)
+		}
+	}
+	sort.Strings(names)
+
+	var children []*directoryDiffDirectory
+	for _, name := range names {
+		childName, ok := path.NewComponent(name)
+		if !ok {
+			return nil, status.Errorf(codes.InvalidArgument, "Entry %#v in directory %#v has an invalid name", name, d.directoryPath.GetUNIXString())
+		}
+		childPath := d.directoryPath.Append(childName)
+		oldNode, newNode := oldNodes[name], newNodes[name]
+
+		if oldNode != nil && newNode != nil {
+			switch {
+			case oldNode.Directory != nil && newNode.Directory != nil:
+				if equalDigestProtos(oldNode.Directory.Digest, newNode.Directory.Digest) {
+					// Identical subtrees. No need to traverse them.
+					continue
+				}
+			case oldNode.File != nil && newNode.File != nil:
+				if equalDigestProtos(oldNode.File.Digest, newNode.File.Digest) && oldNode.File.IsExecutable == newNode.File.IsExecutable {
+					continue
+				}
+			case oldNode.Symlink != nil && newNode.Symlink != nil:
+				if oldNode.Symlink.Target == newNode.Symlink.Target {
+					continue
+				}
+			}
+		}
+
+		// Directories that are present on both sides are not
+		// reported themselves, as the differences are reported
+		// through their contents.
+		var item directoryDiffItem
+		if oldNode == nil || newNode == nil || oldNode.Directory == nil || newNode.Directory == nil {
+			item.entry = &directoryDiffEntry{
+				Path: childPath.GetUNIXString(),
+				Old:  oldNode,
+				New:  newNode,
+			}
+		}
+
+		child := directoryDiffDirectory{directoryPath: childPath}
+		if oldNode != nil && oldNode.Directory != nil {
+			childDigest, err := digestFunction.NewDigestFromProto(oldNode.Directory.Digest)
+			if err != nil {
+				return nil, err
+			}
+			child.oldDigest = &childDigest
+		}
+		if newNode != nil && newNode.Directory != nil {
+			childDigest, err := digestFunction.NewDigestFromProto(newNode.Directory.Digest)
+			if err != nil {
+				return nil, err
+			}
+			child.newDigest = &childDigest
+		}
+		if child.oldDigest != nil || child.newDigest != nil {
+			item.child = &child
+			children = append(children, &child)
+		}
+		d.items = append(d.items, item)
+	}
+	return children, nil
+}
+
+// appendEntries appends the entries of a pair of directories and the
+// pairs of child directories that were compared, in depth-first order.
+func (d *directoryDiffDirectory) appendEntries(entries []directoryDiffEntry) []directoryDiffEntry {
+	for _, item := range d.items {
+		if item.entry != nil {
+			entries = append(entries, *item.entry)
+		}
+		if item.child != nil && item.child.loaded {
+			entries = item.child.appendEntries(entries)
+		}
+	}
+	return entries
+}
+
+// diffDirectories computes the differences between two directory
+// hierarchies, returning an entry for every file, directory and
+// symbolic link that was added, removed or changed. Directories that
+// are present on both sides are only descended into if their digests
+// differ. Directories that are only present on one side are traversed
+// entirely, so that all files contained within are reported.
+//
+// Directories are loaded one level at a time, with all directories at
+// the same level being loaded concurrently. As every directory may
+// need to be loaded from the Content Addressable Storage, the number
+// of directories that are loaded is limited. The second return value
+// indicates whether this limit was reached, meaning that not all
+// differences have been reported.
+//
+// A nil directory is treated as if it were empty.
+func (s *BrowserService) diffDirectories(ctx context.Context, digestFunction digest.Function, oldDirectory, newDirectory *remoteexecution.Directory, getOldDirectory, getNewDirectory directoryGetter) ([]directoryDiffEntry, bool, error) {
+	root := directoryDiffDirectory{
+		oldDirectory: oldDirectory,
+		newDirectory: newDirectory,
+		loaded:       true,
+	}
+	currentLevel := []*directoryDiffDirectory{&root}
+	directoriesRemaining := s.maximumSearchDirectories
+	directoryLimitReached := false
+	for len(currentLevel) > 0 {
+		var nextLevel []*directoryDiffDirectory
+		for _, d := range currentLevel {
+			children, err := d.compare(digestFunction)
+			if err != nil {
+				return nil, false, err
+			}
+			d.oldDirectory, d.newDirectory = nil, nil
+			nextLevel = append(nextLevel, children...)
+		}
+
+		// Only load as many directories as permitted.
+		for i, d := range nextLevel {
+			directoriesNeeded := 0
+			if d.oldDigest != nil {
+				directoriesNeeded++
+			}
+			if d.newDigest != nil {
+				directoriesNeeded++
+			}
+			if directoriesNeeded > directoriesRemaining {
+				nextLevel = nextLevel[:i]
+				directoryLimitReached = true
+				break
+			}
+			directoriesRemaining -= directoriesNeeded
+		}
+
+		group, groupCtx := errgroup.WithContext(ctx)
+		group.SetLimit(s.archivePrefetchParallelism)
+		for _, d := range nextLevel {
+			if d.oldDigest != nil {
+				group.Go(func() error {
+					var err error
+					d.oldDirectory, err = getOldDirectory(groupCtx, *d.oldDigest)
+					return err
+				})
+			}
+			if d.newDigest != nil {
+				group.Go(func() error {
+					var err error
+					d.newDirectory, err = getNewDirectory(groupCtx, *d.newDigest)
+					return err
+				})
+			}
+			d.loaded = true
+		}
+		if err := group.Wait(); err != nil {
+			return nil, false, err
+		}
+		currentLevel = nextLevel
+	}
+	return root.appendEntries(nil), directoryLimitReached, nil
+}
+
+// equalDigestProtos returns whether two REv2 Digest messages refer
+// to the same object.
+func equalDigestProtos(a, b *remoteexecution.Digest) bool {
+	return a.GetHash() == b.GetHash() && a.GetSizeBytes() == b.GetSizeBytes()
+}
+
+// getDirectoryDiffSide loads the root directory of one side of a
+// directory diff, which may either be a Directory or a Tree stored in
+// the Content Addressable Storage. In addition to the root directory,
+// it returns a directoryGetter for loading its children, and the path
+// of the page displaying it.
+func (s *BrowserService) getDirectoryDiffSide(ctx context.Context, req *http.Request, typeVar, hashVar, sizeBytesVar string) (*remoteexecution.Directory, directoryGetter, string, error) {
+	rootDigest, err := getDigestFromRequestVars(req, hashVar, sizeBytesVar)
+	if err != nil {
+		return nil, nil, "", err
+	}
+	pageType := mux.Vars(req)[typeVar]
+	pagePath := fmt.Sprintf("%s/%s-%d", pageType, rootDigest.GetHashString(), rootDigest.GetSizeBytes())
+	if pageType == "tree" {
+		index, err := s.messageCache.GetTreeIndex(ctx, rootDigest)
+		if err != nil {
+			return nil, nil, "", err
+		}
+		return index.root, index.getDirectory, pagePath, nil
+	}
+
+	directory, err := s.getDirectory(ctx, rootDigest)
+	if err != nil {
+		return nil, nil, "", err
+	}
+	return directory, s.getDirectory, pagePath, nil
+}
+
+func (s *BrowserService) handleDirectoryDiff(w http.ResponseWriter, req *http.Request) {
+	digestFunction, err := getDigestFunctionFromRequest(req)
+	if err != nil {
+		s.renderError(w, req, err)
+		return
+	}
+
+	ctx := extractContextFromRequest(req)
+	oldDirectory, getOldDirectory, oldPath, err := s.getDirectoryDiffSide(ctx, req, "typeA", "hashA", "sizeBytesA")
+	if err != nil {
+		s.renderError(w, req, err)
+		return
+	}
+	newDirectory, getNewDirectory, newPath, err := s.getDirectoryDiffSide(ctx, req, "typeB", "hashB", "sizeBytesB")
+	if err != nil {
+		s.renderError(w, req, err)
+		return
+	}
+
+	directoryDiffPageInfo := directoryDiffPageInfo{
+		OldPath: oldPath,
+		NewPath: newPath,
+		Diff: directoryDiffInfo{
+			RootDirectory: "../../../../..",
+		},
+	}
+	if mux.Vars(req)["typeA"] == "tree" {
+		directoryDiffPageInfo.Diff.OldTreePath = oldPath
+	}
+	if mux.Vars(req)["typeB"] == "tree" {
+		directoryDiffPageInfo.Diff.NewTreePath = newPath
+	}
+	directoryDiffPageInfo.Diff.Entries, directoryDiffPageInfo.Diff.DirectoryLimitReached, err = s.diffDirectories(ctx, digestFunction, oldDirectory, newDirectory, getOldDirectory, getNewDirectory)
+	if err != nil {
+		s.renderError(w, req, err)
+		return
+	}
+
+	if err := s.templates.ExecuteTemplate(w, "page_directory_diff.html", &directoryDiffPageInfo); err != nil {
+		log.Print(err)
+	}
+}
@@ -0,0 +1,278 @@
+package main
+
+import (
+	"net/url"
+	"path"
+	"sort"
+	"strconv"
+	"strings"
+
+	remoteexecution "github.com/bazelbuild/remote-apis/build/bazel/remote/execution/v2"
+
+	"google.golang.org/grpc/codes"
+	"google.golang.org/grpc/status"
+)
+
+const (
+	// defaultDirectoryListingPageSize is the number of entries of a
+	// directory that are displayed on a single page if no page size
+	// is provided.
+	defaultDirectoryListingPageSize = 1000
+	// maximumDirectoryListingPageSize is the maximum number of
+	// entries of a directory that may be displayed on a single page,
+	// so that pages remain usable in web browsers.
+	maximumDirectoryListingPageSize = 10000
+)
+
+// directoryListingEntry is a single file, directory or symbolic link
+// contained in a directory listing. Exactly one of its fields is set.
+type directoryListingEntry struct {
+	Directory *remoteexecution.DirectoryNode
+	Symlink   *remoteexecution.SymlinkNode
+	File      *remoteexecution.FileNode
+}
+
+func (e *directoryListingEntry) getName() string {
+	if e.Directory != nil {
+		return e.Directory.Name
+	}
+	if e.Symlink != nil {
+		return e.Symlink.Name
+	}
+	return e.File.Name
+}
+
+func (e *directoryListingEntry) getSizeBytes() int64 {
+	if e.Directory != nil {
+		return e.Directory.Digest.GetSizeBytes()
+	}
+	if e.File != nil {
+		return e.File.Digest.GetSizeBytes()
+	}
+	return 0
+}
+
+// directoryListingOptions contains the parameters provided through
+// the query string of a page, controlling which entries of a directory
+// are displayed.
+type directoryListingOptions struct {
+	// Sort is the key by which entries are sorted. Valid values are
+	// "type", "name" and "size". Sorting by type lists directories,
+	// symbolic links and files, in that order, each sorted by name.
+	Sort       string
+	Descending bool
+	// Filter limits the entries to the ones whose name matches.
+	// Filters containing any of the characters "*?[" are treated
+	// as glob patterns, while other filters match substrings.
+	Filter   string
+	Page     int
+	PageSize int
+}
+
+// newDirectoryListingOptionsFromQuery parses the query parameters
+// "sort", "order", "filter", "page" and "page_size".
+func newDirectoryListingOptionsFromQuery(query url.Values) (directoryListingOptions, error) {
+	options := directoryListingOptions{
+		Sort:     "type",
+		Filter:   query.Get("filter"),
+		Page:     1,
+		PageSize: defaultDirectoryListingPageSize,
+	}
+	switch sortKey := query.Get("sort"); sortKey {
+	case "":
+	case "type", "name", "size":
+		options.Sort = sortKey
+	default:
+		return directoryListingOptions{}, status.Errorf(codes.InvalidArgument, "Invalid sort key %#v", sortKey)
+	}
+	switch order := query.Get("order"); order {
+	case "", "asc":
+	case "desc":
+		options.Descending = true
+	default:
+		return directoryListingOptions{}, status.Errorf(codes.InvalidArgument, "Invalid sort order %#v", order)
+	}
+	if isGlobPattern(options.Filter) {
+		if _, err := path.Match(options.Filter, ""); err != nil {
+			return directoryListingOptions{}, status.Errorf(codes.InvalidArgument, "Invalid glob pattern %#v", options.Filter)
+		}
+	}
+	if pageStr := query.Get("page"); pageStr != "" {
+		page, err := strconv.Atoi(pageStr)
+		if err != nil || page < 1 {
+			return directoryListingOptions{}, status.Errorf(codes.InvalidArgument, "Invalid page number %#v", pageStr)
+		}
+		options.Page = page
+	}
+	if pageSizeStr := query.Get("page_size"); pageSizeStr != "" {
+		pageSize, err := strconv.Atoi(pageSizeStr)
+		if err != nil || pageSize < 1 || pageSize > maximumDirectoryListingPageSize {
+			return directoryListingOptions{}, status.Errorf(codes.InvalidArgument, "Page size must be an integer between 1 and %d", maximumDirectoryListingPageSize)
+		}
+		options.PageSize = pageSize
+	}
+	return options, nil
+}
+
+func isGlobPattern(filter string) bool {
+	return strings.ContainsAny(filter, "*?[")
+}
+
+func (o *directoryListingOptions) matches(name string) bool {
+	if o.Filter == "" {
+		return true
+	}
+	if isGlobPattern(o.Filter) {
+		matched, _ := path.Match(o.Filter, name)
+		return matched
+	}
+	return strings.Contains(name, o.Filter)
+}
+
+// directoryListing contains a single page of the entries of a
+// directory, after filtering and sorting them. This prevents pages of
+// directories containing many entries from becoming too large to be
+// displayed by web browsers.
+type directoryListing struct {
+	Options         directoryListingOptions
+	Entries         []directoryListingEntry
+	TotalEntries    int
+	MatchingEntries int
+	PageCount       int
+
+	// The URL of the page on which the entries are displayed, and
+	// the query parameters that need to be preserved when linking
+	// to other pages of the same listing.
+	baseURL string
+	query   url.Values
+}
+
+// newDirectoryListing creates a directory listing of a Directory. The
+// base URL and query parameters are used to construct links to other
+// pages of the listing. An empty base URL refers to the current page.
+func newDirectoryListing(directory *remoteexecution.Directory, options directoryListingOptions, baseURL string, query url.Values) *directoryListing {
+	entries := make([]directoryListingEntry, 0, len(directory.Directories)+len(directory.Symlinks)+len(directory.Files))
+	for _, directoryNode := range directory.Directories {
+		if options.matches(directoryNode.Name) {
+			entries = append(entries, directoryListingEntry{Directory: directoryNode})
+		}
+	}
+	for _, symlinkNode := range directory.Symlinks {
+		if options.matches(symlinkNode.Name) {
+			entries = append(entries, directoryListingEntry{Symlink: symlinkNode})
+		}
+	}
+	for _, fileNode := range directory.Files {
+		if options.matches(fileNode.Name) {
+			entries = append(entries, directoryListingEntry{File: fileNode})
+		}
+	}
+
+	switch options.Sort {
+	case "name":
+		sort.SliceStable(entries, func(i, j int) bool { return entries[i].getName() < entries[j].getName() })
+	case "size":
+		sort.SliceStable(entries, func(i, j int) bool { return entries[i].getSizeBytes() < entries[j].getSizeBytes() })
+	}
+	if options.Descending {
+		for i, j := 0, len(entries)-1; i < j; i, j = i+1, j-1 {
+			entries[i], entries[j] = entries[j], entries[i]
+		}
+	}
+
+	// Extract the requested page. Requests for pages past the end
+	// of the listing yield the last page.
+	pageCount := (len(entries) + options.PageSize - 1) / options.PageSize
+	if pageCount < 1 {
+		pageCount = 1
+	}
+	if options.Page > pageCount {
+		options.Page = pageCount
+	}
+	start := (options.Page - 1) * options.PageSize
+	end := min(start+options.PageSize, len(entries))
+
+	return &directoryListing{
+		Options:         options,
+		Entries:         entries[start:end],
+		TotalEntries:    len(directory.Directories) + len(directory.Symlinks) + len(directory.Files),
+		MatchingEntries: len(entries),
+		PageCount:       pageCount,
+		baseURL:         baseURL,
+		query:           query,
+	}
+}
+
+func (dl *directoryListing) getURL(query url.Values) string {
+	if encoded := query.Encode(); encoded != "" {
+		return dl.baseURL + "?" + encoded
+	}
+	if dl.baseURL == "" {
+		return "?"
+	}
+	return dl.baseURL
+}
+
+func (dl *directoryListing) cloneQuery() url.Values {
+	query := url.Values{}
+	for key, values := range dl.query {
+		query[key] = append([]string(nil), values...)
+	}
+	return query
+}
+
+// GetPageURL returns a link to another page of the directory listing.
+func (dl *directoryListing) GetPageURL(page int) string {
+	query := dl.cloneQuery()
+	if page > 1 {
+		query.Set("page", strconv.Itoa(page))
+	} else {
+		query.Del("page")
+	}
+	return dl.getURL(query)
+}
+
+// GetPreviousPageURL returns a link to the page preceding the current
+// page of the directory listing.
+func (dl *directoryListing) GetPreviousPageURL() string {
+	return dl.GetPageURL(max(dl.Options.Page-1, 1))
+}
+
+// GetNextPageURL returns a link to the page following the current page
+// of the directory listing.
+func (dl *directoryListing) GetNextPageURL() string {
+	return dl.GetPageURL(min(dl.Options.Page+1, dl.PageCount))
+}
+
+// GetSortURL returns a link to the first page of the directory listing,
+// sorted by a given key. If the listing is already sorted by the key,
+// the sort order is reversed.
+func (dl *directoryListing) GetSortURL(sortKey string) string {
+	query := dl.cloneQuery()
+	query.Del("page")
+	query.Set("sort", sortKey)
+	if dl.Options.Sort == sortKey && !dl.Options.Descending {
+		query.Set("order", "desc")
+	} else {
+		query.Del("order")
+	}
+	return dl.getURL(query)
+}
+
+// GetBaseURL returns the URL to which the filter form is submitted.
+func (dl *directoryListing) GetBaseURL() string {
+	return dl.baseURL
+}
+
+// GetFormParameters returns the query parameters that need to be
+// preserved by the filter form, as submitting it replaces the query
+// string in its entirety.
+func (dl *directoryListing) GetFormParameters() map[string]string {
+	parameters := map[string]string{}
+	for key := range dl.query {
+		if key != "filter" && key != "page" {
+			parameters[key] = dl.query.Get(key)
+		}
+	}
+	return parameters
+}
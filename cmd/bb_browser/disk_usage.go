@@ -0,0 +1,548 @@
+package main
+
+import (
+	"context"
+	"fmt"
+	"html/template"
+	"image/color"
+	"log"
+	"net/http"
+	"net/url"
+	"path"
+	"sort"
+	"strings"
+
+	remoteexecution "github.com/bazelbuild/remote-apis/build/bazel/remote/execution/v2"
+	"github.com/buildbarn/bb-storage/pkg/digest"
+	"github.com/dustin/go-humanize"
+
+	"golang.org/x/sync/semaphore"
+
+	"google.golang.org/grpc/codes"
+	"google.golang.org/grpc/status"
+
+	"gonum.org/v1/plot"
+	"gonum.org/v1/plot/vg"
+	"gonum.org/v1/plot/vg/draw"
+)
+
+const (
+	// maximumDiskUsageRows is the maximum number of directories
+	// that are displayed in the table of disk usage pages, so that
+	// pages of large directory hierarchies remain usable.
+	maximumDiskUsageRows = 1000
+	// maximumTreemapChildren is the maximum number of children of
+	// a directory that are drawn in a treemap individually. The
+	// remaining children are drawn as a single rectangle.
+	maximumTreemapChildren = 100
+)
+
+// diskUsageDirectory contains the recursive size of a directory
+// contained in a directory hierarchy. Sizes are computed both with
+// and without counting files with identical contents only once.
+type diskUsageDirectory struct {
+	Path            string
+	FileCount       int64
+	TotalSizeBytes  int64
+	UniqueSizeBytes int64
+}
+
+// diskUsageSubtree contains the recursive size of all directories in
+// a directory hierarchy that share the same digest, so that the size
+// of directories that occur multiple times is only computed once.
+type diskUsageSubtree struct {
+	fileCount       int64
+	totalSizeBytes  int64
+	uniqueSizeBytes int64
+	// The digests of all files contained in the subtree, whose sizes
+	// add up to uniqueSizeBytes. It is discarded once all parents
+	// have merged it into their own.
+	uniqueFiles map[digest.Digest]struct{}
+	// All child directories, including ones sharing the same digest.
+	children []*diskUsageSubtree
+	// The number of distinct parents that have not merged
+	// uniqueFiles into their own yet.
+	pendingParents int
+	computed       bool
+}
+
+// addUniqueFile adds a file to the set of unique files of a subtree,
+// without updating its file count and total size.
+func (t *diskUsageSubtree) addUniqueFile(fileDigest digest.Digest) {
+	if _, ok := t.uniqueFiles[fileDigest]; !ok {
+		t.uniqueFiles[fileDigest] = struct{}{}
+		t.uniqueSizeBytes += fileDigest.GetSizeBytes()
+	}
+}
+
+// compute the recursive size of a subtree, based on the files
+// contained in the directory itself and the recursive sizes of its
+// children. Instead of copying the unique files of every child, the
+// largest set that is no longer needed by any other parent is reused,
+// so that files are only inserted into a small number of sets.
+func (t *diskUsageSubtree) compute() {
+	if t.computed {
+		return
+	}
+	t.computed = true
+
+	var distinctChildren []*diskUsageSubtree
+	seenChildren := map[*diskUsageSubtree]struct{}{}
+	var reusableChild *diskUsageSubtree
+	for _, child := range t.children {
+		child.compute()
+		t.fileCount += child.fileCount
+		t.totalSizeBytes += child.totalSizeBytes
+		if _, ok := seenChildren[child]; ok {
+			continue
+		}
+		seenChildren[child] = struct{}{}
+		distinctChildren = append(distinctChildren, child)
+		if child.pendingParents == 1 && (reusableChild == nil || len(child.uniqueFiles) > len(reusableChild.uniqueFiles)) {
+			reusableChild = child
+		}
+	}
+
+	if reusableChild != nil && len(reusableChild.uniqueFiles) > len(t.uniqueFiles) {
+		ownFiles := t.uniqueFiles
+		t.uniqueFiles, t.uniqueSizeBytes = reusableChild.uniqueFiles, reusableChild.uniqueSizeBytes
+		reusableChild.uniqueFiles = nil
+		for fileDigest := range ownFiles {
+			t.addUniqueFile(fileDigest)
+		}
+	}
+	for _, child := range distinctChildren {
+		for fileDigest := range child.uniqueFiles {
+			t.addUniqueFile(fileDigest)
+		}
+		child.pendingParents--
+		if child.pendingParents == 0 {
+			child.uniqueFiles = nil
+		}
+	}
+}
+
+// diskUsageNode is a file or directory that is drawn in a treemap.
+type diskUsageNode struct {
+	name      string
+	sizeBytes int64
+	children  []*diskUsageNode
+}
+
+// diskUsageInfo contains the information that we display on pages
+// breaking down the disk usage of a directory hierarchy.
+type diskUsageInfo struct {
+	Digest      digest.Digest
+	Root        *diskUsageDirectory
+	Directories []*diskUsageDirectory
+	// The number of directories in the hierarchy, not including the
+	// root directory.
+	DirectoryCount int64
+	// Whether directories have been omitted from the table, due
+	// to the hierarchy containing too many of them.
+	Truncated  bool
+	Sort       string
+	Descending bool
+	Treemap    template.HTML
+}
+
+// GetSortURL returns a link to the disk usage page, sorted by a given
+// key. If the page is already sorted by the key, the sort order is
+// reversed.
+func (di *diskUsageInfo) GetSortURL(sortKey string) string {
+	query := url.Values{}
+	query.Set("format", "du")
+	query.Set("sort", sortKey)
+	if di.Sort == sortKey {
+		if di.Descending {
+			query.Set("order", "asc")
+		} else {
+			query.Set("order", "desc")
+		}
+	}
+	return "?" + query.Encode()
+}
+
+// isDiskUsageFormat returns whether the format requested through the
+// "format" query parameter causes the disk usage of a directory
+// hierarchy to be displayed.
+func isDiskUsageFormat(format string) bool {
+	return format == "du"
+}
+
+// computeDiskUsage computes the recursive size of every directory in
+// a directory hierarchy. Directories in the hierarchy that have the
+// same digest share a single diskUsageSubtree, meaning that the
+// contents of every distinct directory are only accounted for once.
+// Sizes are then computed bottom-up, starting at the root directory.
+func (s *BrowserService) computeDiskUsage(ctx context.Context, directoryDigest digest.Digest, directory *remoteexecution.Directory, getDirectory directoryGetter) (*diskUsageDirectory, []*diskUsageDirectory, *diskUsageNode, error) {
+	// diskUsageOccurrence is a directory at a given path in the
+	// hierarchy. Only the first occurrence of every digest is
+	// used to populate its subtree.
+	type diskUsageOccurrence struct {
+		directory *diskUsageDirectory
+		subtree   *diskUsageSubtree
+		node      *diskUsageNode
+		populate  bool
+	}
+	newSubtree := func() *diskUsageSubtree {
+		return &diskUsageSubtree{uniqueFiles: map[digest.Digest]struct{}{}}
+	}
+
+	rootSubtree := newSubtree()
+	root := &diskUsageOccurrence{
+		directory: &diskUsageDirectory{Path: "."},
+		subtree:   rootSubtree,
+		node:      &diskUsageNode{},
+		populate:  true,
+	}
+	subtrees := map[digest.Digest]*diskUsageSubtree{directoryDigest: rootSubtree}
+	occurrences := map[string]*diskUsageOccurrence{".": root}
+	occurrenceList := []*diskUsageOccurrence{root}
+	memory := semaphore.NewWeighted(s.archivePrefetchMemoryBudgetBytes)
+	if _, err := s.walkArchiveEntries(ctx, directoryDigest.GetDigestFunction(), directory, getDirectory, memory, 0, func(entry *archiveEntry) error {
+		if entry.entryType == archiveEntryTypeSymlink {
+			return nil
+		}
+		parent := occurrences[path.Dir(entry.path)]
+
+		// Treemaps only display the top two levels of the
+		// hierarchy, so there is no need to create nodes for
+		// anything deeper.
+		var node *diskUsageNode
+		if parent.node != nil && strings.Count(entry.path, "/") < 2 {
+			node = &diskUsageNode{name: path.Base(entry.path)}
+			parent.node.children = append(parent.node.children, node)
+		}
+
+		if entry.entryType == archiveEntryTypeDirectory {
+			subtree, ok := subtrees[entry.digest]
+			if !ok {
+				subtree = newSubtree()
+				subtrees[entry.digest] = subtree
+			}
+			if parent.populate {
+				parent.subtree.children = append(parent.subtree.children, subtree)
+			}
+			occurrence := &diskUsageOccurrence{
+				directory: &diskUsageDirectory{Path: entry.path},
+				subtree:   subtree,
+				node:      node,
+				populate:  !ok,
+			}
+			occurrences[entry.path] = occurrence
+			occurrenceList = append(occurrenceList, occurrence)
+			return nil
+		}
+
+		sizeBytes := entry.digest.GetSizeBytes()
+		if node != nil {
+			node.sizeBytes = sizeBytes
+		}
+		if parent.populate {
+			parent.subtree.fileCount++
+			parent.subtree.totalSizeBytes += sizeBytes
+			parent.subtree.addUniqueFile(entry.digest)
+		}
+		return nil
+	}); err != nil {
+		return nil, nil, nil, err
+	}
+
+	// Count the number of distinct parents of every subtree, so
+	// that its set of unique files can be discarded or reused by
+	// the last parent that needs it.
+	for _, subtree := range subtrees {
+		seenChildren := map[*diskUsageSubtree]struct{}{}
+		for _, child := range subtree.children {
+			if _, ok := seenChildren[child]; !ok {
+				seenChildren[child] = struct{}{}
+				child.pendingParents++
+			}
+		}
+	}
+	rootSubtree.compute()
+
+	directories := make([]*diskUsageDirectory, 0, len(occurrenceList))
+	for _, occurrence := range occurrenceList {
+		directory, subtree := occurrence.directory, occurrence.subtree
+		directory.FileCount = subtree.fileCount
+		directory.TotalSizeBytes = subtree.totalSizeBytes
+		directory.UniqueSizeBytes = subtree.uniqueSizeBytes
+		if occurrence.node != nil {
+			occurrence.node.sizeBytes = subtree.totalSizeBytes
+		}
+		directories = append(directories, directory)
+	}
+	return root.directory, directories, root.node, nil
+}
+
+// sortDiskUsageDirectories sorts the directories displayed on disk
+// usage pages by a given key. Directories that are equal according to
+// the key are sorted by path.
+func sortDiskUsageDirectories(directories []*diskUsageDirectory, sortKey string, descending bool) {
+	var key func(d *diskUsageDirectory) int64
+	switch sortKey {
+	case "files":
+		key = func(d *diskUsageDirectory) int64 { return d.FileCount }
+	case "size":
+		key = func(d *diskUsageDirectory) int64 { return d.TotalSizeBytes }
+	case "unique_size":
+		key = func(d *diskUsageDirectory) int64 { return d.UniqueSizeBytes }
+	default:
+		key = func(d *diskUsageDirectory) int64 { return 0 }
+	}
+	sort.Slice(directories, func(i, j int) bool {
+		a, b := directories[i], directories[j]
+		if descending {
+			a, b = b, a
+		}
+		if ka, kb := key(a), key(b); ka != kb {
+			return ka < kb
+		}
+		if sortKey == "path" {
+			return a.Path < b.Path
+		}
+		return directories[i].Path < directories[j].Path
+	})
+}
+
+// generateDiskUsage renders a page breaking down the disk usage of a
+// directory hierarchy, consisting of a treemap and a table containing
+// the recursive size of every directory.
+func (s *BrowserService) generateDiskUsage(ctx context.Context, w http.ResponseWriter, req *http.Request, directoryDigest digest.Digest, directory *remoteexecution.Directory, getDirectory directoryGetter) {
+	query := req.URL.Query()
+	info := diskUsageInfo{
+		Digest:     directoryDigest,
+		Sort:       "size",
+		Descending: true,
+	}
+	switch sortKey := query.Get("sort"); sortKey {
+	case "":
+	case "path", "files", "size", "unique_size":
+		info.Sort = sortKey
+		info.Descending = sortKey != "path"
+	default:
+		s.renderError(w, req, status.Errorf(codes.InvalidArgument, "Invalid sort key %#v", sortKey))
+		return
+	}
+	switch order := query.Get("order"); order {
+	case "":
+	case "asc":
+		info.Descending = false
+	case "desc":
+		info.Descending = true
+	default:
+		s.renderError(w, req, status.Errorf(codes.InvalidArgument, "Invalid sort order %#v", order))
+		return
+	}
+
+	root, directories, rootNode, err := s.computeDiskUsage(ctx, directoryDigest, directory, getDirectory)
+	if err != nil {
+		s.renderError(w, req, err)
+		return
+	}
+	info.Root = root
+	info.DirectoryCount = int64(len(directories) - 1)
+	sortDiskUsageDirectories(directories, info.Sort, info.Descending)
+	if len(directories) > maximumDiskUsageRows {
+		directories = directories[:maximumDiskUsageRows]
+		info.Truncated = true
+	}
+	info.Directories = directories
+
+	if rootNode.sizeBytes > 0 {
+		p := plot.New()
+		p.HideAxes()
+		p.Add(&diskUsageTreemap{root: rootNode})
+		var graph strings.Builder
+		writerTo, err := p.WriterTo(30*vg.Centimeter, 15*vg.Centimeter, "svg")
+		if err != nil {
+			s.renderError(w, req, err)
+			return
+		}
+		if _, err := writerTo.WriteTo(&graph); err != nil {
+			s.renderError(w, req, err)
+			return
+		}
+		info.Treemap = template.HTML(graph.String())
+	}
+
+	if err := s.templates.ExecuteTemplate(w, "page_disk_usage.html", &info); err != nil {
+		log.Print(err)
+	}
+}
+
+// treemapColors are the colors of the rectangles of directories and
+// files at the top level of treemaps.
+var treemapColors = []color.RGBA{
+	{R: 13, G: 110, B: 253, A: 255},
+	{R: 25, G: 135, B: 84, A: 255},
+	{R: 253, G: 126, B: 20, A: 255},
+	{R: 111, G: 66, B: 193, A: 255},
+	{R: 214, G: 51, B: 132, A: 255},
+	{R: 32, G: 201, B: 151, A: 255},
+}
+
+// diskUsageTreemap is a plot.Plotter that draws a treemap of a
+// directory hierarchy. The area of every rectangle is proportional to
+// the size of the file or directory it represents. Only the top two
+// levels of the directory hierarchy are drawn.
+type diskUsageTreemap struct {
+	root *diskUsageNode
+}
+
+// getTreemapChildren returns the children of a directory that are
+// drawn in a treemap, in decreasing order of size. If the directory
+// has many children, the smallest ones are combined.
+func getTreemapChildren(node *diskUsageNode) []*diskUsageNode {
+	children := make([]*diskUsageNode, 0, len(node.children))
+	for _, child := range node.children {
+		if child.sizeBytes > 0 {
+			children = append(children, child)
+		}
+	}
+	sort.SliceStable(children, func(i, j int) bool { return children[i].sizeBytes > children[j].sizeBytes })
+	if len(children) > maximumTreemapChildren {
+		other := &diskUsageNode{
+			name: fmt.Sprintf("%d more", len(children)-maximumTreemapChildren+1),
+		}
+		for _, child := range children[maximumTreemapChildren-1:] {
+			other.sizeBytes += child.sizeBytes
+		}
+		children = append(children[:maximumTreemapChildren-1], other)
+	}
+	return children
+}
+
+// Plot draws the treemap onto the canvas of the plot.
+func (t *diskUsageTreemap) Plot(c draw.Canvas, p *plot.Plot) {
+	textStyle := p.Title.TextStyle
+	textStyle.Font.Size = vg.Points(8)
+	textStyle.XAlign = draw.XLeft
+	textStyle.YAlign = draw.YTop
+	padding := vg.Points(2)
+	headerHeight := textStyle.Height("M") + 2*padding
+
+	children := getTreemapChildren(t.root)
+	for i, rectangle := range squarifyTreemap(children, c.Rectangle) {
+		child := children[i]
+		fillColor := treemapColors[i%len(treemapColors)]
+		drawTreemapRectangle(&c, rectangle, fillColor, textStyle, padding, child)
+
+		// Draw the children of directories, if there is
+		// enough space to do so.
+		inner := vg.Rectangle{
+			Min: vg.Point{X: rectangle.Min.X + padding, Y: rectangle.Min.Y + padding},
+			Max: vg.Point{X: rectangle.Max.X - padding, Y: rectangle.Max.Y - headerHeight},
+		}
+		if len(child.children) == 0 || inner.Size().X < 4*headerHeight || inner.Size().Y < 2*headerHeight {
+			continue
+		}
+		grandchildren := getTreemapChildren(child)
+		childColor := lightenColor(fillColor)
+		for j, childRectangle := range squarifyTreemap(grandchildren, inner) {
+			drawTreemapRectangle(&c, childRectangle, childColor, textStyle, padding, grandchildren[j])
+		}
+	}
+}
+
+// lightenColor blends a color with white, so that the children of a
+// directory can be told apart from the directory itself.
+func lightenColor(c color.RGBA) color.RGBA {
+	return color.RGBA{
+		R: uint8((int(c.R) + 2*255) / 3),
+		G: uint8((int(c.G) + 2*255) / 3),
+		B: uint8((int(c.B) + 2*255) / 3),
+		A: c.A,
+	}
+}
+
+// drawTreemapRectangle draws a single rectangle of a treemap, labeled
+// with the name and size of the file or directory it represents if it
+// fits.
+func drawTreemapRectangle(c *draw.Canvas, rectangle vg.Rectangle, fillColor color.Color, textStyle draw.TextStyle, padding vg.Length, node *diskUsageNode) {
+	corners := []vg.Point{
+		rectangle.Min,
+		{X: rectangle.Max.X, Y: rectangle.Min.Y},
+		rectangle.Max,
+		{X: rectangle.Min.X, Y: rectangle.Max.Y},
+	}
+	c.FillPolygon(fillColor, corners)
+	c.StrokeLines(draw.LineStyle{Color: color.White, Width: vg.Points(0.5)}, append(corners, rectangle.Min))
+
+	label := fmt.Sprintf("%s (%s)", node.name, humanize.Bytes(uint64(node.sizeBytes)))
+	size := rectangle.Size()
+	if textStyle.Width(label)+2*padding <= size.X && textStyle.Height(label)+2*padding <= size.Y {
+		c.FillText(textStyle, vg.Point{X: rectangle.Min.X + padding, Y: rectangle.Max.Y - padding}, label)
+	}
+}
+
+// squarifyTreemap partitions a rectangle into rectangles whose areas
+// are proportional to the sizes of the provided nodes, using the
+// squarified treemap algorithm by Bruls, Huizing and van Wijk. Nodes
+// need to be sorted in decreasing order of size.
+func squarifyTreemap(nodes []*diskUsageNode, rectangle vg.Rectangle) []vg.Rectangle {
+	var totalSizeBytes int64
+	for _, node := range nodes {
+		totalSizeBytes += node.sizeBytes
+	}
+	rectangles := make([]vg.Rectangle, 0, len(nodes))
+	if totalSizeBytes == 0 {
+		return rectangles
+	}
+	size := rectangle.Size()
+	scale := float64(size.X) * float64(size.Y) / float64(totalSizeBytes)
+	areas := make([]float64, 0, len(nodes))
+	for _, node := range nodes {
+		areas = append(areas, float64(node.sizeBytes)*scale)
+	}
+
+	// worstAspectRatio returns the largest aspect ratio of the
+	// rectangles in a row that is placed along a side of the given
+	// length.
+	worstAspectRatio := func(row []float64, rowArea, side float64) float64 {
+		largest, smallest := row[0], row[len(row)-1]
+		return max(side*side*largest/(rowArea*rowArea), rowArea*rowArea/(side*side*smallest))
+	}
+
+	for i := 0; i < len(areas); {
+		size := rectangle.Size()
+		side := float64(min(size.X, size.Y))
+
+		// Add rectangles to the current row for as long as it
+		// doesn't make the row less square.
+		j, rowArea := i+1, areas[i]
+		for j < len(areas) && worstAspectRatio(areas[i:j+1], rowArea+areas[j], side) <= worstAspectRatio(areas[i:j], rowArea, side) {
+			rowArea += areas[j]
+			j++
+		}
+
+		// Place the row along the shorter side of the remaining
+		// space, and shrink the remaining space accordingly.
+		thickness := vg.Length(rowArea / side)
+		offset := vg.Length(0)
+		for _, area := range areas[i:j] {
+			length := vg.Length(area) / thickness
+			if size.X >= size.Y {
+				rectangles = append(rectangles, vg.Rectangle{
+					Min: vg.Point{X: rectangle.Min.X, Y: rectangle.Max.Y - offset - length},
+					Max: vg.Point{X: rectangle.Min.X + thickness, Y: rectangle.Max.Y - offset},
+				})
+			} else {
+				rectangles = append(rectangles, vg.Rectangle{
+					Min: vg.Point{X: rectangle.Min.X + offset, Y: rectangle.Max.Y - thickness},
+					Max: vg.Point{X: rectangle.Min.X + offset + length, Y: rectangle.Max.Y},
+				})
+			}
+			offset += length
+		}
+		if size.X >= size.Y {
+			rectangle.Min.X += thickness
+		} else {
+			rectangle.Max.Y -= thickness
+		}
+		i = j
+	}
+	return rectangles
+}
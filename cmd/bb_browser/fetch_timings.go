@@ -0,0 +1,80 @@
+package main
+
+import (
+	"fmt"
+	"net/http"
+	"sort"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/buildbarn/bb-storage/pkg/util"
+	"github.com/prometheus/client_golang/prometheus"
+)
+
+var (
+	browserServicePrometheusMetrics sync.Once
+
+	browserServiceFetchDurationSeconds = prometheus.NewHistogramVec(
+		prometheus.HistogramOpts{
+			Namespace: "buildbarn",
+			Subsystem: "browser",
+			Name:      "fetch_duration_seconds",
+			Help:      "Amount of time spent fetching objects displayed on pages, in seconds.",
+			Buckets:   util.DecimalExponentialBuckets(-3, 6, 2),
+		},
+		[]string{"page", "object"})
+)
+
+// fetchTiming is the amount of time it took to fetch a single object
+// displayed on a page.
+type fetchTiming struct {
+	object   string
+	duration time.Duration
+}
+
+// fetchTimings keeps track of how long it took to fetch each of the
+// objects that are displayed on a page. These durations are exposed
+// as Prometheus metrics, and are returned to the client through the
+// Server-Timing header, so that they can be inspected using the
+// developer tools of web browsers.
+type fetchTimings struct {
+	page string
+
+	lock    sync.Mutex
+	timings []fetchTiming
+}
+
+func newFetchTimings(page string) *fetchTimings {
+	return &fetchTimings{page: page}
+}
+
+// record the amount of time it took to fetch an object, measured from
+// a given point in time until now.
+func (ft *fetchTimings) record(object string, start time.Time) {
+	duration := time.Since(start)
+	browserServiceFetchDurationSeconds.WithLabelValues(ft.page, object).Observe(duration.Seconds())
+
+	ft.lock.Lock()
+	ft.timings = append(ft.timings, fetchTiming{
+		object:   object,
+		duration: duration,
+	})
+	ft.lock.Unlock()
+}
+
+// setHeader adds a Server-Timing header to the HTTP response,
+// containing the durations of all fetches recorded so far.
+func (ft *fetchTimings) setHeader(w http.ResponseWriter) {
+	ft.lock.Lock()
+	defer ft.lock.Unlock()
+
+	sort.Slice(ft.timings, func(i, j int) bool { return ft.timings[i].object < ft.timings[j].object })
+	metrics := make([]string, 0, len(ft.timings))
+	for _, timing := range ft.timings {
+		metrics = append(metrics, fmt.Sprintf("%s;dur=%.3f", timing.object, float64(timing.duration)/float64(time.Millisecond)))
+	}
+	if len(metrics) > 0 {
+		w.Header().Set("Server-Timing", strings.Join(metrics, ", "))
+	}
+}
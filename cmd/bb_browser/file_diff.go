@@ -0,0 +1,296 @@
+package main
+
+import (
+	"bytes"
+	"log"
+	"net/http"
+	"strings"
+	"unicode/utf8"
+
+	"github.com/buildbarn/bb-storage/pkg/digest"
+	"github.com/buildbarn/bb-storage/pkg/util"
+)
+
+const (
+	// maximumFileDiffEdits is the maximum number of lines that are
+	// permitted to differ between two files when computing a line
+	// based diff. Files that differ more are displayed as being
+	// replaced entirely.
+	maximumFileDiffEdits = 2000
+
+	// fileDiffContextLines is the number of unchanged lines that are
+	// displayed before and after every change.
+	fileDiffContextLines = 3
+)
+
+// fileDiffLine is a single line of a unified diff between two text
+// files. Line numbers are zero for lines that are not present in the
+// respective file. MissingNewline is set for the last line of a file
+// that is not terminated by a newline character.
+type fileDiffLine struct {
+	OldLineNumber  int
+	NewLineNumber  int
+	Text           string
+	MissingNewline bool
+	Removed        bool
+	Added          bool
+}
+
+// fileDiffSplitRow is a single row of a side-by-side diff between two
+// text files. Old is nil for lines that were added, while New is nil
+// for lines that were removed.
+type fileDiffSplitRow struct {
+	Old *fileDiffLine
+	New *fileDiffLine
+}
+
+// fileDiffHunk is a range of lines in which two text files differ,
+// surrounded by a small number of unchanged lines.
+type fileDiffHunk struct {
+	OldStart  int
+	OldCount  int
+	NewStart  int
+	NewCount  int
+	Lines     []fileDiffLine
+	SplitRows []fileDiffSplitRow
+}
+
+// fileDiffBinarySummary summarizes the differences between two files
+// that do not contain text.
+type fileDiffBinarySummary struct {
+	CommonPrefixSizeBytes  int
+	CommonSuffixSizeBytes  int
+	DifferingBytesInCommon int
+}
+
+// fileDiffInfo contains the information that we display on pages
+// comparing two files.
+type fileDiffInfo struct {
+	OldDigest digest.Digest
+	NewDigest digest.Digest
+	Name      string
+	Split     bool
+
+	Identical            bool
+	MaximumFileSizeBytes int
+	BinarySummary        *fileDiffBinarySummary
+	Hunks                []fileDiffHunk
+}
+
+// IsTooLarge returns whether any of the files exceeds the maximum size
+// of files that may be compared.
+func (fi *fileDiffInfo) IsTooLarge() bool {
+	return fi.OldDigest.GetSizeBytes() > int64(fi.MaximumFileSizeBytes) || fi.NewDigest.GetSizeBytes() > int64(fi.MaximumFileSizeBytes)
+}
+
+// isTextFile returns whether the contents of a file are likely text,
+// meaning that a line based diff can be displayed.
+func isTextFile(data []byte) bool {
+	return utf8.Valid(data) && bytes.IndexByte(data, 0) < 0
+}
+
+// splitLines splits the contents of a text file into lines. Lines
+// retain their trailing newline character, so that a missing newline
+// at the end of the file is also reported as a difference.
+func splitLines(text string) []string {
+	lines := strings.SplitAfter(text, "\n")
+	if lines[len(lines)-1] == "" {
+		lines = lines[:len(lines)-1]
+	}
+	return lines
+}
+
+func newFileDiffLine(text string, oldLineNumber, newLineNumber int) fileDiffLine {
+	trimmedText, hasNewline := strings.CutSuffix(text, "\n")
+	return fileDiffLine{
+		OldLineNumber:  oldLineNumber,
+		NewLineNumber:  newLineNumber,
+		Text:           trimmedText,
+		MissingNewline: !hasNewline,
+	}
+}
+
+// getFileDiffHunks computes a line based diff between two text files,
+// grouping changed lines into hunks.
+func getFileDiffHunks(oldLines, newLines []string) []fileDiffHunk {
+	operations := diffSequences(
+		len(oldLines),
+		len(newLines),
+		func(oldIndex, newIndex int) bool { return oldLines[oldIndex] == newLines[newIndex] },
+		maximumFileDiffEdits)
+
+	// Line numbers at which hunks start are computed by keeping
+	// track of the number of lines in both files that precede the
+	// operation at position.
+	var hunks []fileDiffHunk
+	position, oldLinesBefore, newLinesBefore := 0, 0, 0
+	for i := 0; i < len(operations); {
+		// Find the next change.
+		for i < len(operations) && operations[i].Type == diffOperationTypeEqual {
+			i++
+		}
+		if i == len(operations) {
+			break
+		}
+
+		// Extend the hunk up to the point where two consecutive
+		// changes are separated by more unchanged lines than can
+		// be displayed as context.
+		start := max(i-fileDiffContextLines, 0)
+		end := i
+		for end < len(operations) {
+			if operations[end].Type != diffOperationTypeEqual {
+				end++
+				continue
+			}
+			equalEnd := end
+			for equalEnd < len(operations) && operations[equalEnd].Type == diffOperationTypeEqual {
+				equalEnd++
+			}
+			if equalEnd == len(operations) || equalEnd-end > 2*fileDiffContextLines {
+				end = min(end+fileDiffContextLines, len(operations))
+				break
+			}
+			end = equalEnd
+		}
+
+		var hunk fileDiffHunk
+		var removed, added []int
+		flushSplitRows := func() {
+			for j := 0; j < len(removed) || j < len(added); j++ {
+				var row fileDiffSplitRow
+				if j < len(removed) {
+					row.Old = &hunk.Lines[removed[j]]
+				}
+				if j < len(added) {
+					row.New = &hunk.Lines[added[j]]
+				}
+				hunk.SplitRows = append(hunk.SplitRows, row)
+			}
+			removed, added = removed[:0], added[:0]
+		}
+		hunk.Lines = make([]fileDiffLine, 0, end-start)
+		for _, operation := range operations[start:end] {
+			switch operation.Type {
+			case diffOperationTypeEqual:
+				hunk.Lines = append(hunk.Lines, newFileDiffLine(oldLines[operation.OldIndex], operation.OldIndex+1, operation.NewIndex+1))
+				hunk.OldCount++
+				hunk.NewCount++
+			case diffOperationTypeDelete:
+				line := newFileDiffLine(oldLines[operation.OldIndex], operation.OldIndex+1, 0)
+				line.Removed = true
+				hunk.Lines = append(hunk.Lines, line)
+				hunk.OldCount++
+			case diffOperationTypeInsert:
+				line := newFileDiffLine(newLines[operation.NewIndex], 0, operation.NewIndex+1)
+				line.Added = true
+				hunk.Lines = append(hunk.Lines, line)
+				hunk.NewCount++
+			}
+		}
+
+		// Convert the lines to rows of a side-by-side diff.
+		// Consecutive removed and added lines are placed next to
+		// each other.
+		for j, line := range hunk.Lines {
+			switch {
+			case line.Removed:
+				if len(added) > 0 {
+					flushSplitRows()
+				}
+				removed = append(removed, j)
+			case line.Added:
+				added = append(added, j)
+			default:
+				flushSplitRows()
+				hunk.SplitRows = append(hunk.SplitRows, fileDiffSplitRow{
+					Old: &hunk.Lines[j],
+					New: &hunk.Lines[j],
+				})
+			}
+		}
+		flushSplitRows()
+
+		for ; position < start; position++ {
+			if operations[position].Type != diffOperationTypeInsert {
+				oldLinesBefore++
+			}
+			if operations[position].Type != diffOperationTypeDelete {
+				newLinesBefore++
+			}
+		}
+		hunk.OldStart, hunk.NewStart = oldLinesBefore+1, newLinesBefore+1
+		hunks = append(hunks, hunk)
+		i = end
+	}
+	return hunks
+}
+
+// getFileDiffBinarySummary computes a summary of the differences
+// between two files that do not contain text.
+func getFileDiffBinarySummary(oldData, newData []byte) *fileDiffBinarySummary {
+	var summary fileDiffBinarySummary
+	commonSizeBytes := min(len(oldData), len(newData))
+	for summary.CommonPrefixSizeBytes < commonSizeBytes && oldData[summary.CommonPrefixSizeBytes] == newData[summary.CommonPrefixSizeBytes] {
+		summary.CommonPrefixSizeBytes++
+	}
+	for summary.CommonSuffixSizeBytes < commonSizeBytes-summary.CommonPrefixSizeBytes && oldData[len(oldData)-summary.CommonSuffixSizeBytes-1] == newData[len(newData)-summary.CommonSuffixSizeBytes-1] {
+		summary.CommonSuffixSizeBytes++
+	}
+	for i := 0; i < commonSizeBytes; i++ {
+		if oldData[i] != newData[i] {
+			summary.DifferingBytesInCommon++
+		}
+	}
+	return &summary
+}
+
+func (s *BrowserService) handleFileDiff(w http.ResponseWriter, req *http.Request) {
+	oldDigest, err := getDigestFromRequestVars(req, "hashA", "sizeBytesA")
+	if err != nil {
+		s.renderError(w, req, err)
+		return
+	}
+	newDigest, err := getDigestFromRequestVars(req, "hashB", "sizeBytesB")
+	if err != nil {
+		s.renderError(w, req, err)
+		return
+	}
+
+	query := req.URL.Query()
+	fileDiffInfo := fileDiffInfo{
+		OldDigest:            oldDigest,
+		NewDigest:            newDigest,
+		Name:                 query.Get("name"),
+		Split:                query.Get("view") == "split",
+		Identical:            oldDigest == newDigest,
+		MaximumFileSizeBytes: s.maximumFileDiffSizeBytes,
+	}
+	if fileDiffInfo.Name == "" {
+		fileDiffInfo.Name = "file"
+	}
+
+	if !fileDiffInfo.Identical && !fileDiffInfo.IsTooLarge() {
+		ctx := extractContextFromRequest(req)
+		oldData, err := s.contentAddressableStorage.Get(ctx, oldDigest).ToByteSlice(s.maximumFileDiffSizeBytes)
+		if err != nil {
+			s.renderError(w, req, util.StatusWrapf(err, "Failed to obtain old file %#v", oldDigest.String()))
+			return
+		}
+		newData, err := s.contentAddressableStorage.Get(ctx, newDigest).ToByteSlice(s.maximumFileDiffSizeBytes)
+		if err != nil {
+			s.renderError(w, req, util.StatusWrapf(err, "Failed to obtain new file %#v", newDigest.String()))
+			return
+		}
+
+		if isTextFile(oldData) && isTextFile(newData) {
+			fileDiffInfo.Hunks = getFileDiffHunks(splitLines(string(oldData)), splitLines(string(newData)))
+		} else {
+			fileDiffInfo.BinarySummary = getFileDiffBinarySummary(oldData, newData)
+		}
+	}
+
+	if err := s.templates.ExecuteTemplate(w, "page_file_diff.html", &fileDiffInfo); err != nil {
+		log.Print(err)
+	}
+}
@@ -0,0 +1,295 @@
+package main
+
+import (
+	"html/template"
+	"log"
+	"net/http"
+	"net/url"
+	"path"
+	"strings"
+	"unicode/utf8"
+
+	"github.com/buildbarn/bb-storage/pkg/digest"
+	"github.com/gorilla/mux"
+)
+
+// maximumFileViewerSizeBytes is the maximum size of files that are
+// displayed by the file viewer. Larger files are served as is, as
+// highlighting them and rendering every line as HTML is too expensive.
+const maximumFileViewerSizeBytes = 1 << 20
+
+// fileViewerLanguage describes the syntax of a programming language,
+// in sufficient detail to highlight comments, string literals,
+// numbers and keywords.
+type fileViewerLanguage struct {
+	name          string
+	lineComments  []string
+	blockComments [][2]string
+	// Characters that delimit string literals that cannot span
+	// multiple lines, and in which backslashes escape characters.
+	quotes string
+	// Delimiters of string literals that may span multiple lines.
+	multiLineQuotes []string
+	keywords        map[string]struct{}
+}
+
+func newFileViewerLanguage(name string, lineComments []string, blockComments [][2]string, quotes string, multiLineQuotes []string, keywords string) *fileViewerLanguage {
+	l := &fileViewerLanguage{
+		name:            name,
+		lineComments:    lineComments,
+		blockComments:   blockComments,
+		quotes:          quotes,
+		multiLineQuotes: multiLineQuotes,
+		keywords:        map[string]struct{}{},
+	}
+	for _, keyword := range strings.Fields(keywords) {
+		l.keywords[keyword] = struct{}{}
+	}
+	return l
+}
+
+var (
+	cStyleBlockComments = [][2]string{{"/*", "*/"}}
+
+	fileViewerLanguageC = newFileViewerLanguage("C/C++", []string{"//"}, cStyleBlockComments, `"'`, nil,
+		"alignas alignof auto bool break case catch char class const constexpr continue default delete do double else enum explicit extern false float for friend goto if inline int long namespace new noexcept nullptr operator private protected public register return short signed sizeof static static_assert struct switch template this throw true try typedef typename union unsigned using virtual void volatile while")
+	fileViewerLanguageGo = newFileViewerLanguage("Go", []string{"//"}, cStyleBlockComments, `"'`, []string{"`"},
+		"break case chan const continue default defer else fallthrough false for func go goto if import interface iota map nil package range return select struct switch true type var")
+	fileViewerLanguageJava = newFileViewerLanguage("Java", []string{"//"}, cStyleBlockComments, `"'`, []string{`"""`},
+		"abstract boolean break byte case catch char class continue default do double else enum extends false final finally float for if implements import instanceof int interface long native new null package private protected public return short static super switch synchronized this throw throws true try void volatile while")
+	fileViewerLanguageJavaScript = newFileViewerLanguage("JavaScript", []string{"//"}, cStyleBlockComments, `"'`, []string{"`"},
+		"async await break case catch class const continue default delete do else export extends false finally for function if import in instanceof interface let new null return switch this throw true try type typeof undefined var void while yield")
+	fileViewerLanguageJsonnet = newFileViewerLanguage("Jsonnet", []string{"//", "#"}, cStyleBlockComments, `"'`, []string{"|||"},
+		"assert else error false for function if import importstr in local null self super tailstrict then true")
+	fileViewerLanguagePython = newFileViewerLanguage("Python", []string{"#"}, nil, `"'`, []string{`"""`, "'''"},
+		"and as assert async await break class continue def del elif else except False finally for from global if import in is lambda None nonlocal not or pass raise return True try while with yield")
+	fileViewerLanguageProtobuf = newFileViewerLanguage("Protocol Buffers", []string{"//"}, cStyleBlockComments, `"'`, nil,
+		"bool bytes double enum extend false fixed32 fixed64 float import int32 int64 map message oneof option optional package repeated reserved returns rpc service sfixed32 sfixed64 sint32 sint64 string syntax to true uint32 uint64")
+	fileViewerLanguageRust = newFileViewerLanguage("Rust", []string{"//"}, cStyleBlockComments, `"`, nil,
+		"as async await break const continue crate dyn else enum extern false fn for if impl in let loop match mod move mut pub ref return self Self static struct super trait true type unsafe use where while")
+	fileViewerLanguageShell = newFileViewerLanguage("Shell", []string{"#"}, nil, `"'`, nil,
+		"case do done elif else esac exit export fi for function if in local readonly return set shift then unset until while")
+	fileViewerLanguageStarlark = newFileViewerLanguage("Starlark", []string{"#"}, nil, `"'`, []string{`"""`, "'''"},
+		"and break continue def elif else False for if in lambda load None not or pass return True")
+
+	// fileViewerLanguagesByName contains languages of files that are
+	// identified by their full name.
+	fileViewerLanguagesByName = map[string]*fileViewerLanguage{
+		"BUILD":        fileViewerLanguageStarlark,
+		"BUILD.bazel":  fileViewerLanguageStarlark,
+		"MODULE.bazel": fileViewerLanguageStarlark,
+		"WORKSPACE":    fileViewerLanguageStarlark,
+	}
+	// fileViewerLanguagesByExtension contains languages of files that
+	// are identified by their extension.
+	fileViewerLanguagesByExtension = map[string]*fileViewerLanguage{
+		".bash":      fileViewerLanguageShell,
+		".bzl":       fileViewerLanguageStarlark,
+		".c":         fileViewerLanguageC,
+		".cc":        fileViewerLanguageC,
+		".cpp":       fileViewerLanguageC,
+		".cxx":       fileViewerLanguageC,
+		".go":        fileViewerLanguageGo,
+		".h":         fileViewerLanguageC,
+		".hh":        fileViewerLanguageC,
+		".hpp":       fileViewerLanguageC,
+		".java":      fileViewerLanguageJava,
+		".js":        fileViewerLanguageJavaScript,
+		".jsonnet":   fileViewerLanguageJsonnet,
+		".libsonnet": fileViewerLanguageJsonnet,
+		".m":         fileViewerLanguageC,
+		".mm":        fileViewerLanguageC,
+		".proto":     fileViewerLanguageProtobuf,
+		".py":        fileViewerLanguagePython,
+		".rs":        fileViewerLanguageRust,
+		".sh":        fileViewerLanguageShell,
+		".star":      fileViewerLanguageStarlark,
+		".ts":        fileViewerLanguageJavaScript,
+	}
+)
+
+// getFileViewerLanguage returns the language of a file based on its
+// name. Nil is returned if the language is not known, in which case
+// the file is displayed without highlighting.
+func getFileViewerLanguage(name string) *fileViewerLanguage {
+	if language, ok := fileViewerLanguagesByName[name]; ok {
+		return language
+	}
+	return fileViewerLanguagesByExtension[strings.ToLower(path.Ext(name))]
+}
+
+// fileViewerLine is a single line of a file displayed by the file
+// viewer.
+type fileViewerLine struct {
+	Number int
+	HTML   template.HTML
+}
+
+// fileViewerInfo contains the information that we display on pages of
+// the file viewer, which displays text files with line numbers.
+type fileViewerInfo struct {
+	Digest   digest.Digest
+	Name     string
+	RawURL   string
+	Language string
+	Lines    []fileViewerLine
+}
+
+// fileViewerHighlighter converts the contents of a file to HTML, one
+// line at a time. Tokens that span multiple lines, such as block
+// comments, are split up, so that every line is well formed.
+type fileViewerHighlighter struct {
+	lines       []fileViewerLine
+	currentLine strings.Builder
+}
+
+func (h *fileViewerHighlighter) appendToken(class, text string) {
+	for {
+		end := strings.IndexByte(text, '\n')
+		part := text
+		if end >= 0 {
+			part = text[:end]
+		}
+		if part != "" {
+			if class != "" {
+				h.currentLine.WriteString(`<span class="file-viewer-`)
+				h.currentLine.WriteString(class)
+				h.currentLine.WriteString(`">`)
+				h.currentLine.WriteString(template.HTMLEscapeString(part))
+				h.currentLine.WriteString("</span>")
+			} else {
+				h.currentLine.WriteString(template.HTMLEscapeString(part))
+			}
+		}
+		if end < 0 {
+			return
+		}
+		h.lines = append(h.lines, fileViewerLine{
+			Number: len(h.lines) + 1,
+			HTML:   template.HTML(h.currentLine.String()),
+		})
+		h.currentLine.Reset()
+		text = text[end+1:]
+	}
+}
+
+func isIdentifierByte(c byte, first bool) bool {
+	return c == '_' || (c >= 'a' && c <= 'z') || (c >= 'A' && c <= 'Z') || (!first && c >= '0' && c <= '9')
+}
+
+// getFileViewerTokenLength returns the length and class of the token at
+// the start of some text. Only comments, string literals, numbers and
+// keywords have a class.
+func getFileViewerTokenLength(language *fileViewerLanguage, text string, atWordBoundary bool) (int, string) {
+	for _, prefix := range language.lineComments {
+		if strings.HasPrefix(text, prefix) {
+			if end := strings.IndexByte(text, '\n'); end >= 0 {
+				return end, "comment"
+			}
+			return len(text), "comment"
+		}
+	}
+	for _, delimiters := range language.blockComments {
+		if strings.HasPrefix(text, delimiters[0]) {
+			if end := strings.Index(text[len(delimiters[0]):], delimiters[1]); end >= 0 {
+				return len(delimiters[0]) + end + len(delimiters[1]), "comment"
+			}
+			return len(text), "comment"
+		}
+	}
+	for _, delimiter := range language.multiLineQuotes {
+		if strings.HasPrefix(text, delimiter) {
+			if end := strings.Index(text[len(delimiter):], delimiter); end >= 0 {
+				return len(delimiter) + end + len(delimiter), "string"
+			}
+			return len(text), "string"
+		}
+	}
+	if strings.IndexByte(language.quotes, text[0]) >= 0 {
+		for i := 1; i < len(text); i++ {
+			switch text[i] {
+			case '\\':
+				i++
+			case '\n':
+				return i, "string"
+			case text[0]:
+				return i + 1, "string"
+			}
+		}
+		return len(text), "string"
+	}
+	if atWordBoundary && text[0] >= '0' && text[0] <= '9' {
+		i := 1
+		for i < len(text) && (isIdentifierByte(text[i], false) || text[i] == '.') {
+			i++
+		}
+		return i, "number"
+	}
+	if isIdentifierByte(text[0], true) {
+		i := 1
+		for i < len(text) && isIdentifierByte(text[i], false) {
+			i++
+		}
+		if _, ok := language.keywords[text[:i]]; ok && atWordBoundary {
+			return i, "keyword"
+		}
+		return i, ""
+	}
+	return 1, ""
+}
+
+// highlightFile converts the contents of a file to HTML, one line at a
+// time, highlighting the syntax of the language of the file if known.
+func highlightFile(contents string, language *fileViewerLanguage) []fileViewerLine {
+	contents = strings.TrimSuffix(contents, "\n")
+	var h fileViewerHighlighter
+	if language == nil {
+		h.appendToken("", contents)
+	} else {
+		atWordBoundary := true
+		for len(contents) > 0 {
+			length, class := getFileViewerTokenLength(language, contents, atWordBoundary)
+			h.appendToken(class, contents[:length])
+			atWordBoundary = !isIdentifierByte(contents[length-1], false)
+			contents = contents[length:]
+		}
+	}
+	h.appendToken("", "\n")
+	return h.lines
+}
+
+// generateFileViewer writes a page displaying the contents of a text
+// file as the HTTP response. Every line is prefixed with its line
+// number, which can be used to link to the line ("#L123") or a range
+// of lines ("#L123-L130"). Files that don't contain text are served as
+// is.
+func (s *BrowserService) generateFileViewer(w http.ResponseWriter, req *http.Request, fileDigest digest.Digest) {
+	ctx := extractContextFromRequest(req)
+	contents, err := s.contentAddressableStorage.Get(ctx, fileDigest).ToByteSlice(maximumFileViewerSizeBytes)
+	if err != nil {
+		s.renderError(w, req, err)
+		return
+	}
+	name := mux.Vars(req)["name"]
+	if !utf8.Valid(contents) {
+		// Files that don't contain text are displayed as a hex
+		// dump instead.
+		s.generateHexDump(w, req, fileDigest, name)
+		return
+	}
+
+	language := getFileViewerLanguage(name)
+	info := fileViewerInfo{
+		Digest:   fileDigest,
+		Name:     name,
+		RawURL:   "./" + url.PathEscape(name),
+		Language: "Plain text",
+		Lines:    highlightFile(string(contents), language),
+	}
+	if language != nil {
+		info.Language = language.name
+	}
+	if err := s.templates.ExecuteTemplate(w, "page_file.html", &info); err != nil {
+		log.Print(err)
+	}
+}
@@ -0,0 +1,399 @@
+package main
+
+import (
+	"bufio"
+	"bytes"
+	"context"
+	"fmt"
+	"io"
+	"log"
+	"net/http"
+	"net/url"
+	"path"
+	"regexp"
+	"strconv"
+	"strings"
+
+	remoteexecution "github.com/bazelbuild/remote-apis/build/bazel/remote/execution/v2"
+	"github.com/buildbarn/bb-browser/pkg/proto/query"
+	"github.com/buildbarn/bb-storage/pkg/digest"
+	"github.com/buildbarn/bb-storage/pkg/util"
+	"golang.org/x/sync/errgroup"
+	"golang.org/x/sync/semaphore"
+
+	"google.golang.org/grpc/codes"
+	"google.golang.org/grpc/status"
+)
+
+const (
+	// defaultGrepContextLines is the number of lines of context that
+	// are displayed before and after every match, if not specified
+	// through the "context" query parameter.
+	defaultGrepContextLines = 2
+	// maximumGrepContextLines is the maximum number of lines of
+	// context that may be requested.
+	maximumGrepContextLines = 10
+	// maximumGrepLineLengthBytes is the maximum length of lines that
+	// are displayed. Longer lines are truncated.
+	maximumGrepLineLengthBytes = 1000
+	// grepBinaryDetectionSizeBytes is the size of the prefix of a
+	// file that is inspected to determine whether it contains text.
+	// Like Git, files containing a null byte within this prefix are
+	// considered to be binary.
+	grepBinaryDetectionSizeBytes = 8000
+)
+
+// isGrepFormat returns whether the format requested through the
+// "format" query parameter causes the contents of the files in a
+// directory hierarchy to be searched. Results can be returned as an
+// HTML page ("grep") or in machine readable form ("grep_json").
+func isGrepFormat(format string) bool {
+	return format == "grep" || format == "grep_json"
+}
+
+// grepLine is a single line of a file that is displayed as part of
+// the results of a content search.
+type grepLine struct {
+	Number  int64
+	Text    string
+	IsMatch bool
+}
+
+// grepHunk is a range of consecutive lines of a file, containing one or
+// more matches and the lines surrounding them.
+type grepHunk struct {
+	Lines []grepLine
+}
+
+func (h *grepHunk) toProto() *query.GrepInfo_Hunk {
+	lines := make([]*query.GrepInfo_Line, 0, len(h.Lines))
+	for _, line := range h.Lines {
+		lines = append(lines, &query.GrepInfo_Line{
+			Number:  line.Number,
+			Text:    line.Text,
+			IsMatch: line.IsMatch,
+		})
+	}
+	return &query.GrepInfo_Hunk{Lines: lines}
+}
+
+// grepFileResult contains the results of searching the contents of a
+// single file. As files with identical contents are only searched once,
+// results may be shared by multiple grepFiles.
+type grepFileResult struct {
+	hunks             []grepHunk
+	matchCount        int64
+	matchLimitReached bool
+	isBinary          bool
+	err               error
+}
+
+// grepFile is a file containing one or more matches, or that could not
+// be searched.
+type grepFile struct {
+	Path       string
+	Digest     digest.Digest
+	URL        string
+	Hunks      []grepHunk
+	MatchCount int64
+	Error      *status.Status
+}
+
+func (f *grepFile) toProto() *query.GrepInfo_File {
+	hunks := make([]*query.GrepInfo_Hunk, 0, len(f.Hunks))
+	for i := range f.Hunks {
+		hunks = append(hunks, f.Hunks[i].toProto())
+	}
+	return &query.GrepInfo_File{
+		Path:       f.Path,
+		Digest:     getDigestProto(f.Digest),
+		Hunks:      hunks,
+		MatchCount: f.MatchCount,
+		Error:      f.Error.Proto(),
+	}
+}
+
+// grepInfo contains the information that we display on pages containing
+// the results of searching the contents of the files in a directory
+// hierarchy.
+type grepInfo struct {
+	Digest       digest.Digest
+	Pattern      string
+	ContextLines int
+	Files        []grepFile
+
+	MatchCount            int64
+	FilesScanned          int64
+	BytesScanned          int64
+	BinaryFilesSkipped    int64
+	DirectoryLimitReached bool
+	SizeLimitReached      bool
+	MatchLimitReached     bool
+}
+
+// GetJSONURL returns a link to the results of the search in machine
+// readable form.
+func (gi *grepInfo) GetJSONURL() string {
+	jsonQuery := url.Values{}
+	jsonQuery.Set("format", "grep_json")
+	jsonQuery.Set("q", gi.Pattern)
+	jsonQuery.Set("context", strconv.FormatInt(int64(gi.ContextLines), 10))
+	return "?" + jsonQuery.Encode()
+}
+
+func (gi *grepInfo) toProto() *query.GrepInfo {
+	files := make([]*query.GrepInfo_File, 0, len(gi.Files))
+	for i := range gi.Files {
+		files = append(files, gi.Files[i].toProto())
+	}
+	return &query.GrepInfo{
+		Digest:                getDigestProto(gi.Digest),
+		Pattern:               gi.Pattern,
+		ContextLines:          int32(gi.ContextLines),
+		Files:                 files,
+		FilesScanned:          gi.FilesScanned,
+		BytesScanned:          gi.BytesScanned,
+		BinaryFilesSkipped:    gi.BinaryFilesSkipped,
+		SizeLimitReached:      gi.SizeLimitReached,
+		MatchLimitReached:     gi.MatchLimitReached,
+		DirectoryLimitReached: gi.DirectoryLimitReached,
+	}
+}
+
+// grepReader searches a stream of text for matching lines, and groups
+// matches and the lines surrounding them into hunks.
+func grepReader(r io.Reader, match func(line []byte) bool, contextLines int, maximumMatches int64) (*grepFileResult, error) {
+	br := bufio.NewReaderSize(r, 64*1024)
+	if prefix, err := br.Peek(grepBinaryDetectionSizeBytes); err != nil && err != io.EOF && err != bufio.ErrBufferFull {
+		return nil, err
+	} else if bytes.IndexByte(prefix, 0) >= 0 {
+		return &grepFileResult{isBinary: true}, nil
+	}
+
+	result := &grepFileResult{}
+	var before []grepLine
+	afterRemaining := 0
+	for lineNumber := int64(1); ; lineNumber++ {
+		// Read the next line. Lines that don't fit in the buffer
+		// are only matched partially.
+		line, err := br.ReadSlice('\n')
+		atEOF := err == io.EOF
+		if err == bufio.ErrBufferFull {
+			line = bytes.Clone(line)
+			for err == bufio.ErrBufferFull {
+				_, err = br.ReadSlice('\n')
+			}
+			atEOF = err == io.EOF
+		}
+		if err != nil && err != io.EOF {
+			return nil, err
+		}
+		if atEOF && len(line) == 0 {
+			return result, nil
+		}
+		line = bytes.TrimSuffix(line, []byte{'\n'})
+
+		isMatch := match(line)
+		if isMatch {
+			if result.matchCount >= maximumMatches {
+				result.matchLimitReached = true
+				return result, nil
+			}
+			result.matchCount++
+		}
+		if isMatch || afterRemaining > 0 {
+			if len(line) > maximumGrepLineLengthBytes {
+				line = line[:maximumGrepLineLengthBytes]
+			}
+			current := grepLine{
+				Number:  lineNumber,
+				Text:    strings.ToValidUTF8(string(line), "�"),
+				IsMatch: isMatch,
+			}
+			if isMatch {
+				// Start a new hunk, unless the lines
+				// preceding the match are adjacent to
+				// the previous hunk.
+				lines := append(before, current)
+				before = nil
+				if n := len(result.hunks); n > 0 && result.hunks[n-1].Lines[len(result.hunks[n-1].Lines)-1].Number+1 == lines[0].Number {
+					result.hunks[n-1].Lines = append(result.hunks[n-1].Lines, lines...)
+				} else {
+					result.hunks = append(result.hunks, grepHunk{Lines: lines})
+				}
+				afterRemaining = contextLines
+			} else {
+				hunk := &result.hunks[len(result.hunks)-1]
+				hunk.Lines = append(hunk.Lines, current)
+				afterRemaining--
+			}
+		} else if contextLines > 0 {
+			if len(line) > maximumGrepLineLengthBytes {
+				line = line[:maximumGrepLineLengthBytes]
+			}
+			if len(before) == contextLines {
+				before = before[1:]
+			}
+			before = append(before, grepLine{
+				Number: lineNumber,
+				Text:   strings.ToValidUTF8(string(line), "�"),
+			})
+		}
+		if atEOF {
+			return result, nil
+		}
+	}
+}
+
+// getGrepContextLines returns the number of lines of context to
+// display before and after every match, as provided through the
+// "context" query parameter.
+func getGrepContextLines(parameters url.Values) (int, error) {
+	contextLines := parameters.Get("context")
+	if contextLines == "" {
+		return defaultGrepContextLines, nil
+	}
+	n, err := strconv.ParseInt(contextLines, 10, 0)
+	if err != nil || n < 0 || n > maximumGrepContextLines {
+		return 0, status.Errorf(codes.InvalidArgument, "Number of context lines must be between 0 and %d", maximumGrepContextLines)
+	}
+	return int(n), nil
+}
+
+// errGrepSizeLimitReached is used to stop traversing a directory
+// hierarchy once the total size of the files selected for searching
+// reaches the configured limit.
+var errGrepSizeLimitReached = status.Error(codes.ResourceExhausted, "Maximum total size of files to search reached")
+
+// grepDirectory searches the contents of all files contained in a
+// directory hierarchy for lines matching a regular expression. Files
+// are read from the Content Addressable Storage concurrently. Files
+// are selected for searching in the order in which they are written
+// into archives, until the total size of the selected files exceeds
+// the configured limit. As the directory hierarchy may be arbitrarily
+// large, the number of directories that are loaded is limited as well.
+func (s *BrowserService) grepDirectory(ctx context.Context, info *grepInfo, directoryDigest digest.Digest, directory *remoteexecution.Directory, getDirectory directoryGetter, re *regexp.Regexp, rootDirectory string) error {
+	// Select the files to search. Files with identical contents are
+	// only searched once.
+	results := map[digest.Digest]*grepFileResult{}
+	var filesToScan []digest.Digest
+	var entries []*recursiveListingEntry
+	memory := semaphore.NewWeighted(s.archivePrefetchMemoryBudgetBytes)
+	directoryLimitReached, err := s.walkArchiveEntries(ctx, directoryDigest.GetDigestFunction(), directory, getDirectory, memory, s.maximumSearchDirectories, func(entry *archiveEntry) error {
+		if entry.entryType != archiveEntryTypeFile && entry.entryType != archiveEntryTypeDuplicateFile {
+			return nil
+		}
+		if _, ok := results[entry.digest]; !ok {
+			sizeBytes := entry.digest.GetSizeBytes()
+			if info.BytesScanned+sizeBytes > s.maximumGrepSizeBytes {
+				info.SizeLimitReached = true
+				return errGrepSizeLimitReached
+			}
+			info.BytesScanned += sizeBytes
+			results[entry.digest] = &grepFileResult{}
+			filesToScan = append(filesToScan, entry.digest)
+		}
+		entries = append(entries, &recursiveListingEntry{
+			Path:         entry.path,
+			Digest:       entry.digest,
+			IsExecutable: entry.isExecutable,
+		})
+		return nil
+	})
+	if err != nil && err != errGrepSizeLimitReached {
+		return err
+	}
+	info.DirectoryLimitReached = directoryLimitReached
+	info.FilesScanned = int64(len(filesToScan))
+
+	maximumMatches := int64(s.maximumSearchResults)
+	group, groupCtx := errgroup.WithContext(ctx)
+	group.SetLimit(s.grepParallelism)
+	for _, fileDigest := range filesToScan {
+		group.Go(func() error {
+			r := s.contentAddressableStorage.Get(groupCtx, fileDigest).ToReader()
+			defer r.Close()
+			result, err := grepReader(r, re.Match, info.ContextLines, maximumMatches)
+			if err != nil {
+				result = &grepFileResult{err: err}
+			}
+			// Each goroutine writes to a distinct result, so
+			// no locking is needed.
+			*results[fileDigest] = *result
+			return nil
+		})
+	}
+	group.Wait()
+	if ctx.Err() != nil {
+		return util.StatusFromContext(ctx)
+	}
+
+	for _, entry := range entries {
+		result := results[entry.Digest]
+		if result.isBinary {
+			info.BinaryFilesSkipped++
+			continue
+		}
+		if result.matchLimitReached {
+			info.MatchLimitReached = true
+		}
+		if result.err == nil && result.matchCount == 0 {
+			continue
+		}
+		if info.MatchCount >= maximumMatches {
+			info.MatchLimitReached = true
+			break
+		}
+		file := grepFile{
+			Path:       entry.Path,
+			Digest:     entry.Digest,
+			URL:        fmt.Sprintf("%sfile/%s-%d/%s", rootDirectory, entry.Digest.GetHashString(), entry.Digest.GetSizeBytes(), path.Base(entry.Path)),
+			Hunks:      result.hunks,
+			MatchCount: result.matchCount,
+		}
+		if result.err != nil {
+			file.Error = status.Convert(util.StatusWrapf(result.err, "Failed to search file %#v", entry.Path))
+		}
+		info.MatchCount += result.matchCount
+		info.Files = append(info.Files, file)
+	}
+	return nil
+}
+
+// generateGrep searches the contents of the files in a directory
+// hierarchy for lines matching the regular expression provided through
+// the "q" query parameter, and writes the results as the HTTP response.
+// When no regular expression is provided, only a search form is
+// displayed.
+func (s *BrowserService) generateGrep(ctx context.Context, w http.ResponseWriter, req *http.Request, directoryDigest digest.Digest, directory *remoteexecution.Directory, getDirectory directoryGetter, rootDirectory string) {
+	parameters := req.URL.Query()
+	info := grepInfo{
+		Digest:  directoryDigest,
+		Pattern: parameters.Get("q"),
+	}
+	contextLines, err := getGrepContextLines(parameters)
+	if err != nil {
+		s.renderError(w, req, err)
+		return
+	}
+	info.ContextLines = contextLines
+	if info.Pattern != "" {
+		re, err := regexp.Compile(info.Pattern)
+		if err != nil {
+			s.renderError(w, req, util.StatusWrapfWithCode(err, codes.InvalidArgument, "Invalid regular expression %#v", info.Pattern))
+			return
+		}
+		if err := s.grepDirectory(ctx, &info, directoryDigest, directory, getDirectory, re, rootDirectory); err != nil {
+			s.renderError(w, req, err)
+			return
+		}
+	}
+
+	if parameters.Get("format") == "grep_json" {
+		s.renderJSON(w, req, &query.Response{
+			Page: &query.Response_Grep{Grep: info.toProto()},
+		})
+	} else if err := s.templates.ExecuteTemplate(w, "page_grep.html", &info); err != nil {
+		log.Print(err)
+	}
+}
@@ -0,0 +1,284 @@
+package main
+
+import (
+	"bytes"
+	"encoding/binary"
+	"fmt"
+	"io"
+	"log"
+	"net/http"
+	"net/url"
+	"strconv"
+	"strings"
+	"unicode/utf8"
+
+	"github.com/buildbarn/bb-storage/pkg/digest"
+	"github.com/buildbarn/bb-storage/pkg/util"
+
+	"google.golang.org/grpc/codes"
+	"google.golang.org/grpc/status"
+	"google.golang.org/protobuf/encoding/protowire"
+)
+
+const (
+	// hexDumpBytesPerRow is the number of bytes displayed on every
+	// row of a hex dump.
+	hexDumpBytesPerRow = 16
+	// hexDumpPageSizeBytes is the number of bytes displayed on a
+	// single page of a hex dump. It is also the number of bytes at
+	// the start of a file that are inspected to detect its type.
+	hexDumpPageSizeBytes = 4096
+)
+
+// fileMagic describes the type of a file, as detected by inspecting the
+// first bytes of the file.
+type fileMagic struct {
+	Name string
+	// Ranges of bytes of the file that identify its type, which are
+	// highlighted in hex dumps.
+	ranges [][2]int64
+}
+
+func (fm *fileMagic) contains(offset int64) bool {
+	for _, r := range fm.ranges {
+		if offset >= r[0] && offset < r[1] {
+			return true
+		}
+	}
+	return false
+}
+
+// elfClasses and elfDataEncodings contain descriptions of the values of
+// the EI_CLASS and EI_DATA fields of the identification of ELF files.
+var (
+	elfClasses       = map[byte]string{1: "32-bit", 2: "64-bit"}
+	elfDataEncodings = map[byte]string{1: "little endian", 2: "big endian"}
+)
+
+// isProtobufMessage returns whether data contains a sequence of fields
+// of a Protocol Buffers message. Messages don't have a magic number,
+// meaning this is only a heuristic. If the data is a prefix of a larger
+// file, the final field is permitted to be truncated.
+func isProtobufMessage(data []byte, isPrefix bool) bool {
+	if len(data) == 0 {
+		return false
+	}
+	for len(data) > 0 {
+		number, wireType, n := protowire.ConsumeTag(data)
+		if n < 0 {
+			return isPrefix && protowire.ParseError(n) == io.ErrUnexpectedEOF
+		}
+		if number > protowire.MaxValidNumber || (wireType == protowire.StartGroupType || wireType == protowire.EndGroupType) {
+			return false
+		}
+		m := protowire.ConsumeFieldValue(number, wireType, data[n:])
+		if m < 0 {
+			return isPrefix && protowire.ParseError(m) == io.ErrUnexpectedEOF
+		}
+		data = data[n+m:]
+	}
+	return true
+}
+
+// detectFileMagic determines the type of a file by inspecting the first
+// bytes of the file. Nil is returned if the type of the file is not
+// recognized.
+func detectFileMagic(header []byte, sizeBytes int64) *fileMagic {
+	switch {
+	case bytes.HasPrefix(header, []byte("\x7fELF")):
+		name := "ELF"
+		if len(header) >= 6 {
+			if class, ok := elfClasses[header[4]]; ok {
+				if dataEncoding, ok := elfDataEncodings[header[5]]; ok {
+					name = fmt.Sprintf("ELF (%s, %s)", class, dataEncoding)
+				}
+			}
+		}
+		return &fileMagic{Name: name, ranges: [][2]int64{{0, 4}}}
+	case bytes.HasPrefix(header, []byte{0xfe, 0xed, 0xfa, 0xce}), bytes.HasPrefix(header, []byte{0xce, 0xfa, 0xed, 0xfe}):
+		return &fileMagic{Name: "Mach-O (32-bit)", ranges: [][2]int64{{0, 4}}}
+	case bytes.HasPrefix(header, []byte{0xfe, 0xed, 0xfa, 0xcf}), bytes.HasPrefix(header, []byte{0xcf, 0xfa, 0xed, 0xfe}):
+		return &fileMagic{Name: "Mach-O (64-bit)", ranges: [][2]int64{{0, 4}}}
+	case bytes.HasPrefix(header, []byte{0xca, 0xfe, 0xba, 0xbe}) && len(header) >= 8 && binary.BigEndian.Uint32(header[4:]) < 64:
+		// Java class files use the same magic number. These can
+		// be distinguished by the number of architectures that
+		// follow, which Java class files use to store their
+		// version number.
+		return &fileMagic{Name: "Mach-O (universal)", ranges: [][2]int64{{0, 4}}}
+	case bytes.HasPrefix(header, []byte("MZ")):
+		// PE files start with an MS-DOS stub, containing the
+		// offset of the PE signature at offset 0x3c.
+		if len(header) >= 0x40 {
+			if peOffset := int64(binary.LittleEndian.Uint32(header[0x3c:])); peOffset+4 <= int64(len(header)) && bytes.Equal(header[peOffset:peOffset+4], []byte("PE\x00\x00")) {
+				return &fileMagic{Name: "PE", ranges: [][2]int64{{0, 2}, {peOffset, peOffset + 4}}}
+			}
+		}
+		return &fileMagic{Name: "MS-DOS executable", ranges: [][2]int64{{0, 2}}}
+	case bytes.HasPrefix(header, []byte{0x1f, 0x8b}):
+		return &fileMagic{Name: "gzip", ranges: [][2]int64{{0, 2}}}
+	case bytes.HasPrefix(header, []byte("PK\x03\x04")), bytes.HasPrefix(header, []byte("PK\x05\x06")):
+		return &fileMagic{Name: "ZIP", ranges: [][2]int64{{0, 4}}}
+	case bytes.HasPrefix(header, []byte{0x28, 0xb5, 0x2f, 0xfd}):
+		return &fileMagic{Name: "Zstandard", ranges: [][2]int64{{0, 4}}}
+	case !utf8.Valid(header) && isProtobufMessage(header, int64(len(header)) < sizeBytes):
+		// Text may coincidentally also be a valid Protocol
+		// Buffers message, so only consider binary data.
+		_, _, n := protowire.ConsumeTag(header)
+		return &fileMagic{Name: "Protocol Buffers message (probably)", ranges: [][2]int64{{0, int64(n)}}}
+	}
+	return nil
+}
+
+// hexDumpCell is a single byte displayed in a hex dump.
+type hexDumpCell struct {
+	Hex       string
+	Character string
+	IsMagic   bool
+}
+
+// hexDumpRow is a single row of a hex dump.
+type hexDumpRow struct {
+	Offset int64
+	Cells  []hexDumpCell
+	// Whitespace that aligns the characters of the final row of a
+	// file, if the row is not complete.
+	Padding string
+}
+
+// hexDumpInfo contains the information that we display on pages
+// containing a hex dump of a file, which displays the contents of a
+// file one page at a time.
+type hexDumpInfo struct {
+	Digest    digest.Digest
+	Name      string
+	Offset    int64
+	EndOffset int64
+	Magic     *fileMagic
+	Rows      []hexDumpRow
+}
+
+func (hi *hexDumpInfo) getURL(offset int64) string {
+	pageQuery := url.Values{}
+	pageQuery.Set("format", "hex")
+	pageQuery.Set("offset", strconv.FormatInt(offset, 10))
+	return "?" + pageQuery.Encode()
+}
+
+// GetFirstURL returns a link to the first page of the hex dump.
+func (hi *hexDumpInfo) GetFirstURL() string {
+	return hi.getURL(0)
+}
+
+// GetLastURL returns a link to the last page of the hex dump.
+func (hi *hexDumpInfo) GetLastURL() string {
+	return hi.getURL(max(hi.Digest.GetSizeBytes()-1, 0) / hexDumpPageSizeBytes * hexDumpPageSizeBytes)
+}
+
+// GetPreviousURL returns a link to the page preceding the current one.
+func (hi *hexDumpInfo) GetPreviousURL() string {
+	return hi.getURL(max(hi.Offset-hexDumpPageSizeBytes, 0))
+}
+
+// GetNextURL returns a link to the page following the current one.
+func (hi *hexDumpInfo) GetNextURL() string {
+	return hi.getURL(hi.EndOffset)
+}
+
+// HasPrevious returns whether the current page is preceded by other
+// pages.
+func (hi *hexDumpInfo) HasPrevious() bool {
+	return hi.Offset > 0
+}
+
+// HasNext returns whether the current page is followed by other pages.
+func (hi *hexDumpInfo) HasNext() bool {
+	return hi.EndOffset < hi.Digest.GetSizeBytes()
+}
+
+// GetRawURL returns a link to the contents of the file.
+func (hi *hexDumpInfo) GetRawURL() string {
+	return "./" + url.PathEscape(hi.Name)
+}
+
+// generateHexDump writes a page containing a hex dump of a part of a
+// file as the HTTP response. The page starts at the offset provided
+// through the "offset" query parameter, which may be written in
+// decimal or hexadecimal notation, and is rounded down to the start of
+// a row.
+func (s *BrowserService) generateHexDump(w http.ResponseWriter, req *http.Request, fileDigest digest.Digest, name string) {
+	sizeBytes := fileDigest.GetSizeBytes()
+	offset := int64(0)
+	if offsetStr := req.URL.Query().Get("offset"); offsetStr != "" {
+		var err error
+		offset, err = strconv.ParseInt(offsetStr, 0, 64)
+		if err != nil {
+			s.renderError(w, req, util.StatusWrapfWithCode(err, codes.InvalidArgument, "Invalid offset %#v", offsetStr))
+			return
+		}
+		if offset < 0 || offset > sizeBytes {
+			s.renderError(w, req, status.Errorf(codes.InvalidArgument, "Offset %d is outside the file, which is %d bytes in size", offset, sizeBytes))
+			return
+		}
+	}
+	offset -= offset % hexDumpBytesPerRow
+
+	// Read the start of the file to detect its type, followed by the
+	// page to display. Buffers may only be consumed once, so every
+	// range is read from a buffer of its own.
+	ctx := extractContextFromRequest(req)
+	readRange := func(start int64) ([]byte, error) {
+		data := make([]byte, min(hexDumpPageSizeBytes, sizeBytes-start))
+		if len(data) == 0 {
+			return data, nil
+		}
+		n, err := s.contentAddressableStorage.Get(ctx, fileDigest).ReadAt(data, start)
+		if err != nil && err != io.EOF {
+			return nil, util.StatusWrap(err, "Failed to read file")
+		}
+		return data[:n], nil
+	}
+	header, err := readRange(0)
+	if err != nil {
+		s.renderError(w, req, err)
+		return
+	}
+	data := header
+	if offset > 0 {
+		if data, err = readRange(offset); err != nil {
+			s.renderError(w, req, err)
+			return
+		}
+	}
+
+	info := hexDumpInfo{
+		Digest:    fileDigest,
+		Name:      name,
+		Offset:    offset,
+		EndOffset: offset + int64(len(data)),
+		Magic:     detectFileMagic(header, sizeBytes),
+	}
+	for rowStart := 0; rowStart < len(data); rowStart += hexDumpBytesPerRow {
+		row := hexDumpRow{Offset: offset + int64(rowStart)}
+		for i, b := range data[rowStart:min(rowStart+hexDumpBytesPerRow, len(data))] {
+			cell := hexDumpCell{
+				Hex:       fmt.Sprintf("%02x", b),
+				Character: ".",
+				IsMagic:   info.Magic != nil && info.Magic.contains(row.Offset+int64(i)),
+			}
+			if b >= 0x20 && b < 0x7f {
+				cell.Character = string(rune(b))
+			}
+			row.Cells = append(row.Cells, cell)
+		}
+		if missing := hexDumpBytesPerRow - len(row.Cells); missing > 0 {
+			row.Padding = strings.Repeat("   ", missing)
+			if missing >= hexDumpBytesPerRow/2 {
+				row.Padding += " "
+			}
+		}
+		info.Rows = append(info.Rows, row)
+	}
+	if err := s.templates.ExecuteTemplate(w, "page_hex_dump.html", &info); err != nil {
+		log.Print(err)
+	}
+}
@@ -0,0 +1,250 @@
+package main
+
+import (
+	"bytes"
+	"fmt"
+	"html/template"
+	"io"
+	"net/http"
+	"net/url"
+	"regexp"
+	"strings"
+
+	remoteexecution "github.com/bazelbuild/remote-apis/build/bazel/remote/execution/v2"
+	"github.com/buildbarn/bb-browser/pkg/proto/query"
+	"github.com/buildbarn/bb-storage/pkg/digest"
+	"github.com/buildbarn/bb-storage/pkg/util"
+	"github.com/buildkite/terminal-to-html"
+	"github.com/gorilla/mux"
+
+	"google.golang.org/grpc/codes"
+	"google.golang.org/grpc/status"
+)
+
+// ansiEscapeSequence matches the escape sequences that terminals use
+// to set colors and move the cursor. Lines of logs are matched against
+// regular expressions with these sequences removed, so that searching
+// for "error:" also finds errors that are printed in color.
+var ansiEscapeSequence = regexp.MustCompile(`\x1b(?:\[[0-?]*[ -/]*[@-~]|\][^\x07\x1b]*(?:\x07|\x1b\\)|[@-Z\\-_])`)
+
+const (
+	// logSearchMatchStart and logSearchMatchEnd are inserted into
+	// lines of logs around matches, prior to converting them to
+	// HTML. They are characters from Unicode's private use area,
+	// which are not altered by terminal-to-html.
+	logSearchMatchStart = "\ue000"
+	logSearchMatchEnd   = "\ue001"
+)
+
+// stripANSIEscapeSequences removes all terminal escape sequences from
+// a line of a log. In addition to the resulting text, it returns the
+// offset in the original line of every byte of the text.
+func stripANSIEscapeSequences(line []byte) ([]byte, []int) {
+	text := make([]byte, 0, len(line))
+	offsets := make([]int, 0, len(line))
+	previousEnd := 0
+	appendRange := func(start, end int) {
+		text = append(text, line[start:end]...)
+		for i := start; i < end; i++ {
+			offsets = append(offsets, i)
+		}
+	}
+	for _, escapeSequence := range ansiEscapeSequence.FindAllIndex(line, -1) {
+		appendRange(previousEnd, escapeSequence[0])
+		previousEnd = escapeSequence[1]
+	}
+	appendRange(previousEnd, len(line))
+	return text, offsets
+}
+
+// highlightLogLine converts a line of a log containing terminal escape
+// sequences to HTML, wrapping all matches of a regular expression in
+// <mark> elements. As matches may overlap with the <span> elements
+// generated by terminal-to-html, <mark> elements are closed and
+// reopened around every tag, so that the resulting HTML remains well
+// formed.
+func highlightLogLine(line string, re *regexp.Regexp) template.HTML {
+	lineBytes := []byte(strings.NewReplacer(logSearchMatchStart, "", logSearchMatchEnd, "").Replace(line))
+	text, offsets := stripANSIEscapeSequences(lineBytes)
+	var marked []byte
+	previousEnd := 0
+	for _, match := range re.FindAllIndex(text, -1) {
+		if match[0] == match[1] {
+			continue
+		}
+		start, end := offsets[match[0]], offsets[match[1]-1]+1
+		marked = append(marked, lineBytes[previousEnd:start]...)
+		marked = append(marked, logSearchMatchStart...)
+		marked = append(marked, lineBytes[start:end]...)
+		marked = append(marked, logSearchMatchEnd...)
+		previousEnd = end
+	}
+	marked = append(marked, lineBytes[previousEnd:]...)
+
+	rendered := string(terminal.Render(marked))
+	var sb strings.Builder
+	inMatch := false
+	for len(rendered) > 0 {
+		if strings.HasPrefix(rendered, logSearchMatchStart) {
+			sb.WriteString("<mark>")
+			rendered = rendered[len(logSearchMatchStart):]
+			inMatch = true
+		} else if strings.HasPrefix(rendered, logSearchMatchEnd) {
+			sb.WriteString("</mark>")
+			rendered = rendered[len(logSearchMatchEnd):]
+			inMatch = false
+		} else if tagEnd := strings.IndexByte(rendered, '>'); inMatch && rendered[0] == '<' && tagEnd >= 0 {
+			sb.WriteString("</mark>")
+			sb.WriteString(rendered[:tagEnd+1])
+			sb.WriteString("<mark>")
+			rendered = rendered[tagEnd+1:]
+		} else {
+			sb.WriteByte(rendered[0])
+			rendered = rendered[1:]
+		}
+	}
+	if inMatch {
+		sb.WriteString("</mark>")
+	}
+	return template.HTML(sb.String())
+}
+
+// logSearchLine is a single line of a log that is displayed as part of
+// the results of a search.
+type logSearchLine struct {
+	grepLine
+
+	HTML template.HTML
+}
+
+// logSearchHunk is a range of consecutive lines of a log, containing
+// one or more matches and the lines surrounding them.
+type logSearchHunk struct {
+	Lines []logSearchLine
+}
+
+// logSearchInfo contains the results of searching a log for lines
+// matching a regular expression.
+type logSearchInfo struct {
+	Pattern           string
+	ContextLines      int
+	Hunks             []logSearchHunk
+	MatchCount        int64
+	MatchLimitReached bool
+	SizeLimitReached  bool
+	IsBinary          bool
+}
+
+func (si *logSearchInfo) getHunksProto() []*query.GrepInfo_Hunk {
+	hunks := make([]*query.GrepInfo_Hunk, 0, len(si.Hunks))
+	for _, hunk := range si.Hunks {
+		lines := make([]grepLine, 0, len(hunk.Lines))
+		for _, line := range hunk.Lines {
+			lines = append(lines, line.grepLine)
+		}
+		hunks = append(hunks, (&grepHunk{Lines: lines}).toProto())
+	}
+	return hunks
+}
+
+// searchLog searches a log for lines matching the regular expression
+// provided through the "q" query parameter. Logs are streamed, meaning
+// that they are not held in memory in their entirety. Only the first
+// part of logs that are larger than the limit that applies to searching
+// the contents of files is searched.
+func (s *BrowserService) searchLog(r io.Reader, sizeBytes int64, parameters url.Values) (*logSearchInfo, error) {
+	info := logSearchInfo{
+		Pattern: parameters.Get("q"),
+	}
+	contextLines, err := getGrepContextLines(parameters)
+	if err != nil {
+		return nil, err
+	}
+	info.ContextLines = contextLines
+	re, err := regexp.Compile(info.Pattern)
+	if err != nil {
+		return nil, util.StatusWrapfWithCode(err, codes.InvalidArgument, "Invalid regular expression %#v", info.Pattern)
+	}
+	if sizeBytes > s.maximumGrepSizeBytes {
+		r = io.LimitReader(r, s.maximumGrepSizeBytes)
+		info.SizeLimitReached = true
+	}
+
+	result, err := grepReader(r, func(line []byte) bool {
+		text, _ := stripANSIEscapeSequences(line)
+		return re.Match(text)
+	}, contextLines, int64(s.maximumSearchResults))
+	if err != nil {
+		return nil, util.StatusWrap(err, "Failed to search log")
+	}
+	info.IsBinary = result.isBinary
+	info.MatchCount = result.matchCount
+	info.MatchLimitReached = result.matchLimitReached
+	for _, hunk := range result.hunks {
+		lines := make([]logSearchLine, 0, len(hunk.Lines))
+		for _, line := range hunk.Lines {
+			logLine := logSearchLine{grepLine: line}
+			if line.IsMatch {
+				logLine.HTML = highlightLogLine(line.Text, re)
+			} else {
+				logLine.HTML = template.HTML(terminal.Render([]byte(line.Text)))
+			}
+			lines = append(lines, logLine)
+		}
+		info.Hunks = append(info.Hunks, logSearchHunk{Lines: lines})
+	}
+	return &info, nil
+}
+
+// handleActionLog displays the standard output or standard error of an
+// action in the log viewer. Logs that are stored in the Content
+// Addressable Storage are displayed by redirecting to the log viewer's
+// page for the log's digest. Logs that are stored inline in the action
+// result are displayed in their entirety.
+func (s *BrowserService) handleActionLog(w http.ResponseWriter, req *http.Request) {
+	ctx := extractContextFromRequest(req)
+	vars := mux.Vars(req)
+	_, actionResult, _, err := s.getActionResultDiffSide(ctx, req, vars["pageType"], "hash", "sizeBytes")
+	if err != nil {
+		s.renderError(w, req, err)
+		return
+	}
+
+	var name string
+	var logDigest *remoteexecution.Digest
+	var rawLogBody []byte
+	switch vars["log"] {
+	case "stdout":
+		name, logDigest, rawLogBody = "Standard output", actionResult.StdoutDigest, actionResult.StdoutRaw
+	case "stderr":
+		name, logDigest, rawLogBody = "Standard error", actionResult.StderrDigest, actionResult.StderrRaw
+	}
+	parameters := req.URL.Query()
+	parameters.Set("name", name)
+	if logDigest != nil {
+		http.Redirect(w, req, fmt.Sprintf("../../../../log/%s-%d/?%s", logDigest.Hash, logDigest.SizeBytes, parameters.Encode()), http.StatusFound)
+		return
+	}
+	if len(rawLogBody) == 0 {
+		s.renderError(w, req, status.Errorf(codes.NotFound, "Action result does not contain a log for %s", strings.ToLower(name)))
+		return
+	}
+
+	logPage := &logPageInfo{
+		Name:      name,
+		Digest:    digest.BadDigest,
+		EndOffset: int64(len(rawLogBody)),
+	}
+	if parameters.Get("q") != "" {
+		search, err := s.searchLog(bytes.NewReader(rawLogBody), int64(len(rawLogBody)), parameters)
+		if err != nil {
+			s.renderError(w, req, err)
+			return
+		}
+		logPage.Search = search
+	} else {
+		logPage.Contents = rawLogBody
+		logPage.HTML = template.HTML(terminal.Render(rawLogBody))
+	}
+	s.renderLogPage(w, req, logPage)
+}
@@ -0,0 +1,233 @@
+package main
+
+import (
+	"bytes"
+	"context"
+	"html/template"
+	"io"
+	"log"
+	"net/http"
+	"net/url"
+	"strconv"
+
+	"github.com/buildbarn/bb-browser/pkg/proto/query"
+	"github.com/buildbarn/bb-storage/pkg/digest"
+	"github.com/buildbarn/bb-storage/pkg/util"
+	"github.com/buildkite/terminal-to-html"
+
+	"google.golang.org/grpc/codes"
+	"google.golang.org/grpc/status"
+)
+
+// logPageInfo contains the information that we display on pages of
+// the log viewer, which displays a log file stored in the Content
+// Addressable Storage one page at a time.
+type logPageInfo struct {
+	Name          string
+	Digest        digest.Digest
+	Offset        int64
+	EndOffset     int64
+	PageSizeBytes int64
+	Contents      []byte
+	HTML          template.HTML
+	Search        *logSearchInfo
+}
+
+// HasDigest returns whether the log is stored in the Content
+// Addressable Storage. Logs that are stored inline in the action
+// result are displayed in their entirety, meaning no navigation
+// between pages is possible.
+func (lp *logPageInfo) HasDigest() bool {
+	return lp.Digest != digest.BadDigest
+}
+
+func (lp *logPageInfo) getURL(key string, offset int64) string {
+	pageQuery := url.Values{}
+	if lp.Name != "" {
+		pageQuery.Set("name", lp.Name)
+	}
+	pageQuery.Set(key, strconv.FormatInt(offset, 10))
+	return "?" + pageQuery.Encode()
+}
+
+// GetHeadURL returns a link to the first page of the log.
+func (lp *logPageInfo) GetHeadURL() string {
+	return lp.getURL("offset", 0)
+}
+
+// GetTailURL returns a link to the last page of the log.
+func (lp *logPageInfo) GetTailURL() string {
+	return lp.getURL("end", lp.Digest.GetSizeBytes())
+}
+
+// GetPreviousURL returns a link to the page preceding the current one.
+func (lp *logPageInfo) GetPreviousURL() string {
+	return lp.getURL("end", lp.Offset)
+}
+
+// GetNextURL returns a link to the page following the current one.
+func (lp *logPageInfo) GetNextURL() string {
+	return lp.getURL("offset", lp.EndOffset)
+}
+
+// HasPrevious returns whether the current page is preceded by other
+// pages.
+func (lp *logPageInfo) HasPrevious() bool {
+	return lp.Offset > 0
+}
+
+// HasNext returns whether the current page is followed by other pages.
+func (lp *logPageInfo) HasNext() bool {
+	return lp.EndOffset < lp.Digest.GetSizeBytes()
+}
+
+func (lp *logPageInfo) toProto() *query.LogPageInfo {
+	logPage := &query.LogPageInfo{
+		Digest:    getDigestProto(lp.Digest),
+		Offset:    lp.Offset,
+		EndOffset: lp.EndOffset,
+		Contents:  lp.Contents,
+	}
+	if lp.Search != nil {
+		logPage.Pattern = lp.Search.Pattern
+		logPage.Hunks = lp.Search.getHunksProto()
+		logPage.MatchCount = lp.Search.MatchCount
+		logPage.MatchLimitReached = lp.Search.MatchLimitReached
+		logPage.SizeLimitReached = lp.Search.SizeLimitReached
+	}
+	return logPage
+}
+
+// readLogRange reads a range of a log file from the Content
+// Addressable Storage. When not starting at the beginning of the log,
+// the byte preceding the range is read as well, and the remainder of
+// the line containing the start of the range is skipped. This prevents
+// pages from starting halfway through a line. If the range does not
+// contain the end of the line, it is split regardless.
+func (s *BrowserService) readLogRange(ctx context.Context, logDigest digest.Digest, startOffset, endOffset int64) ([]byte, int64, error) {
+	readOffset := startOffset
+	if startOffset > 0 {
+		readOffset--
+	}
+	data := make([]byte, endOffset-readOffset)
+	if len(data) > 0 {
+		n, err := s.contentAddressableStorage.Get(ctx, logDigest).ReadAt(data, readOffset)
+		if err != nil && err != io.EOF {
+			return nil, 0, util.StatusWrap(err, "Failed to read log")
+		}
+		data = data[:n]
+	}
+	if startOffset > 0 && len(data) > 0 {
+		if i := bytes.IndexByte(data, '\n'); i >= 0 && i+1 < len(data) {
+			return data[i+1:], readOffset + int64(i+1), nil
+		}
+		return data[1:], startOffset, nil
+	}
+	return data, startOffset, nil
+}
+
+// getLogPage reads a single page of a log file from the Content
+// Addressable Storage. Pages either start at a given offset, or end at
+// a given offset if atEnd is set. The latter is used to navigate to
+// preceding pages. Pages start at the beginning of a line, and end at
+// the end of a line, unless a single line is larger than a page.
+func (s *BrowserService) getLogPage(ctx context.Context, logDigest digest.Digest, offset int64, atEnd bool) (*logPageInfo, error) {
+	sizeBytes := logDigest.GetSizeBytes()
+	if offset < 0 || offset > sizeBytes {
+		return nil, status.Errorf(codes.InvalidArgument, "Offset %d is outside the log, which is %d bytes in size", offset, sizeBytes)
+	}
+
+	pageSizeBytes := s.maximumInlineLogSizeBytes
+	var data []byte
+	var startOffset int64
+	var err error
+	if atEnd {
+		data, startOffset, err = s.readLogRange(ctx, logDigest, max(offset-pageSizeBytes, 0), offset)
+		if err != nil {
+			return nil, err
+		}
+	} else {
+		// Read up to two pages, so that a full page can be
+		// displayed after skipping the remainder of the line
+		// containing the offset.
+		data, startOffset, err = s.readLogRange(ctx, logDigest, offset, min(offset+2*pageSizeBytes, sizeBytes))
+		if err != nil {
+			return nil, err
+		}
+		if int64(len(data)) > pageSizeBytes {
+			data = data[:pageSizeBytes]
+			if i := bytes.LastIndexByte(data, '\n'); i >= 0 {
+				data = data[:i+1]
+			}
+		}
+	}
+
+	return &logPageInfo{
+		Digest:        logDigest,
+		Offset:        startOffset,
+		EndOffset:     startOffset + int64(len(data)),
+		PageSizeBytes: pageSizeBytes,
+		Contents:      data,
+		HTML:          template.HTML(terminal.Render(data)),
+	}, nil
+}
+
+func (s *BrowserService) handleLog(w http.ResponseWriter, req *http.Request) {
+	logDigest, err := getDigestFromRequest(req)
+	if err != nil {
+		s.renderError(w, req, err)
+		return
+	}
+	// Pages either start at the offset provided through the
+	// "offset" query parameter, or end at the offset provided through
+	// the "end" query parameter.
+	offset, atEnd := int64(0), false
+	offsetStr := req.URL.Query().Get("offset")
+	if endStr := req.URL.Query().Get("end"); endStr != "" {
+		offsetStr, atEnd = endStr, true
+	}
+	if offsetStr != "" {
+		offset, err = strconv.ParseInt(offsetStr, 10, 64)
+		if err != nil {
+			s.renderError(w, req, util.StatusWrapfWithCode(err, codes.InvalidArgument, "Invalid offset %#v", offsetStr))
+			return
+		}
+	}
+
+	ctx := extractContextFromRequest(req)
+	var logPage *logPageInfo
+	if req.URL.Query().Get("q") != "" {
+		// Search the log, as opposed to displaying a page.
+		r := s.contentAddressableStorage.Get(ctx, logDigest).ToReader()
+		defer r.Close()
+		search, err := s.searchLog(r, logDigest.GetSizeBytes(), req.URL.Query())
+		if err != nil {
+			s.renderError(w, req, err)
+			return
+		}
+		logPage = &logPageInfo{
+			Digest:        logDigest,
+			EndOffset:     logDigest.GetSizeBytes(),
+			PageSizeBytes: s.maximumInlineLogSizeBytes,
+			Search:        search,
+		}
+	} else {
+		logPage, err = s.getLogPage(ctx, logDigest, offset, atEnd)
+		if err != nil {
+			s.renderError(w, req, err)
+			return
+		}
+	}
+	logPage.Name = req.URL.Query().Get("name")
+	s.renderLogPage(w, req, logPage)
+}
+
+func (s *BrowserService) renderLogPage(w http.ResponseWriter, req *http.Request, logPage *logPageInfo) {
+	if isJSONRequested(req) {
+		s.renderJSON(w, req, &query.Response{
+			Page: &query.Response_Log{Log: logPage.toProto()},
+		})
+	} else if err := s.templates.ExecuteTemplate(w, "page_log.html", logPage); err != nil {
+		log.Print(err)
+	}
+}
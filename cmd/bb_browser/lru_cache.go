@@ -0,0 +1,73 @@
+package main
+
+import (
+	"container/list"
+	"sync"
+)
+
+type lruCacheEntry[K comparable, V any] struct {
+	key       K
+	value     V
+	sizeBytes int64
+}
+
+// lruCache is a thread-safe cache that is bounded by the total size of
+// the values stored in it. Once full, entries are evicted in least
+// recently used order.
+type lruCache[K comparable, V any] struct {
+	maximumSizeBytes int64
+
+	lock           sync.Mutex
+	entries        map[K]*list.Element
+	lru            list.List
+	totalSizeBytes int64
+}
+
+func newLRUCache[K comparable, V any](maximumSizeBytes int64) *lruCache[K, V] {
+	return &lruCache[K, V]{
+		maximumSizeBytes: maximumSizeBytes,
+		entries:          map[K]*list.Element{},
+	}
+}
+
+// get returns the value stored under a given key, marking it as most
+// recently used.
+func (c *lruCache[K, V]) get(key K) (V, bool) {
+	c.lock.Lock()
+	defer c.lock.Unlock()
+
+	element, ok := c.entries[key]
+	if !ok {
+		var value V
+		return value, false
+	}
+	c.lru.MoveToBack(element)
+	return element.Value.(*lruCacheEntry[K, V]).value, true
+}
+
+// add a value to the cache, evicting the least recently used entries
+// if the cache has grown too large. Values that are larger than the
+// cache as a whole are not inserted.
+func (c *lruCache[K, V]) add(key K, value V, sizeBytes int64) {
+	if c.maximumSizeBytes <= 0 || sizeBytes > c.maximumSizeBytes {
+		return
+	}
+
+	c.lock.Lock()
+	defer c.lock.Unlock()
+
+	if _, ok := c.entries[key]; ok {
+		return
+	}
+	c.entries[key] = c.lru.PushBack(&lruCacheEntry[K, V]{
+		key:       key,
+		value:     value,
+		sizeBytes: sizeBytes,
+	})
+	c.totalSizeBytes += sizeBytes
+	for c.totalSizeBytes > c.maximumSizeBytes {
+		entry := c.lru.Remove(c.lru.Front()).(*lruCacheEntry[K, V])
+		delete(c.entries, entry.key)
+		c.totalSizeBytes -= entry.sizeBytes
+	}
+}
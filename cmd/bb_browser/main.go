@@ -95,8 +95,10 @@ func main() {
 			return util.StatusWrap(err, "Failed to create authorizer")
 		}
 
-		// nil the put and findMissing authorizers - bb-browser shouldn't ever use these APIs.
-		contentAddressableStorage = blobstore.NewAuthorizingBlobAccess(contentAddressableStorage, authorizer, nil, nil)
+		// nil the put authorizer - bb-browser shouldn't ever use
+		// this API. FindMissing is only used against the CAS, to
+		// check whether archives can be generated completely.
+		contentAddressableStorage = blobstore.NewAuthorizingBlobAccess(contentAddressableStorage, authorizer, nil, authorizer)
 		actionCache = blobstore.NewAuthorizingBlobAccess(actionCache, authorizer, nil, nil)
 
 		var initialSizeClassCache blobstore.BlobAccess
@@ -306,6 +308,44 @@ func main() {
 		}
 		bbClientdInstanceNamePatcher := digest.NewInstanceNamePatcher(digest.EmptyInstanceName, bbClientdInstanceNamePrefix)
 
+		maximumFileDiffSizeBytes := configuration.MaximumFileDiffSizeBytes
+		if maximumFileDiffSizeBytes == 0 {
+			maximumFileDiffSizeBytes = configuration.MaximumMessageSizeBytes
+		}
+		archivePrefetchParallelism := configuration.ArchivePrefetchParallelism
+		if archivePrefetchParallelism == 0 {
+			archivePrefetchParallelism = 1
+		}
+		archivePrefetchMemoryBudgetBytes := configuration.ArchivePrefetchMemoryBudgetBytes
+		if archivePrefetchMemoryBudgetBytes == 0 {
+			archivePrefetchMemoryBudgetBytes = configuration.MaximumMessageSizeBytes
+		}
+
+		maximumSearchDirectories := configuration.MaximumSearchDirectories
+		if maximumSearchDirectories == 0 {
+			maximumSearchDirectories = 10000
+		}
+		maximumSearchResults := configuration.MaximumSearchResults
+		if maximumSearchResults == 0 {
+			maximumSearchResults = 1000
+		}
+		maximumGrepSizeBytes := configuration.MaximumGrepSizeBytes
+		if maximumGrepSizeBytes == 0 {
+			maximumGrepSizeBytes = configuration.MaximumMessageSizeBytes
+		}
+		grepParallelism := configuration.GrepParallelism
+		if grepParallelism == 0 {
+			grepParallelism = 1
+		}
+		maximumInlineLogSizeBytes := configuration.MaximumInlineLogSizeBytes
+		if maximumInlineLogSizeBytes == 0 {
+			maximumInlineLogSizeBytes = 100000
+		}
+		maximumStreamedMessageSizeBytes := configuration.MaximumStreamedMessageSizeBytes
+		if maximumStreamedMessageSizeBytes == 0 {
+			maximumStreamedMessageSizeBytes = 4 * configuration.MaximumMessageSizeBytes
+		}
+
 		router := mux.NewRouter()
 		subrouter := router.PathPrefix(routePrefix).Subrouter()
 		NewBrowserService(
@@ -313,7 +353,23 @@ func main() {
 			actionCache,
 			initialSizeClassCache,
 			fileSystemAccessCache,
+			newMessageCache(
+				contentAddressableStorage,
+				authorizer,
+				int(configuration.MaximumMessageSizeBytes),
+				maximumStreamedMessageSizeBytes,
+				configuration.MessageCacheSizeBytes,
+				configuration.TreeIndexCacheSizeBytes),
 			int(configuration.MaximumMessageSizeBytes),
+			int(maximumFileDiffSizeBytes),
+			zstdPool,
+			int(archivePrefetchParallelism),
+			archivePrefetchMemoryBudgetBytes,
+			int(maximumSearchDirectories),
+			int(maximumSearchResults),
+			maximumGrepSizeBytes,
+			int(grepParallelism),
+			maximumInlineLogSizeBytes,
 			templates,
 			bbClientdInstanceNamePatcher,
 			subrouter)
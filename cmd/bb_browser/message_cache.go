@@ -0,0 +1,254 @@
+package main
+
+import (
+	"context"
+	"sync"
+
+	remoteexecution "github.com/bazelbuild/remote-apis/build/bazel/remote/execution/v2"
+	"github.com/buildbarn/bb-storage/pkg/auth"
+	"github.com/buildbarn/bb-storage/pkg/blobstore"
+	"github.com/buildbarn/bb-storage/pkg/digest"
+	"github.com/buildbarn/bb-storage/pkg/util"
+	"github.com/prometheus/client_golang/prometheus"
+
+	"golang.org/x/sync/singleflight"
+
+	"google.golang.org/grpc/codes"
+	"google.golang.org/grpc/status"
+	"google.golang.org/protobuf/proto"
+	"google.golang.org/protobuf/reflect/protoreflect"
+)
+
+var (
+	messageCachePrometheusMetrics sync.Once
+
+	messageCacheHitsTotal = prometheus.NewCounterVec(
+		prometheus.CounterOpts{
+			Namespace: "buildbarn",
+			Subsystem: "browser",
+			Name:      "message_cache_hits_total",
+			Help:      "Number of times a decoded message was served from the message cache.",
+		},
+		[]string{"message_type"})
+	messageCacheMissesTotal = prometheus.NewCounterVec(
+		prometheus.CounterOpts{
+			Namespace: "buildbarn",
+			Subsystem: "browser",
+			Name:      "message_cache_misses_total",
+			Help:      "Number of times a message had to be fetched from the Content Addressable Storage, as it was not present in the message cache.",
+		},
+		[]string{"message_type"})
+
+	treeIndexCacheHitsTotal = prometheus.NewCounter(
+		prometheus.CounterOpts{
+			Namespace: "buildbarn",
+			Subsystem: "browser",
+			Name:      "tree_index_cache_hits_total",
+			Help:      "Number of times an index of a Tree was served from the tree index cache.",
+		})
+	treeIndexCacheMissesTotal = prometheus.NewCounter(
+		prometheus.CounterOpts{
+			Namespace: "buildbarn",
+			Subsystem: "browser",
+			Name:      "tree_index_cache_misses_total",
+			Help:      "Number of times an index of a Tree had to be computed, as it was not present in the tree index cache.",
+		})
+)
+
+// messageCacheKey is the key of entries stored in messageCache. As the
+// same object may be decoded as different message types, the type is
+// part of the key.
+//
+// Directories contained in a Tree are not necessarily present in the
+// Content Addressable Storage as separate objects. To prevent them from
+// being returned when requested as such, they are keyed by the digest
+// of the Tree as well.
+type messageCacheKey struct {
+	digest      digest.Digest
+	messageType protoreflect.FullName
+	treeDigest  digest.Digest
+}
+
+var directoryMessageType = (&remoteexecution.Directory{}).ProtoReflect().Descriptor().FullName()
+
+// messageCache loads Protobuf messages from the Content Addressable
+// Storage (CAS). Decoded messages are retained in memory, so that
+// subsequent requests for the same object don't need to fetch and
+// unmarshal it again. As objects in the CAS are immutable, entries
+// never need to be invalidated. Entries are evicted in least recently
+// used order once the total size of the cached objects exceeds the
+// configured limit.
+//
+// In addition to messages, indexes of Tree messages are cached
+// separately. These permit looking up the child directories of a Tree
+// without hashing all of them.
+//
+// Tree messages, and Directory messages that are larger than the
+// maximum message size, are decoded one field at a time. Only the
+// individual directories contained in a Tree, and the individual nodes
+// contained in a Directory, are subject to the maximum message size.
+// As Directory messages are still held in memory in their entirety,
+// their size is limited separately. Tree messages are not limited by
+// size, as indexes of Tree messages grow with the number of
+// directories contained in them. Instead, the estimated size of the
+// index is limited.
+//
+// Messages returned by this type may be shared between requests.
+// Callers must not modify them.
+type messageCache struct {
+	contentAddressableStorage       blobstore.BlobAccess
+	authorizer                      auth.Authorizer
+	maximumMessageSizeBytes         int
+	maximumStreamedMessageSizeBytes int64
+
+	messages         *lruCache[messageCacheKey, proto.Message]
+	treeIndexes      *lruCache[digest.Digest, *treeIndex]
+	treeIndexesGroup singleflight.Group
+}
+
+// newMessageCache creates a messageCache that is placed in front of a
+// given Content Addressable Storage. As objects served from the cache
+// bypass any authorization performed by the storage backend, the
+// authorizer is consulted for every cache hit. If a cache size is zero,
+// no messages or Tree indexes are cached, respectively.
+func newMessageCache(contentAddressableStorage blobstore.BlobAccess, authorizer auth.Authorizer, maximumMessageSizeBytes int, maximumStreamedMessageSizeBytes, messageCacheSizeBytes, treeIndexCacheSizeBytes int64) *messageCache {
+	messageCachePrometheusMetrics.Do(func() {
+		prometheus.MustRegister(messageCacheHitsTotal)
+		prometheus.MustRegister(messageCacheMissesTotal)
+		prometheus.MustRegister(treeIndexCacheHitsTotal)
+		prometheus.MustRegister(treeIndexCacheMissesTotal)
+	})
+
+	return &messageCache{
+		contentAddressableStorage:       contentAddressableStorage,
+		authorizer:                      authorizer,
+		maximumMessageSizeBytes:         maximumMessageSizeBytes,
+		maximumStreamedMessageSizeBytes: maximumStreamedMessageSizeBytes,
+		messages:                        newLRUCache[messageCacheKey, proto.Message](messageCacheSizeBytes),
+		treeIndexes:                     newLRUCache[digest.Digest, *treeIndex](treeIndexCacheSizeBytes),
+	}
+}
+
+func (mc *messageCache) authorize(ctx context.Context, blobDigest digest.Digest) error {
+	if err := auth.AuthorizeSingleInstanceName(ctx, mc.authorizer, blobDigest.GetInstanceName()); err != nil {
+		return util.StatusWrap(err, "Authorization")
+	}
+	return nil
+}
+
+// getOrDecode returns a message from the cache, or calls the provided
+// function to decode it if it is not present.
+func (mc *messageCache) getOrDecode(ctx context.Context, blobDigest digest.Digest, messageType protoreflect.FullName, decode func() (proto.Message, error)) (proto.Message, error) {
+	return mc.getOrDecodeWithKey(ctx, messageCacheKey{
+		digest:      blobDigest,
+		messageType: messageType,
+	}, decode)
+}
+
+// getOrDecodeWithKey is identical to getOrDecode, except that the full
+// key of the cache entry is provided.
+func (mc *messageCache) getOrDecodeWithKey(ctx context.Context, key messageCacheKey, decode func() (proto.Message, error)) (proto.Message, error) {
+	if mc.messages.maximumSizeBytes <= 0 {
+		return decode()
+	}
+
+	blobDigest, messageType := key.digest, key.messageType
+	if cachedMessage, ok := mc.messages.get(key); ok {
+		if err := mc.authorize(ctx, blobDigest); err != nil {
+			return nil, err
+		}
+		messageCacheHitsTotal.WithLabelValues(string(messageType)).Inc()
+		return cachedMessage, nil
+	}
+
+	messageCacheMissesTotal.WithLabelValues(string(messageType)).Inc()
+	decodedMessage, err := decode()
+	if err != nil {
+		return nil, err
+	}
+	// The size of the object in the CAS is used as an estimate of
+	// its size in memory.
+	mc.messages.add(key, decodedMessage, blobDigest.GetSizeBytes())
+	return decodedMessage, nil
+}
+
+// Get a message from the Content Addressable Storage, unmarshaling it
+// into the provided message if it is not present in the cache.
+func (mc *messageCache) Get(ctx context.Context, blobDigest digest.Digest, message proto.Message) (proto.Message, error) {
+	return mc.getOrDecode(ctx, blobDigest, message.ProtoReflect().Descriptor().FullName(), func() (proto.Message, error) {
+		return mc.contentAddressableStorage.Get(ctx, blobDigest).ToProto(message, mc.maximumMessageSizeBytes)
+	})
+}
+
+// checkStreamedMessageSize returns an error if an object is too large
+// to be decoded one field at a time.
+func (mc *messageCache) checkStreamedMessageSize(blobDigest digest.Digest) error {
+	if sizeBytes := blobDigest.GetSizeBytes(); sizeBytes > mc.maximumStreamedMessageSizeBytes {
+		return status.Errorf(codes.InvalidArgument, "Object is %d bytes in size, while a maximum of %d bytes is permitted", sizeBytes, mc.maximumStreamedMessageSizeBytes)
+	}
+	return nil
+}
+
+// GetDirectory returns a Directory message stored in the Content
+// Addressable Storage. Directory messages that exceed the maximum
+// message size are decoded one node at a time.
+func (mc *messageCache) GetDirectory(ctx context.Context, directoryDigest digest.Digest) (*remoteexecution.Directory, error) {
+	directoryMessage, err := mc.getOrDecode(ctx, directoryDigest, directoryMessageType, func() (proto.Message, error) {
+		if directoryDigest.GetSizeBytes() <= int64(mc.maximumMessageSizeBytes) {
+			return mc.contentAddressableStorage.Get(ctx, directoryDigest).ToProto(&remoteexecution.Directory{}, mc.maximumMessageSizeBytes)
+		}
+		if err := mc.checkStreamedMessageSize(directoryDigest); err != nil {
+			return nil, err
+		}
+		r := mc.contentAddressableStorage.Get(ctx, directoryDigest).ToReader()
+		defer r.Close()
+		return readDirectory(r, mc.maximumMessageSizeBytes)
+	})
+	if err != nil {
+		return nil, err
+	}
+	return directoryMessage.(*remoteexecution.Directory), nil
+}
+
+// GetTreeIndex returns an index of a Tree message stored in the
+// Content Addressable Storage. As the Tree is decoded one directory at
+// a time, only the directories contained in it are limited to the
+// maximum message size. The Tree as a whole is only limited by the
+// estimated size of its index.
+//
+// Concurrent requests for the same Tree are deduplicated, so that
+// large Trees are only read and hashed once.
+func (mc *messageCache) GetTreeIndex(ctx context.Context, treeDigest digest.Digest) (*treeIndex, error) {
+	if mc.treeIndexes.maximumSizeBytes > 0 {
+		if cachedIndex, ok := mc.treeIndexes.get(treeDigest); ok {
+			if err := mc.authorize(ctx, treeDigest); err != nil {
+				return nil, err
+			}
+			treeIndexCacheHitsTotal.Inc()
+			return cachedIndex, nil
+		}
+		treeIndexCacheMissesTotal.Inc()
+	}
+
+	index, err, shared := mc.treeIndexesGroup.Do(treeDigest.GetKey(digest.KeyWithInstance), func() (interface{}, error) {
+		r := mc.contentAddressableStorage.Get(ctx, treeDigest).ToReader()
+		defer r.Close()
+		index, err := readTreeIndex(r, mc, treeDigest, mc.maximumMessageSizeBytes, int64(mc.maximumMessageSizeBytes), mc.maximumStreamedMessageSizeBytes)
+		if err != nil {
+			return nil, err
+		}
+		mc.treeIndexes.add(treeDigest, index, index.getSizeBytes())
+		return index, nil
+	})
+	if err != nil {
+		return nil, err
+	}
+	if shared {
+		// The Tree may have been read on behalf of another
+		// request, meaning no authorization took place.
+		if err := mc.authorize(ctx, treeDigest); err != nil {
+			return nil, err
+		}
+	}
+	return index.(*treeIndex), nil
+}
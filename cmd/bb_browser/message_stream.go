@@ -0,0 +1,203 @@
+package main
+
+import (
+	"bufio"
+	"encoding/binary"
+	"io"
+
+	remoteexecution "github.com/bazelbuild/remote-apis/build/bazel/remote/execution/v2"
+	"github.com/buildbarn/bb-storage/pkg/digest"
+	"github.com/buildbarn/bb-storage/pkg/util"
+
+	"google.golang.org/grpc/codes"
+	"google.golang.org/grpc/status"
+	"google.golang.org/protobuf/encoding/protowire"
+	"google.golang.org/protobuf/proto"
+)
+
+// Field numbers of the Tree message.
+const (
+	treeRootFieldNumber     protowire.Number = 1
+	treeChildrenFieldNumber protowire.Number = 2
+)
+
+// readMessageFields reads the top-level fields of a Protobuf message
+// from a stream, one at a time. This permits processing messages that
+// are too large to be unmarshaled as a whole, such as Tree messages of
+// large output directories. The size of each individual field is
+// limited.
+//
+// For every field, the provided function is called with the full
+// encoding of the field, including its tag, the encoding of its value,
+// and the offset of the value within the message.
+func readMessageFields(r io.Reader, maximumFieldSizeBytes int, handleField func(fieldNumber protowire.Number, encodedField, encodedValue []byte, valueOffsetBytes int64) error) error {
+	br := countingByteReader{Reader: bufio.NewReader(r)}
+	for {
+		tag, err := binary.ReadUvarint(&br)
+		if err == io.EOF {
+			return nil
+		} else if err != nil {
+			return convertMessageStreamError(err)
+		}
+		fieldNumber, wireType := protowire.DecodeTag(tag)
+		if !fieldNumber.IsValid() {
+			return status.Errorf(codes.InvalidArgument, "Message contains invalid field number %d", fieldNumber)
+		}
+
+		encodedField := protowire.AppendTag(nil, fieldNumber, wireType)
+		valueOffset := len(encodedField)
+		valueOffsetBytes := br.offsetBytes
+		switch wireType {
+		case protowire.VarintType:
+			value, err := binary.ReadUvarint(&br)
+			if err != nil {
+				return convertMessageStreamError(err)
+			}
+			encodedField = protowire.AppendVarint(encodedField, value)
+		case protowire.Fixed32Type:
+			encodedField = append(encodedField, make([]byte, 4)...)
+			if _, err := io.ReadFull(&br, encodedField[valueOffset:]); err != nil {
+				return convertMessageStreamError(err)
+			}
+		case protowire.Fixed64Type:
+			encodedField = append(encodedField, make([]byte, 8)...)
+			if _, err := io.ReadFull(&br, encodedField[valueOffset:]); err != nil {
+				return convertMessageStreamError(err)
+			}
+		case protowire.BytesType:
+			length, err := binary.ReadUvarint(&br)
+			if err != nil {
+				return convertMessageStreamError(err)
+			}
+			if length > uint64(maximumFieldSizeBytes) {
+				return status.Errorf(codes.InvalidArgument, "Field %d has a size of %d bytes, which exceeds the maximum of %d bytes", fieldNumber, length, maximumFieldSizeBytes)
+			}
+			encodedField = protowire.AppendVarint(encodedField, length)
+			valueOffset = len(encodedField)
+			valueOffsetBytes = br.offsetBytes
+			encodedField = append(encodedField, make([]byte, length)...)
+			if _, err := io.ReadFull(&br, encodedField[valueOffset:]); err != nil {
+				return convertMessageStreamError(err)
+			}
+		default:
+			return status.Errorf(codes.InvalidArgument, "Field %d has unsupported wire type %d", fieldNumber, wireType)
+		}
+
+		if err := handleField(fieldNumber, encodedField, encodedField[valueOffset:], valueOffsetBytes); err != nil {
+			return err
+		}
+	}
+}
+
+// countingByteReader keeps track of the number of bytes consumed from
+// a buffered reader, so that the offsets of fields within a message can
+// be determined.
+type countingByteReader struct {
+	*bufio.Reader
+	offsetBytes int64
+}
+
+func (r *countingByteReader) Read(p []byte) (int, error) {
+	n, err := r.Reader.Read(p)
+	r.offsetBytes += int64(n)
+	return n, err
+}
+
+func (r *countingByteReader) ReadByte() (byte, error) {
+	b, err := r.Reader.ReadByte()
+	if err == nil {
+		r.offsetBytes++
+	}
+	return b, err
+}
+
+// convertMessageStreamError converts errors returned while reading a
+// message from a stream. Errors returned by the stream are propagated
+// as is, as they may indicate that the object could not be found or
+// failed checksum validation.
+func convertMessageStreamError(err error) error {
+	if err == io.EOF || err == io.ErrUnexpectedEOF {
+		return status.Error(codes.InvalidArgument, "Message is truncated")
+	}
+	if _, ok := status.FromError(err); !ok {
+		return status.Error(codes.InvalidArgument, err.Error())
+	}
+	return err
+}
+
+// readDirectory decodes a Directory message from a stream. Only the
+// size of every node contained in it is limited. The caller is
+// responsible for limiting the size of the Directory message as a
+// whole.
+func readDirectory(r io.Reader, maximumFieldSizeBytes int) (*remoteexecution.Directory, error) {
+	var directory remoteexecution.Directory
+	if err := readMessageFields(r, maximumFieldSizeBytes, func(fieldNumber protowire.Number, encodedField, encodedValue []byte, valueOffsetBytes int64) error {
+		// Unmarshaling a field into an existing message is
+		// equivalent to unmarshaling the full message, as
+		// repeated fields are appended to.
+		if err := (proto.UnmarshalOptions{Merge: true}).Unmarshal(encodedField, &directory); err != nil {
+			return util.StatusWrapfWithCode(err, codes.InvalidArgument, "Failed to unmarshal field %d", fieldNumber)
+		}
+		return nil
+	}); err != nil {
+		return nil, err
+	}
+	return &directory, nil
+}
+
+// readTreeIndex decodes a Tree message from a stream, and creates an
+// index of the directories contained in it. The root directory is
+// retained in memory, as are the encodings of child directories up to
+// a total size of maximumEncodedSizeBytes. Of every other child
+// directory, only its digest and its location within the Tree are
+// stored. The size of every Directory message contained in the Tree is
+// limited, as is the estimated size of the index.
+//
+// As the digests of child directories are computed over the encoding
+// of the directories as stored in the Tree, the directories don't need
+// to be unmarshaled.
+func readTreeIndex(r io.Reader, mc *messageCache, treeDigest digest.Digest, maximumDirectorySizeBytes int, maximumEncodedSizeBytes, maximumIndexSizeBytes int64) (*treeIndex, error) {
+	digestFunction := treeDigest.GetDigestFunction()
+	index := treeIndex{
+		messageCache: mc,
+		treeDigest:   treeDigest,
+		root:         &remoteexecution.Directory{},
+		children:     map[digest.Digest]treeIndexChild{},
+	}
+	if err := readMessageFields(r, maximumDirectorySizeBytes, func(fieldNumber protowire.Number, encodedField, encodedValue []byte, valueOffsetBytes int64) error {
+		switch fieldNumber {
+		case treeRootFieldNumber:
+			if err := (proto.UnmarshalOptions{Merge: true}).Unmarshal(encodedValue, index.root); err != nil {
+				return util.StatusWrapWithCode(err, codes.InvalidArgument, "Failed to unmarshal root directory")
+			}
+		case treeChildrenFieldNumber:
+			digestGenerator := digestFunction.NewGenerator(int64(len(encodedValue)))
+			if _, err := digestGenerator.Write(encodedValue); err != nil {
+				return err
+			}
+			childDigest := digestGenerator.Sum()
+			if _, ok := index.children[childDigest]; !ok {
+				child := treeIndexChild{
+					offsetBytes: valueOffsetBytes,
+					sizeBytes:   len(encodedValue),
+				}
+				if sizeBytes := int64(len(encodedValue)); index.encodedSizeBytes+sizeBytes <= maximumEncodedSizeBytes {
+					// Retaining the encoding prevents
+					// round trips to the Content
+					// Addressable Storage when the
+					// child directory is accessed.
+					child.encodedDirectory = encodedValue
+					index.encodedSizeBytes += sizeBytes
+				}
+				index.children[childDigest] = child
+				if indexSizeBytes := int64(len(index.children))*treeIndexChildSizeBytes + index.encodedSizeBytes; indexSizeBytes > maximumIndexSizeBytes {
+					return status.Errorf(codes.InvalidArgument, "Tree contains at least %d directories, causing its index to exceed the maximum size of %d bytes", len(index.children), maximumIndexSizeBytes)
+				}
+			}
+		}
+		return nil
+	}); err != nil {
+		return nil, err
+	}
+	return &index, nil
+}
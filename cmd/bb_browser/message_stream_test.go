@@ -0,0 +1,341 @@
+package main
+
+import (
+	"bytes"
+	"testing"
+
+	remoteexecution "github.com/bazelbuild/remote-apis/build/bazel/remote/execution/v2"
+	"github.com/buildbarn/bb-storage/pkg/digest"
+
+	"google.golang.org/grpc/codes"
+	"google.golang.org/grpc/status"
+	"google.golang.org/protobuf/encoding/protowire"
+	"google.golang.org/protobuf/proto"
+)
+
+func mustMarshal(t *testing.T, m proto.Message) []byte {
+	data, err := proto.MarshalOptions{Deterministic: true}.Marshal(m)
+	if err != nil {
+		t.Fatal(err)
+	}
+	return data
+}
+
+func concatBytes(slices ...[]byte) []byte {
+	return bytes.Join(slices, nil)
+}
+
+func checkStatus(t *testing.T, err error, expectedCode codes.Code, expectedMessage string) {
+	t.Helper()
+	if s := status.Convert(err); s.Code() != expectedCode || s.Message() != expectedMessage {
+		t.Fatalf("Expected error (%s, %#v), got %v", expectedCode, expectedMessage, err)
+	}
+}
+
+var (
+	testDirectoryA = &remoteexecution.Directory{
+		Files: []*remoteexecution.FileNode{
+			{
+				Name: "hello.txt",
+				Digest: &remoteexecution.Digest{
+					Hash:      "a665a45920422f9d417e4867efdc4fb8a04a1f3fff1fa07e998e86f7f7a27ae3",
+					SizeBytes: 3,
+				},
+				IsExecutable: true,
+			},
+		},
+		Symlinks: []*remoteexecution.SymlinkNode{
+			{Name: "link", Target: "hello.txt"},
+		},
+	}
+	testDirectoryB = &remoteexecution.Directory{
+		Directories: []*remoteexecution.DirectoryNode{
+			{
+				Name: "a",
+				Digest: &remoteexecution.Digest{
+					Hash:      "3d8a2b5c0f4b1b6a8f1e6f1d0d5f8d0a4a2b9c3e7f6d5c4b3a2918273645f0e1",
+					SizeBytes: 100,
+				},
+			},
+		},
+		Files: []*remoteexecution.FileNode{
+			{
+				Name: "empty",
+				Digest: &remoteexecution.Digest{
+					Hash:      "e3b0c44298fc1c149afbf4c8996fb92427ae41e4649b934ca495991b7852b855",
+					SizeBytes: 0,
+				},
+			},
+		},
+	}
+)
+
+func TestReadDirectory(t *testing.T) {
+	encodedDirectoryA := mustMarshal(t, testDirectoryA)
+	unknownFields := concatBytes(
+		protowire.AppendVarint(protowire.AppendTag(nil, 100, protowire.VarintType), 42),
+		protowire.AppendFixed32(protowire.AppendTag(nil, 101, protowire.Fixed32Type), 7),
+		protowire.AppendFixed64(protowire.AppendTag(nil, 102, protowire.Fixed64Type), 8),
+		protowire.AppendBytes(protowire.AppendTag(nil, 103, protowire.BytesType), []byte("Hello")))
+
+	for _, testCase := range []struct {
+		name                  string
+		encodedDirectory      []byte
+		maximumFieldSizeBytes int
+		expectedCode          codes.Code
+		expectedMessage       string
+	}{
+		{
+			name:                  "Empty",
+			encodedDirectory:      nil,
+			maximumFieldSizeBytes: 100,
+		},
+		{
+			name:                  "Simple",
+			encodedDirectory:      encodedDirectoryA,
+			maximumFieldSizeBytes: 100,
+		},
+		{
+			name:                  "Concatenated",
+			encodedDirectory:      concatBytes(encodedDirectoryA, mustMarshal(t, testDirectoryB)),
+			maximumFieldSizeBytes: 100,
+		},
+		{
+			name:                  "UnknownFields",
+			encodedDirectory:      concatBytes(unknownFields, encodedDirectoryA, unknownFields),
+			maximumFieldSizeBytes: 100,
+		},
+		{
+			name:                  "TruncatedTag",
+			encodedDirectory:      []byte{0x80},
+			maximumFieldSizeBytes: 100,
+			expectedCode:          codes.InvalidArgument,
+			expectedMessage:       "Message is truncated",
+		},
+		{
+			name:                  "TruncatedValue",
+			encodedDirectory:      encodedDirectoryA[:len(encodedDirectoryA)-1],
+			maximumFieldSizeBytes: 100,
+			expectedCode:          codes.InvalidArgument,
+			expectedMessage:       "Message is truncated",
+		},
+		{
+			name:                  "TruncatedFixed64",
+			encodedDirectory:      protowire.AppendTag(nil, 102, protowire.Fixed64Type),
+			maximumFieldSizeBytes: 100,
+			expectedCode:          codes.InvalidArgument,
+			expectedMessage:       "Message is truncated",
+		},
+		{
+			name:                  "FieldTooLarge",
+			encodedDirectory:      encodedDirectoryA,
+			maximumFieldSizeBytes: 10,
+			expectedCode:          codes.InvalidArgument,
+			expectedMessage:       "Field 1 has a size of 83 bytes, which exceeds the maximum of 10 bytes",
+		},
+		{
+			name:                  "InvalidFieldNumber",
+			encodedDirectory:      protowire.AppendVarint(nil, protowire.EncodeTag(0, protowire.VarintType)),
+			maximumFieldSizeBytes: 100,
+			expectedCode:          codes.InvalidArgument,
+			expectedMessage:       "Message contains invalid field number 0",
+		},
+		{
+			name:                  "UnsupportedWireType",
+			encodedDirectory:      protowire.AppendTag(nil, 1, protowire.StartGroupType),
+			maximumFieldSizeBytes: 100,
+			expectedCode:          codes.InvalidArgument,
+			expectedMessage:       "Field 1 has unsupported wire type 3",
+		},
+	} {
+		t.Run(testCase.name, func(t *testing.T) {
+			directory, err := readDirectory(bytes.NewReader(testCase.encodedDirectory), testCase.maximumFieldSizeBytes)
+			checkStatus(t, err, testCase.expectedCode, testCase.expectedMessage)
+			if err != nil {
+				return
+			}
+
+			// The result should be identical to unmarshaling
+			// the message as a whole, including unknown fields.
+			var expectedDirectory remoteexecution.Directory
+			if err := proto.Unmarshal(testCase.encodedDirectory, &expectedDirectory); err != nil {
+				t.Fatal(err)
+			}
+			if !proto.Equal(directory, &expectedDirectory) {
+				t.Fatalf("Expected directory %v, got %v", &expectedDirectory, directory)
+			}
+		})
+	}
+}
+
+func TestReadTreeIndex(t *testing.T) {
+	digestFunction := digest.MustNewFunction("hello", remoteexecution.DigestFunction_SHA256)
+	treeDigest := digest.MustNewDigest("hello", remoteexecution.DigestFunction_SHA256, "d8b9c5d6ba5e8a7b5f1d4b0de8e3f2e4b1c9a7c2d4e5f60718293a4b5c6d7e8f", 123)
+	getDigest := func(encodedDirectory []byte) digest.Digest {
+		digestGenerator := digestFunction.NewGenerator(int64(len(encodedDirectory)))
+		if _, err := digestGenerator.Write(encodedDirectory); err != nil {
+			t.Fatal(err)
+		}
+		return digestGenerator.Sum()
+	}
+	encodedDirectoryA := mustMarshal(t, testDirectoryA)
+	encodedDirectoryB := mustMarshal(t, testDirectoryB)
+	digestA := getDigest(encodedDirectoryA)
+	digestB := getDigest(encodedDirectoryB)
+
+	for _, testCase := range []struct {
+		name                      string
+		encodedTree               []byte
+		maximumDirectorySizeBytes int
+		maximumEncodedSizeBytes   int64
+		maximumIndexSizeBytes     int64
+		expectedRoot              *remoteexecution.Directory
+		expectedInlineChildren    []digest.Digest
+		expectedOtherChildren     []digest.Digest
+		expectedCode              codes.Code
+		expectedMessage           string
+	}{
+		{
+			name:                      "Empty",
+			encodedTree:               nil,
+			maximumDirectorySizeBytes: 1000,
+			maximumEncodedSizeBytes:   1000,
+			maximumIndexSizeBytes:     1000,
+			expectedRoot:              &remoteexecution.Directory{},
+		},
+		{
+			name: "AllChildrenInline",
+			encodedTree: mustMarshal(t, &remoteexecution.Tree{
+				Root:     testDirectoryB,
+				Children: []*remoteexecution.Directory{testDirectoryA, testDirectoryB},
+			}),
+			maximumDirectorySizeBytes: 1000,
+			maximumEncodedSizeBytes:   1000,
+			maximumIndexSizeBytes:     1000,
+			expectedRoot:              testDirectoryB,
+			expectedInlineChildren:    []digest.Digest{digestA, digestB},
+		},
+		{
+			name: "SomeChildrenInline",
+			encodedTree: mustMarshal(t, &remoteexecution.Tree{
+				Root:     testDirectoryA,
+				Children: []*remoteexecution.Directory{testDirectoryB, testDirectoryA},
+			}),
+			maximumDirectorySizeBytes: 1000,
+			maximumEncodedSizeBytes:   int64(len(encodedDirectoryB)),
+			maximumIndexSizeBytes:     1000,
+			expectedRoot:              testDirectoryA,
+			expectedInlineChildren:    []digest.Digest{digestB},
+			expectedOtherChildren:     []digest.Digest{digestA},
+		},
+		{
+			name: "NoChildrenInline",
+			encodedTree: mustMarshal(t, &remoteexecution.Tree{
+				Children: []*remoteexecution.Directory{testDirectoryA, testDirectoryB},
+			}),
+			maximumDirectorySizeBytes: 1000,
+			maximumEncodedSizeBytes:   0,
+			maximumIndexSizeBytes:     1000,
+			expectedRoot:              &remoteexecution.Directory{},
+			expectedOtherChildren:     []digest.Digest{digestA, digestB},
+		},
+		{
+			// Duplicate children should only be stored once,
+			// and unknown fields should be ignored.
+			name: "DuplicateChildrenAndUnknownFields",
+			encodedTree: concatBytes(
+				protowire.AppendVarint(protowire.AppendTag(nil, 100, protowire.VarintType), 42),
+				mustMarshal(t, &remoteexecution.Tree{
+					Children: []*remoteexecution.Directory{testDirectoryA, testDirectoryA, testDirectoryA},
+				}),
+				protowire.AppendBytes(protowire.AppendTag(nil, 101, protowire.BytesType), []byte("Hello"))),
+			maximumDirectorySizeBytes: 1000,
+			maximumEncodedSizeBytes:   1000,
+			maximumIndexSizeBytes:     treeIndexChildSizeBytes + int64(len(encodedDirectoryA)),
+			expectedRoot:              &remoteexecution.Directory{},
+			expectedInlineChildren:    []digest.Digest{digestA},
+		},
+		{
+			name: "IndexTooLarge",
+			encodedTree: mustMarshal(t, &remoteexecution.Tree{
+				Children: []*remoteexecution.Directory{testDirectoryA, testDirectoryB},
+			}),
+			maximumDirectorySizeBytes: 1000,
+			maximumEncodedSizeBytes:   0,
+			maximumIndexSizeBytes:     treeIndexChildSizeBytes,
+			expectedCode:              codes.InvalidArgument,
+			expectedMessage:           "Tree contains at least 2 directories, causing its index to exceed the maximum size of 128 bytes",
+		},
+		{
+			name: "DirectoryTooLarge",
+			encodedTree: mustMarshal(t, &remoteexecution.Tree{
+				Children: []*remoteexecution.Directory{testDirectoryA},
+			}),
+			maximumDirectorySizeBytes: 10,
+			maximumEncodedSizeBytes:   1000,
+			maximumIndexSizeBytes:     1000,
+			expectedCode:              codes.InvalidArgument,
+			expectedMessage:           "Field 2 has a size of 104 bytes, which exceeds the maximum of 10 bytes",
+		},
+		{
+			name: "Truncated",
+			encodedTree: func() []byte {
+				encodedTree := mustMarshal(t, &remoteexecution.Tree{
+					Children: []*remoteexecution.Directory{testDirectoryA},
+				})
+				return encodedTree[:len(encodedTree)-1]
+			}(),
+			maximumDirectorySizeBytes: 1000,
+			maximumEncodedSizeBytes:   1000,
+			maximumIndexSizeBytes:     1000,
+			expectedCode:              codes.InvalidArgument,
+			expectedMessage:           "Message is truncated",
+		},
+	} {
+		t.Run(testCase.name, func(t *testing.T) {
+			index, err := readTreeIndex(bytes.NewReader(testCase.encodedTree), nil, treeDigest, testCase.maximumDirectorySizeBytes, testCase.maximumEncodedSizeBytes, testCase.maximumIndexSizeBytes)
+			checkStatus(t, err, testCase.expectedCode, testCase.expectedMessage)
+			if err != nil {
+				return
+			}
+
+			if !proto.Equal(index.root, testCase.expectedRoot) {
+				t.Fatalf("Expected root directory %v, got %v", testCase.expectedRoot, index.root)
+			}
+			if expectedCount := len(testCase.expectedInlineChildren) + len(testCase.expectedOtherChildren); len(index.children) != expectedCount {
+				t.Fatalf("Expected %d children, got %d", expectedCount, len(index.children))
+			}
+			var expectedEncodedSizeBytes int64
+			for _, childDigest := range testCase.expectedInlineChildren {
+				child, ok := index.children[childDigest]
+				if !ok || child.encodedDirectory == nil {
+					t.Fatalf("Child %s is not stored inline", childDigest)
+				}
+				if getDigest(child.encodedDirectory) != childDigest {
+					t.Fatalf("Child %s has invalid inline contents", childDigest)
+				}
+				expectedEncodedSizeBytes += int64(len(child.encodedDirectory))
+			}
+			for _, childDigest := range testCase.expectedOtherChildren {
+				child, ok := index.children[childDigest]
+				if !ok || child.encodedDirectory != nil {
+					t.Fatalf("Child %s is not stored by reference", childDigest)
+				}
+			}
+			if index.encodedSizeBytes != expectedEncodedSizeBytes {
+				t.Fatalf("Expected %d bytes of inline children, got %d", expectedEncodedSizeBytes, index.encodedSizeBytes)
+			}
+
+			// The offsets of all children should point to
+			// their encoding within the Tree.
+			for childDigest, child := range index.children {
+				if child.offsetBytes < 0 || child.offsetBytes+int64(child.sizeBytes) > int64(len(testCase.encodedTree)) {
+					t.Fatalf("Child %s has out of bounds offset %d", childDigest, child.offsetBytes)
+				}
+				if getDigest(testCase.encodedTree[child.offsetBytes:child.offsetBytes+int64(child.sizeBytes)]) != childDigest {
+					t.Fatalf("Child %s has invalid offset %d", childDigest, child.offsetBytes)
+				}
+			}
+		})
+	}
+}
@@ -0,0 +1,197 @@
+package main
+
+import (
+	"bufio"
+	"context"
+	"fmt"
+	"log"
+	"net/http"
+
+	remoteexecution "github.com/bazelbuild/remote-apis/build/bazel/remote/execution/v2"
+	"github.com/buildbarn/bb-browser/pkg/proto/query"
+	"github.com/buildbarn/bb-storage/pkg/digest"
+
+	"golang.org/x/sync/semaphore"
+)
+
+// maximumRecursiveListingEntries is the maximum number of entries that
+// are returned as part of a recursive listing, so that listings of
+// large directory hierarchies don't exhaust memory. Totals are always
+// computed over all entries.
+const maximumRecursiveListingEntries = 10000
+
+// isRecursiveListingFormat returns whether the format requested
+// through the "format" query parameter causes a recursive listing of a
+// directory to be returned. Recursive listings can be returned as an
+// HTML page ("list"), as plain text ("list_text") or in machine
+// readable form ("list_json").
+func isRecursiveListingFormat(format string) bool {
+	return format == "list" || format == "list_text" || format == "list_json"
+}
+
+// recursiveListingEntry is a single directory, file or symbolic link
+// contained in a recursive listing. Digest is not set for symbolic
+// links.
+type recursiveListingEntry struct {
+	Path         string
+	IsDirectory  bool
+	IsSymlink    bool
+	Digest       digest.Digest
+	IsExecutable bool
+	Target       string
+}
+
+// GetMode returns the permissions of the entry, formatted like the
+// output of "ls -l".
+func (e *recursiveListingEntry) GetMode() string {
+	if e.IsDirectory {
+		return "drwxr-xr-x"
+	}
+	if e.IsSymlink {
+		return "lrwxrwxrwx"
+	}
+	if e.IsExecutable {
+		return "-r-xr-xr-x"
+	}
+	return "-r--r--r--"
+}
+
+func (e *recursiveListingEntry) toProto() *query.RecursiveListingInfo_Entry {
+	entry := &query.RecursiveListingInfo_Entry{
+		Type:         query.RecursiveListingInfo_Entry_FILE,
+		Path:         e.Path,
+		Digest:       getDigestProto(e.Digest),
+		IsExecutable: e.IsExecutable,
+		Target:       e.Target,
+	}
+	if e.IsDirectory {
+		entry.Type = query.RecursiveListingInfo_Entry_DIRECTORY
+	} else if e.IsSymlink {
+		entry.Type = query.RecursiveListingInfo_Entry_SYMLINK
+	}
+	return entry
+}
+
+// recursiveListingInfo contains the information that we display on
+// pages containing a recursive listing of a directory hierarchy, and
+// totals computed over all of its entries.
+type recursiveListingInfo struct {
+	Digest            digest.Digest
+	Entries           []recursiveListingEntry
+	EntryLimitReached bool
+	// The path of "${instance_name}/blobs/${digest_function}/"
+	// relative to the current page, so that links to files can be
+	// emitted.
+	RootDirectory string
+
+	FileCount       int64
+	DirectoryCount  int64
+	UniqueSizeBytes int64
+	TotalSizeBytes  int64
+}
+
+func (li *recursiveListingInfo) toProto() *query.RecursiveListingInfo {
+	entries := make([]*query.RecursiveListingInfo_Entry, 0, len(li.Entries))
+	for i := range li.Entries {
+		entries = append(entries, li.Entries[i].toProto())
+	}
+	return &query.RecursiveListingInfo{
+		Digest:            getDigestProto(li.Digest),
+		Entries:           entries,
+		FileCount:         li.FileCount,
+		DirectoryCount:    li.DirectoryCount,
+		UniqueSizeBytes:   li.UniqueSizeBytes,
+		TotalSizeBytes:    li.TotalSizeBytes,
+		EntryLimitReached: li.EntryLimitReached,
+	}
+}
+
+// getRecursiveListing computes a recursive listing of a directory
+// hierarchy. It uses the same traversal as the one that is used to
+// generate archives, meaning that directories are loaded concurrently
+// within the bounds of the memory budget, and that entries are listed
+// in the order in which they are written into archives.
+//
+// If maximumEntries is positive, no more than that number of entries
+// are returned. Totals are computed over all entries regardless.
+func (s *BrowserService) getRecursiveListing(ctx context.Context, directoryDigest digest.Digest, directory *remoteexecution.Directory, getDirectory directoryGetter, maximumEntries int) (*recursiveListingInfo, error) {
+	listing := recursiveListingInfo{
+		Digest: directoryDigest,
+	}
+	uniqueFiles := map[digest.Digest]struct{}{}
+	memory := semaphore.NewWeighted(s.archivePrefetchMemoryBudgetBytes)
+	if _, err := s.walkArchiveEntries(ctx, directoryDigest.GetDigestFunction(), directory, getDirectory, memory, 0, func(archiveEntry *archiveEntry) error {
+		entry := recursiveListingEntry{
+			Path:         archiveEntry.path,
+			Digest:       archiveEntry.digest,
+			IsExecutable: archiveEntry.isExecutable,
+		}
+		switch archiveEntry.entryType {
+		case archiveEntryTypeDirectory:
+			entry.IsDirectory = true
+			listing.DirectoryCount++
+		case archiveEntryTypeSymlink:
+			entry.IsSymlink = true
+			entry.Digest = digest.BadDigest
+			entry.Target = archiveEntry.target
+		case archiveEntryTypeFile, archiveEntryTypeDuplicateFile:
+			sizeBytes := archiveEntry.digest.GetSizeBytes()
+			listing.FileCount++
+			listing.TotalSizeBytes += sizeBytes
+			if _, ok := uniqueFiles[archiveEntry.digest]; !ok {
+				uniqueFiles[archiveEntry.digest] = struct{}{}
+				listing.UniqueSizeBytes += sizeBytes
+			}
+		}
+		if maximumEntries > 0 && len(listing.Entries) >= maximumEntries {
+			listing.EntryLimitReached = true
+		} else {
+			listing.Entries = append(listing.Entries, entry)
+		}
+		return nil
+	}); err != nil {
+		return nil, err
+	}
+	return &listing, nil
+}
+
+// generateRecursiveListing writes a recursive listing of a directory
+// hierarchy as the HTTP response, in the format requested through the
+// "format" query parameter.
+func (s *BrowserService) generateRecursiveListing(ctx context.Context, w http.ResponseWriter, req *http.Request, directoryDigest digest.Digest, directory *remoteexecution.Directory, getDirectory directoryGetter, rootDirectory string) {
+	listing, err := s.getRecursiveListing(ctx, directoryDigest, directory, getDirectory, maximumRecursiveListingEntries)
+	if err != nil {
+		s.renderError(w, req, err)
+		return
+	}
+	listing.RootDirectory = rootDirectory
+
+	switch req.URL.Query().Get("format") {
+	case "list_text":
+		w.Header().Set("Content-Type", "text/plain; charset=utf-8")
+		bw := bufio.NewWriter(w)
+		for _, entry := range listing.Entries {
+			if entry.IsSymlink {
+				fmt.Fprintf(bw, "%s\t-\t-\t%s -> %s\n", entry.GetMode(), entry.Path, entry.Target)
+			} else {
+				fmt.Fprintf(bw, "%s\t%d\t%s-%d\t%s\n", entry.GetMode(), entry.Digest.GetSizeBytes(), entry.Digest.GetHashString(), entry.Digest.GetSizeBytes(), entry.Path)
+			}
+		}
+		if listing.EntryLimitReached {
+			fmt.Fprintf(bw, "\nOnly the first %d entries are listed.\n", len(listing.Entries))
+		}
+		fmt.Fprintf(bw, "\n%d files, %d directories, %d unique bytes, %d bytes including duplicates\n", listing.FileCount, listing.DirectoryCount, listing.UniqueSizeBytes, listing.TotalSizeBytes)
+		if err := bw.Flush(); err != nil {
+			log.Print(err)
+			panic(http.ErrAbortHandler)
+		}
+	case "list_json":
+		s.renderJSON(w, req, &query.Response{
+			Page: &query.Response_RecursiveListing{RecursiveListing: listing.toProto()},
+		})
+	default:
+		if err := s.templates.ExecuteTemplate(w, "page_recursive_listing.html", listing); err != nil {
+			log.Print(err)
+		}
+	}
+}
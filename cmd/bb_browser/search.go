@@ -0,0 +1,239 @@
+package main
+
+import (
+	"context"
+	"fmt"
+	"log"
+	"net/http"
+	"net/url"
+	"path"
+	"regexp"
+	"strings"
+
+	remoteexecution "github.com/bazelbuild/remote-apis/build/bazel/remote/execution/v2"
+	"github.com/buildbarn/bb-browser/pkg/proto/query"
+	"github.com/buildbarn/bb-storage/pkg/digest"
+	"github.com/buildbarn/bb-storage/pkg/util"
+
+	"google.golang.org/grpc/codes"
+	"google.golang.org/grpc/status"
+)
+
+// isSearchFormat returns whether the format requested through the
+// "format" query parameter causes a directory hierarchy to be searched.
+// Results can be returned as an HTML page ("search") or in machine
+// readable form ("search_json").
+func isSearchFormat(format string) bool {
+	return format == "search" || format == "search_json"
+}
+
+// searchResult is a directory, file or symbolic link whose path matches
+// the pattern of a search.
+type searchResult struct {
+	recursiveListingEntry
+
+	// The link to the page of the directory or file, relative to the
+	// current page.
+	URL string
+}
+
+// searchInfo contains the information that we display on pages
+// containing the results of searching a directory hierarchy.
+type searchInfo struct {
+	Digest  digest.Digest
+	Pattern string
+	Syntax  string
+	Results []searchResult
+
+	DirectoriesVisited    int64
+	DirectoryLimitReached bool
+	ResultLimitReached    bool
+}
+
+// GetJSONURL returns a link to the results of the search in machine
+// readable form.
+func (si *searchInfo) GetJSONURL() string {
+	jsonQuery := url.Values{}
+	jsonQuery.Set("format", "search_json")
+	jsonQuery.Set("q", si.Pattern)
+	jsonQuery.Set("syntax", si.Syntax)
+	return "?" + jsonQuery.Encode()
+}
+
+func (si *searchInfo) toProto() *query.SearchInfo {
+	results := make([]*query.RecursiveListingInfo_Entry, 0, len(si.Results))
+	for i := range si.Results {
+		results = append(results, si.Results[i].toProto())
+	}
+	return &query.SearchInfo{
+		Digest:                getDigestProto(si.Digest),
+		Pattern:               si.Pattern,
+		Syntax:                si.Syntax,
+		Results:               results,
+		DirectoriesVisited:    si.DirectoriesVisited,
+		DirectoryLimitReached: si.DirectoryLimitReached,
+		ResultLimitReached:    si.ResultLimitReached,
+	}
+}
+
+// newSearchMatcher returns a function that tests whether the path of
+// an entry in a directory hierarchy matches a pattern. Glob patterns
+// that don't contain a slash are matched against the last component of
+// the path, while those containing a slash are matched against the
+// full path. Regular expressions are matched against any part of the
+// full path.
+func newSearchMatcher(pattern, syntax string) (func(p string) bool, error) {
+	switch syntax {
+	case "glob":
+		if _, err := path.Match(pattern, ""); err != nil {
+			return nil, util.StatusWrapfWithCode(err, codes.InvalidArgument, "Invalid glob pattern %#v", pattern)
+		}
+		if strings.Contains(pattern, "/") {
+			return func(p string) bool {
+				matched, _ := path.Match(pattern, p)
+				return matched
+			}, nil
+		}
+		return func(p string) bool {
+			matched, _ := path.Match(pattern, path.Base(p))
+			return matched
+		}, nil
+	case "regex":
+		re, err := regexp.Compile(pattern)
+		if err != nil {
+			return nil, util.StatusWrapfWithCode(err, codes.InvalidArgument, "Invalid regular expression %#v", pattern)
+		}
+		return re.MatchString, nil
+	default:
+		return nil, status.Errorf(codes.InvalidArgument, "Invalid pattern syntax %#v", syntax)
+	}
+}
+
+// searchDirectory walks a directory hierarchy in breadth-first order,
+// and returns all entries whose paths match. As every directory that
+// is visited may need to be loaded from the Content Addressable
+// Storage, the search is stopped when either the number of visited
+// directories or the number of results exceeds the configured limit.
+func (s *BrowserService) searchDirectory(ctx context.Context, info *searchInfo, digestFunction digest.Function, rootDirectory *remoteexecution.Directory, getDirectory directoryGetter, match func(p string) bool) error {
+	type queuedDirectory struct {
+		path      string
+		directory *remoteexecution.Directory
+	}
+	queue := []queuedDirectory{{directory: rootDirectory}}
+	info.DirectoriesVisited = 1
+	addResult := func(entry recursiveListingEntry) bool {
+		if len(info.Results) >= s.maximumSearchResults {
+			info.ResultLimitReached = true
+			return false
+		}
+		info.Results = append(info.Results, searchResult{recursiveListingEntry: entry})
+		return true
+	}
+	for len(queue) > 0 {
+		current := queue[0]
+		queue = queue[1:]
+		for _, directoryNode := range current.directory.Directories {
+			childPath := path.Join(current.path, directoryNode.Name)
+			childDigest, err := digestFunction.NewDigestFromProto(directoryNode.Digest)
+			if err != nil {
+				return util.StatusWrapf(err, "Failed to parse digest of directory %#v", childPath)
+			}
+			if match(childPath) && !addResult(recursiveListingEntry{
+				Path:        childPath,
+				IsDirectory: true,
+				Digest:      childDigest,
+			}) {
+				return nil
+			}
+			if info.DirectoriesVisited >= int64(s.maximumSearchDirectories) {
+				info.DirectoryLimitReached = true
+				continue
+			}
+			childDirectory, err := getDirectory(ctx, childDigest)
+			if err != nil {
+				return util.StatusWrapf(err, "Failed to obtain directory %#v", childPath)
+			}
+			info.DirectoriesVisited++
+			queue = append(queue, queuedDirectory{
+				path:      childPath,
+				directory: childDirectory,
+			})
+		}
+		for _, fileNode := range current.directory.Files {
+			filePath := path.Join(current.path, fileNode.Name)
+			if !match(filePath) {
+				continue
+			}
+			fileDigest, err := digestFunction.NewDigestFromProto(fileNode.Digest)
+			if err != nil {
+				return util.StatusWrapf(err, "Failed to parse digest of file %#v", filePath)
+			}
+			if !addResult(recursiveListingEntry{
+				Path:         filePath,
+				Digest:       fileDigest,
+				IsExecutable: fileNode.IsExecutable,
+			}) {
+				return nil
+			}
+		}
+		for _, symlinkNode := range current.directory.Symlinks {
+			symlinkPath := path.Join(current.path, symlinkNode.Name)
+			if match(symlinkPath) && !addResult(recursiveListingEntry{
+				Path:      symlinkPath,
+				IsSymlink: true,
+				Digest:    digest.BadDigest,
+				Target:    symlinkNode.Target,
+			}) {
+				return nil
+			}
+		}
+	}
+	return nil
+}
+
+// generateSearch searches a directory hierarchy for entries whose
+// paths match the pattern provided through the "q" query parameter,
+// and writes the results as the HTTP response. When no pattern is
+// provided, only a search form is displayed.
+//
+// Files are linked to by digest. Directories are linked to using the
+// provided function, as directories contained in Tree messages can
+// only be accessed through the page of the Tree.
+func (s *BrowserService) generateSearch(ctx context.Context, w http.ResponseWriter, req *http.Request, directoryDigest digest.Digest, directory *remoteexecution.Directory, getDirectory directoryGetter, rootDirectory string, getDirectoryURL func(searchResult *searchResult) string) {
+	parameters := req.URL.Query()
+	info := searchInfo{
+		Digest:  directoryDigest,
+		Pattern: parameters.Get("q"),
+		Syntax:  parameters.Get("syntax"),
+	}
+	if info.Syntax == "" {
+		info.Syntax = "glob"
+	}
+	if info.Pattern != "" {
+		match, err := newSearchMatcher(info.Pattern, info.Syntax)
+		if err != nil {
+			s.renderError(w, req, err)
+			return
+		}
+		if err := s.searchDirectory(ctx, &info, directoryDigest.GetDigestFunction(), directory, getDirectory, match); err != nil {
+			s.renderError(w, req, err)
+			return
+		}
+		for i := range info.Results {
+			result := &info.Results[i]
+			if result.IsDirectory {
+				result.URL = getDirectoryURL(result)
+			} else if !result.IsSymlink {
+				result.URL = fmt.Sprintf("%sfile/%s-%d/%s", rootDirectory, result.Digest.GetHashString(), result.Digest.GetSizeBytes(), path.Base(result.Path))
+			}
+		}
+	}
+
+	if parameters.Get("format") == "search_json" {
+		s.renderJSON(w, req, &query.Response{
+			Page: &query.Response_Search{Search: info.toProto()},
+		})
+	} else if err := s.templates.ExecuteTemplate(w, "page_search.html", &info); err != nil {
+		log.Print(err)
+	}
+}
@@ -0,0 +1,113 @@
+package main
+
+import (
+	"context"
+	"io"
+
+	remoteexecution "github.com/bazelbuild/remote-apis/build/bazel/remote/execution/v2"
+	"github.com/buildbarn/bb-storage/pkg/digest"
+	"github.com/buildbarn/bb-storage/pkg/filesystem/path"
+	"github.com/buildbarn/bb-storage/pkg/util"
+
+	"google.golang.org/grpc/codes"
+	"google.golang.org/grpc/status"
+	"google.golang.org/protobuf/proto"
+)
+
+// treeIndexChildSizeBytes is an estimate of the amount of memory used
+// by a single child directory stored in a treeIndex.
+const treeIndexChildSizeBytes = 128
+
+// treeIndexChild contains the location of a child directory within
+// the encoding of a Tree message. Small Trees have the encoding of the
+// child directory stored as well, so that it does not need to be read
+// from the Content Addressable Storage.
+type treeIndexChild struct {
+	offsetBytes      int64
+	sizeBytes        int
+	encodedDirectory []byte
+}
+
+// treeIndex provides lookups of the child directories contained in a
+// Tree, either by digest or by path. Computing the digests of all child
+// directories requires hashing them, which is why indexes of large Tree
+// messages are cached by messageCache. Indexes are created by
+// readTreeIndex().
+//
+// Apart from the root directory, the index only contains the contents
+// of child directories up to a limited total size. Of any other child
+// directories, only their offsets within the Tree are stored, so that
+// they can be read from the Content Addressable Storage when needed.
+//
+// Lookups by path are performed by resolving one pathname component at
+// a time, starting at the root directory. The index does not contain
+// the paths of child directories, as the same directory may be
+// present at any number of paths within the Tree. Storing them would
+// cause the size of the index to be proportional to that of the Tree.
+type treeIndex struct {
+	messageCache *messageCache
+	treeDigest   digest.Digest
+	root         *remoteexecution.Directory
+	children     map[digest.Digest]treeIndexChild
+	// The total size of the encoded child directories stored in
+	// the index.
+	encodedSizeBytes int64
+}
+
+// getSizeBytes returns an estimate of the amount of memory used by the
+// index.
+func (ti *treeIndex) getSizeBytes() int64 {
+	return int64(proto.Size(ti.root)) + int64(len(ti.children))*treeIndexChildSizeBytes + ti.encodedSizeBytes
+}
+
+// getDirectory returns a child directory contained in the Tree. It is
+// a directoryGetter, meaning that it can be used to generate archives
+// and diffs of the Tree.
+func (ti *treeIndex) getDirectory(ctx context.Context, directoryDigest digest.Digest) (*remoteexecution.Directory, error) {
+	child, ok := ti.children[directoryDigest]
+	if !ok {
+		return nil, status.Error(codes.InvalidArgument, "Failed to find child node in tree")
+	}
+	directoryMessage, err := ti.messageCache.getOrDecodeWithKey(ctx, messageCacheKey{
+		digest:      directoryDigest,
+		messageType: directoryMessageType,
+		treeDigest:  ti.treeDigest,
+	}, func() (proto.Message, error) {
+		data := child.encodedDirectory
+		if data == nil {
+			data = make([]byte, child.sizeBytes)
+			if n, err := ti.messageCache.contentAddressableStorage.Get(ctx, ti.treeDigest).ReadAt(data, child.offsetBytes); err != nil && (err != io.EOF || n != len(data)) {
+				return nil, util.StatusWrapf(err, "Failed to read child directory %#v from tree", directoryDigest.String())
+			}
+		}
+		var directory remoteexecution.Directory
+		if err := proto.Unmarshal(data, &directory); err != nil {
+			return nil, util.StatusWrapfWithCode(err, codes.InvalidArgument, "Failed to unmarshal child directory %#v", directoryDigest.String())
+		}
+		return &directory, nil
+	})
+	if err != nil {
+		return nil, err
+	}
+	return directoryMessage.(*remoteexecution.Directory), nil
+}
+
+// getChildDirectory returns the digest and contents of a subdirectory
+// of a directory contained in the Tree. Calling this function
+// repeatedly permits resolving paths within the Tree.
+func (ti *treeIndex) getChildDirectory(ctx context.Context, parent *remoteexecution.Directory, name path.Component) (digest.Digest, *remoteexecution.Directory, error) {
+	for _, directoryNode := range parent.Directories {
+		if name.String() == directoryNode.Name {
+			childDigest, err := ti.treeDigest.GetDigestFunction().NewDigestFromProto(directoryNode.Digest)
+			if err != nil {
+				return digest.BadDigest, nil, err
+			}
+			childDirectory, err := ti.getDirectory(ctx, childDigest)
+			if err != nil {
+				return digest.BadDigest, nil, err
+			}
+			return childDigest, childDirectory, nil
+		}
+	}
+	return digest.BadDigest, nil, status.Error(codes.NotFound, "Subdirectory in tree not found")
+}
@@ -40,6 +40,17 @@ type ApplicationConfiguration struct {
 	Authorizer                             *auth.AuthorizerConfiguration      `protobuf:"bytes,8,opt,name=authorizer,proto3" json:"authorizer,omitempty"`
 	RequestMetadataLinksJmespathExpression *jmespath.Expression               `protobuf:"bytes,11,opt,name=request_metadata_links_jmespath_expression,json=requestMetadataLinksJmespathExpression,proto3" json:"request_metadata_links_jmespath_expression,omitempty"`
 	ZstdPool                               *zstd.PoolConfiguration            `protobuf:"bytes,12,opt,name=zstd_pool,json=zstdPool,proto3" json:"zstd_pool,omitempty"`
+	MaximumFileDiffSizeBytes               int64                              `protobuf:"varint,13,opt,name=maximum_file_diff_size_bytes,json=maximumFileDiffSizeBytes,proto3" json:"maximum_file_diff_size_bytes,omitempty"`
+	ArchivePrefetchParallelism             int32                              `protobuf:"varint,14,opt,name=archive_prefetch_parallelism,json=archivePrefetchParallelism,proto3" json:"archive_prefetch_parallelism,omitempty"`
+	ArchivePrefetchMemoryBudgetBytes       int64                              `protobuf:"varint,15,opt,name=archive_prefetch_memory_budget_bytes,json=archivePrefetchMemoryBudgetBytes,proto3" json:"archive_prefetch_memory_budget_bytes,omitempty"`
+	MessageCacheSizeBytes                  int64                              `protobuf:"varint,16,opt,name=message_cache_size_bytes,json=messageCacheSizeBytes,proto3" json:"message_cache_size_bytes,omitempty"`
+	TreeIndexCacheSizeBytes                int64                              `protobuf:"varint,17,opt,name=tree_index_cache_size_bytes,json=treeIndexCacheSizeBytes,proto3" json:"tree_index_cache_size_bytes,omitempty"`
+	MaximumStreamedMessageSizeBytes        int64                              `protobuf:"varint,18,opt,name=maximum_streamed_message_size_bytes,json=maximumStreamedMessageSizeBytes,proto3" json:"maximum_streamed_message_size_bytes,omitempty"`
+	MaximumSearchDirectories               int32                              `protobuf:"varint,19,opt,name=maximum_search_directories,json=maximumSearchDirectories,proto3" json:"maximum_search_directories,omitempty"`
+	MaximumSearchResults                   int32                              `protobuf:"varint,20,opt,name=maximum_search_results,json=maximumSearchResults,proto3" json:"maximum_search_results,omitempty"`
+	MaximumGrepSizeBytes                   int64                              `protobuf:"varint,21,opt,name=maximum_grep_size_bytes,json=maximumGrepSizeBytes,proto3" json:"maximum_grep_size_bytes,omitempty"`
+	GrepParallelism                        int32                              `protobuf:"varint,22,opt,name=grep_parallelism,json=grepParallelism,proto3" json:"grep_parallelism,omitempty"`
+	MaximumInlineLogSizeBytes              int64                              `protobuf:"varint,23,opt,name=maximum_inline_log_size_bytes,json=maximumInlineLogSizeBytes,proto3" json:"maximum_inline_log_size_bytes,omitempty"`
 	unknownFields                          protoimpl.UnknownFields
 	sizeCache                              protoimpl.SizeCache
 }
@@ -151,11 +162,88 @@ func (x *ApplicationConfiguration) GetZstdPool() *zstd.PoolConfiguration {
 	return nil
 }
 
+func (x *ApplicationConfiguration) GetMaximumFileDiffSizeBytes() int64 {
+	if x != nil {
+		return x.MaximumFileDiffSizeBytes
+	}
+	return 0
+}
+
+func (x *ApplicationConfiguration) GetArchivePrefetchParallelism() int32 {
+	if x != nil {
+		return x.ArchivePrefetchParallelism
+	}
+	return 0
+}
+
+func (x *ApplicationConfiguration) GetArchivePrefetchMemoryBudgetBytes() int64 {
+	if x != nil {
+		return x.ArchivePrefetchMemoryBudgetBytes
+	}
+	return 0
+}
+
+func (x *ApplicationConfiguration) GetMessageCacheSizeBytes() int64 {
+	if x != nil {
+		return x.MessageCacheSizeBytes
+	}
+	return 0
+}
+
+func (x *ApplicationConfiguration) GetTreeIndexCacheSizeBytes() int64 {
+	if x != nil {
+		return x.TreeIndexCacheSizeBytes
+	}
+	return 0
+}
+
+func (x *ApplicationConfiguration) GetMaximumStreamedMessageSizeBytes() int64 {
+	if x != nil {
+		return x.MaximumStreamedMessageSizeBytes
+	}
+	return 0
+}
+
+func (x *ApplicationConfiguration) GetMaximumSearchDirectories() int32 {
+	if x != nil {
+		return x.MaximumSearchDirectories
+	}
+	return 0
+}
+
+func (x *ApplicationConfiguration) GetMaximumSearchResults() int32 {
+	if x != nil {
+		return x.MaximumSearchResults
+	}
+	return 0
+}
+
+func (x *ApplicationConfiguration) GetMaximumGrepSizeBytes() int64 {
+	if x != nil {
+		return x.MaximumGrepSizeBytes
+	}
+	return 0
+}
+
+func (x *ApplicationConfiguration) GetGrepParallelism() int32 {
+	if x != nil {
+		return x.GrepParallelism
+	}
+	return 0
+}
+
+func (x *ApplicationConfiguration) GetMaximumInlineLogSizeBytes() int64 {
+	if x != nil {
+		return x.MaximumInlineLogSizeBytes
+	}
+	return 0
+}
+
 var File_github_com_buildbarn_bb_browser_pkg_proto_configuration_bb_browser_bb_browser_proto protoreflect.FileDescriptor
 
 const file_github_com_buildbarn_bb_browser_pkg_proto_configuration_bb_browser_bb_browser_proto_rawDesc = "" +
 	"\n" +
-	"Sgithub.com/buildbarn/bb-browser/pkg/proto/configuration/bb_browser/bb_browser.proto\x12\"buildbarn.configuration.bb_browser\x1aGgithub.com/buildbarn/bb-storage/pkg/proto/configuration/auth/auth.proto\x1aQgithub.com/buildbarn/bb-storage/pkg/proto/configuration/blobstore/blobstore.proto\x1aKgithub.com/buildbarn/bb-storage/pkg/proto/configuration/global/global.proto\x1aPgithub.com/buildbarn/bb-storage/pkg/proto/configuration/http/server/server.proto\x1aOgithub.com/buildbarn/bb-storage/pkg/proto/configuration/jmespath/jmespath.proto\x1aGgithub.com/buildbarn/bb-storage/pkg/proto/configuration/zstd/zstd.proto\"\xd7\a\n" +
+	"Sgithub.com/buildbarn/bb-browser/pkg/proto/configuration/bb_browser/bb_browser.proto\x12\"buildbarn.configuration.bb_browser\x1aGgithub.com/buildbarn/bb-storage/pkg/proto/configuration/auth/auth.proto\x1aQgithub.com/buildbarn/bb-storage/pkg/proto/configuration/blobstore/blobstore.proto\x1aKgithub.com/buildbarn/bb-storage/pkg/proto/configuration/global/global.proto\x1aPgithub.com/buildbarn/bb-storage/pkg/proto/configuration/http/server/server.proto\x1aOgithub.com/buildbarn/bb-storage/pkg/proto/configuration/jmespath/jmespath.proto\x1aGgithub.com/buildbarn/bb-storage/pkg/proto/configuration/zstd/zstd.proto\"\x86\r\n" +
 	"\x18ApplicationConfiguration\x12W\n" +
 	"\tblobstore\x18\x01 \x01(\v29.buildbarn.configuration.blobstore.BlobstoreConfigurationR\tblobstore\x12;\n" +
 	"\x1amaximum_message_size_bytes\x18\x02 \x01(\x03R\x17maximumMessageSizeBytes\x12U\n" +
@@ -170,7 +258,18 @@ const file_github_com_buildbarn_bb_browser_pkg_proto_configuration_bb_browser_bb
 	"authorizer\x18\b \x01(\v25.buildbarn.configuration.auth.AuthorizerConfigurationR\n" +
 	"authorizer\x12\x88\x01\n" +
 	"*request_metadata_links_jmespath_expression\x18\v \x01(\v2,.buildbarn.configuration.jmespath.ExpressionR&requestMetadataLinksJmespathExpression\x12L\n" +
-	"\tzstd_pool\x18\f \x01(\v2/.buildbarn.configuration.zstd.PoolConfigurationR\bzstdPoolJ\x04\b\x03\x10\x04BDZBgithub.com/buildbarn/bb-browser/pkg/proto/configuration/bb_browserb\x06proto3"
+	"\tzstd_pool\x18\f \x01(\v2/.buildbarn.configuration.zstd.PoolConfigurationR\bzstdPool\x12>\n" +
+	"\x1cmaximum_file_diff_size_bytes\x18\r \x01(\x03R\x18maximumFileDiffSizeBytes\x12@\n" +
+	"\x1carchive_prefetch_parallelism\x18\x0e \x01(\x05R\x1aarchivePrefetchParallelism\x12N\n" +
+	"$archive_prefetch_memory_budget_bytes\x18\x0f \x01(\x03R archivePrefetchMemoryBudgetBytes\x127\n" +
+	"\x18message_cache_size_bytes\x18\x10 \x01(\x03R\x15messageCacheSizeBytes\x12<\n" +
+	"\x1btree_index_cache_size_bytes\x18\x11 \x01(\x03R\x17treeIndexCacheSizeBytes\x12L\n" +
+	"#maximum_streamed_message_size_bytes\x18\x12 \x01(\x03R\x1fmaximumStreamedMessageSizeBytes\x12<\n" +
+	"\x1amaximum_search_directories\x18\x13 \x01(\x05R\x18maximumSearchDirectories\x124\n" +
+	"\x16maximum_search_results\x18\x14 \x01(\x05R\x14maximumSearchResults\x125\n" +
+	"\x17maximum_grep_size_bytes\x18\x15 \x01(\x03R\x14maximumGrepSizeBytes\x12)\n" +
+	"\x10grep_parallelism\x18\x16 \x01(\x05R\x0fgrepParallelism\x12@\n" +
+	"\x1dmaximum_inline_log_size_bytes\x18\x17 \x01(\x03R\x19maximumInlineLogSizeBytesJ\x04\b\x03\x10\x04BDZBgithub.com/buildbarn/bb-browser/pkg/proto/configuration/bb_browserb\x06proto3"
 
 var (
 	file_github_com_buildbarn_bb_browser_pkg_proto_configuration_bb_browser_bb_browser_proto_rawDescOnce sync.Once
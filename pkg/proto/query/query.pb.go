@@ -8,6 +8,8 @@ package query
 
 import (
 	v2 "github.com/bazelbuild/remote-apis/build/bazel/remote/execution/v2"
+	iscc "github.com/buildbarn/bb-storage/pkg/proto/iscc"
+	status "google.golang.org/genproto/googleapis/rpc/status"
 	protoreflect "google.golang.org/protobuf/reflect/protoreflect"
 	protoimpl "google.golang.org/protobuf/runtime/protoimpl"
 	reflect "reflect"
@@ -22,6 +24,58 @@ const (
 	_ = protoimpl.EnforceVersion(protoimpl.MaxVersion - 20)
 )
 
+type RecursiveListingInfo_Entry_Type int32
+
+const (
+	RecursiveListingInfo_Entry_UNKNOWN   RecursiveListingInfo_Entry_Type = 0
+	RecursiveListingInfo_Entry_DIRECTORY RecursiveListingInfo_Entry_Type = 1
+	RecursiveListingInfo_Entry_FILE      RecursiveListingInfo_Entry_Type = 2
+	RecursiveListingInfo_Entry_SYMLINK   RecursiveListingInfo_Entry_Type = 3
+)
+
+// Enum value maps for RecursiveListingInfo_Entry_Type.
+var (
+	RecursiveListingInfo_Entry_Type_name = map[int32]string{
+		0: "UNKNOWN",
+		1: "DIRECTORY",
+		2: "FILE",
+		3: "SYMLINK",
+	}
+	RecursiveListingInfo_Entry_Type_value = map[string]int32{
+		"UNKNOWN":   0,
+		"DIRECTORY": 1,
+		"FILE":      2,
+		"SYMLINK":   3,
+	}
+)
+
+func (x RecursiveListingInfo_Entry_Type) Enum() *RecursiveListingInfo_Entry_Type {
+	p := new(RecursiveListingInfo_Entry_Type)
+	*p = x
+	return p
+}
+
+func (x RecursiveListingInfo_Entry_Type) String() string {
+	return protoimpl.X.EnumStringOf(x.Descriptor(), protoreflect.EnumNumber(x))
+}
+
+func (RecursiveListingInfo_Entry_Type) Descriptor() protoreflect.EnumDescriptor {
+	return file_github_com_buildbarn_bb_browser_pkg_proto_query_query_proto_enumTypes[0].Descriptor()
+}
+
+func (RecursiveListingInfo_Entry_Type) Type() protoreflect.EnumType {
+	return &file_github_com_buildbarn_bb_browser_pkg_proto_query_query_proto_enumTypes[0]
+}
+
+func (x RecursiveListingInfo_Entry_Type) Number() protoreflect.EnumNumber {
+	return protoreflect.EnumNumber(x)
+}
+
+// Deprecated: Use RecursiveListingInfo_Entry_Type.Descriptor instead.
+func (RecursiveListingInfo_Entry_Type) EnumDescriptor() ([]byte, []int) {
+	return file_github_com_buildbarn_bb_browser_pkg_proto_query_query_proto_rawDescGZIP(), []int{7, 0, 0}
+}
+
 type FileSystemAccessProfileReference struct {
 	state              protoimpl.MessageState `protogen:"open.v1"`
 	Digest             *v2.Digest             `protobuf:"bytes,1,opt,name=digest,proto3" json:"digest,omitempty"`
@@ -30,21 +84,1102 @@ type FileSystemAccessProfileReference struct {
 	sizeCache          protoimpl.SizeCache
 }
 
-func (x *FileSystemAccessProfileReference) Reset() {
-	*x = FileSystemAccessProfileReference{}
-	mi := &file_github_com_buildbarn_bb_browser_pkg_proto_query_query_proto_msgTypes[0]
+func (x *FileSystemAccessProfileReference) Reset() {
+	*x = FileSystemAccessProfileReference{}
+	mi := &file_github_com_buildbarn_bb_browser_pkg_proto_query_query_proto_msgTypes[0]
+	ms := protoimpl.X.MessageStateOf(protoimpl.Pointer(x))
+	ms.StoreMessageInfo(mi)
+}
+
+func (x *FileSystemAccessProfileReference) String() string {
+	return protoimpl.X.MessageStringOf(x)
+}
+
+func (*FileSystemAccessProfileReference) ProtoMessage() {}
+
+func (x *FileSystemAccessProfileReference) ProtoReflect() protoreflect.Message {
+	mi := &file_github_com_buildbarn_bb_browser_pkg_proto_query_query_proto_msgTypes[0]
+	if x != nil {
+		ms := protoimpl.X.MessageStateOf(protoimpl.Pointer(x))
+		if ms.LoadMessageInfo() == nil {
+			ms.StoreMessageInfo(mi)
+		}
+		return ms
+	}
+	return mi.MessageOf(x)
+}
+
+// Deprecated: Use FileSystemAccessProfileReference.ProtoReflect.Descriptor instead.
+func (*FileSystemAccessProfileReference) Descriptor() ([]byte, []int) {
+	return file_github_com_buildbarn_bb_browser_pkg_proto_query_query_proto_rawDescGZIP(), []int{0}
+}
+
+func (x *FileSystemAccessProfileReference) GetDigest() *v2.Digest {
+	if x != nil {
+		return x.Digest
+	}
+	return nil
+}
+
+func (x *FileSystemAccessProfileReference) GetPathHashesBaseHash() uint64 {
+	if x != nil {
+		return x.PathHashesBaseHash
+	}
+	return 0
+}
+
+type Response struct {
+	state protoimpl.MessageState `protogen:"open.v1"`
+	// Types that are valid to be assigned to Page:
+	//
+	//	*Response_Action
+	//	*Response_Command
+	//	*Response_Directory
+	//	*Response_Tree
+	//	*Response_PreviousExecutionStats
+	//	*Response_ActionInput
+	//	*Response_ActionOutput
+	//	*Response_RecursiveListing
+	//	*Response_Search
+	//	*Response_Grep
+	//	*Response_Log
+	Page          isResponse_Page `protobuf_oneof:"page"`
+	unknownFields protoimpl.UnknownFields
+	sizeCache     protoimpl.SizeCache
+}
+
+func (x *Response) Reset() {
+	*x = Response{}
+	mi := &file_github_com_buildbarn_bb_browser_pkg_proto_query_query_proto_msgTypes[1]
+	ms := protoimpl.X.MessageStateOf(protoimpl.Pointer(x))
+	ms.StoreMessageInfo(mi)
+}
+
+func (x *Response) String() string {
+	return protoimpl.X.MessageStringOf(x)
+}
+
+func (*Response) ProtoMessage() {}
+
+func (x *Response) ProtoReflect() protoreflect.Message {
+	mi := &file_github_com_buildbarn_bb_browser_pkg_proto_query_query_proto_msgTypes[1]
+	if x != nil {
+		ms := protoimpl.X.MessageStateOf(protoimpl.Pointer(x))
+		if ms.LoadMessageInfo() == nil {
+			ms.StoreMessageInfo(mi)
+		}
+		return ms
+	}
+	return mi.MessageOf(x)
+}
+
+// Deprecated: Use Response.ProtoReflect.Descriptor instead.
+func (*Response) Descriptor() ([]byte, []int) {
+	return file_github_com_buildbarn_bb_browser_pkg_proto_query_query_proto_rawDescGZIP(), []int{1}
+}
+
+func (x *Response) GetPage() isResponse_Page {
+	if x != nil {
+		return x.Page
+	}
+	return nil
+}
+
+func (x *Response) GetAction() *ActionInfo {
+	if x != nil {
+		if x, ok := x.Page.(*Response_Action); ok {
+			return x.Action
+		}
+	}
+	return nil
+}
+
+func (x *Response) GetCommand() *CommandInfo {
+	if x != nil {
+		if x, ok := x.Page.(*Response_Command); ok {
+			return x.Command
+		}
+	}
+	return nil
+}
+
+func (x *Response) GetDirectory() *DirectoryInfo {
+	if x != nil {
+		if x, ok := x.Page.(*Response_Directory); ok {
+			return x.Directory
+		}
+	}
+	return nil
+}
+
+func (x *Response) GetTree() *TreeInfo {
+	if x != nil {
+		if x, ok := x.Page.(*Response_Tree); ok {
+			return x.Tree
+		}
+	}
+	return nil
+}
+
+func (x *Response) GetPreviousExecutionStats() *PreviousExecutionStatsInfo {
+	if x != nil {
+		if x, ok := x.Page.(*Response_PreviousExecutionStats); ok {
+			return x.PreviousExecutionStats
+		}
+	}
+	return nil
+}
+
+func (x *Response) GetActionInput() *ActionPathInfo {
+	if x != nil {
+		if x, ok := x.Page.(*Response_ActionInput); ok {
+			return x.ActionInput
+		}
+	}
+	return nil
+}
+
+func (x *Response) GetActionOutput() *ActionPathInfo {
+	if x != nil {
+		if x, ok := x.Page.(*Response_ActionOutput); ok {
+			return x.ActionOutput
+		}
+	}
+	return nil
+}
+
+func (x *Response) GetRecursiveListing() *RecursiveListingInfo {
+	if x != nil {
+		if x, ok := x.Page.(*Response_RecursiveListing); ok {
+			return x.RecursiveListing
+		}
+	}
+	return nil
+}
+
+func (x *Response) GetSearch() *SearchInfo {
+	if x != nil {
+		if x, ok := x.Page.(*Response_Search); ok {
+			return x.Search
+		}
+	}
+	return nil
+}
+
+func (x *Response) GetGrep() *GrepInfo {
+	if x != nil {
+		if x, ok := x.Page.(*Response_Grep); ok {
+			return x.Grep
+		}
+	}
+	return nil
+}
+
+func (x *Response) GetLog() *LogPageInfo {
+	if x != nil {
+		if x, ok := x.Page.(*Response_Log); ok {
+			return x.Log
+		}
+	}
+	return nil
+}
+
+type isResponse_Page interface {
+	isResponse_Page()
+}
+
+type Response_Action struct {
+	Action *ActionInfo `protobuf:"bytes,1,opt,name=action,proto3,oneof"`
+}
+
+type Response_Command struct {
+	Command *CommandInfo `protobuf:"bytes,2,opt,name=command,proto3,oneof"`
+}
+
+type Response_Directory struct {
+	Directory *DirectoryInfo `protobuf:"bytes,3,opt,name=directory,proto3,oneof"`
+}
+
+type Response_Tree struct {
+	Tree *TreeInfo `protobuf:"bytes,4,opt,name=tree,proto3,oneof"`
+}
+
+type Response_PreviousExecutionStats struct {
+	PreviousExecutionStats *PreviousExecutionStatsInfo `protobuf:"bytes,5,opt,name=previous_execution_stats,json=previousExecutionStats,proto3,oneof"`
+}
+
+type Response_ActionInput struct {
+	ActionInput *ActionPathInfo `protobuf:"bytes,6,opt,name=action_input,json=actionInput,proto3,oneof"`
+}
+
+type Response_ActionOutput struct {
+	ActionOutput *ActionPathInfo `protobuf:"bytes,7,opt,name=action_output,json=actionOutput,proto3,oneof"`
+}
+
+type Response_RecursiveListing struct {
+	RecursiveListing *RecursiveListingInfo `protobuf:"bytes,8,opt,name=recursive_listing,json=recursiveListing,proto3,oneof"`
+}
+
+type Response_Search struct {
+	Search *SearchInfo `protobuf:"bytes,9,opt,name=search,proto3,oneof"`
+}
+
+type Response_Grep struct {
+	Grep *GrepInfo `protobuf:"bytes,10,opt,name=grep,proto3,oneof"`
+}
+
+type Response_Log struct {
+	Log *LogPageInfo `protobuf:"bytes,11,opt,name=log,proto3,oneof"`
+}
+
+func (*Response_Action) isResponse_Page() {}
+
+func (*Response_Command) isResponse_Page() {}
+
+func (*Response_Directory) isResponse_Page() {}
+
+func (*Response_Tree) isResponse_Page() {}
+
+func (*Response_PreviousExecutionStats) isResponse_Page() {}
+
+func (*Response_ActionInput) isResponse_Page() {}
+
+func (*Response_ActionOutput) isResponse_Page() {}
+
+func (*Response_RecursiveListing) isResponse_Page() {}
+
+func (*Response_Search) isResponse_Page() {}
+
+func (*Response_Grep) isResponse_Page() {}
+
+func (*Response_Log) isResponse_Page() {}
+
+type ActionInfo struct {
+	state                       protoimpl.MessageState      `protogen:"open.v1"`
+	ActionDigest                *v2.Digest                  `protobuf:"bytes,1,opt,name=action_digest,json=actionDigest,proto3" json:"action_digest,omitempty"`
+	IsHistoricalExecuteResponse bool                        `protobuf:"varint,2,opt,name=is_historical_execute_response,json=isHistoricalExecuteResponse,proto3" json:"is_historical_execute_response,omitempty"`
+	Action                      *v2.Action                  `protobuf:"bytes,3,opt,name=action,proto3" json:"action,omitempty"`
+	Command                     *CommandInfo                `protobuf:"bytes,4,opt,name=command,proto3" json:"command,omitempty"`
+	ExecuteResponse             *v2.ExecuteResponse         `protobuf:"bytes,5,opt,name=execute_response,json=executeResponse,proto3" json:"execute_response,omitempty"`
+	Stdout                      *LogInfo                    `protobuf:"bytes,6,opt,name=stdout,proto3" json:"stdout,omitempty"`
+	Stderr                      *LogInfo                    `protobuf:"bytes,7,opt,name=stderr,proto3" json:"stderr,omitempty"`
+	InputRoot                   *DirectoryInfo              `protobuf:"bytes,8,opt,name=input_root,json=inputRoot,proto3" json:"input_root,omitempty"`
+	MissingPaths                []string                    `protobuf:"bytes,9,rep,name=missing_paths,json=missingPaths,proto3" json:"missing_paths,omitempty"`
+	PreviousExecutionStats      *PreviousExecutionStatsInfo `protobuf:"bytes,10,opt,name=previous_execution_stats,json=previousExecutionStats,proto3" json:"previous_execution_stats,omitempty"`
+	Errors                      map[string]*status.Status   `protobuf:"bytes,11,rep,name=errors,proto3" json:"errors,omitempty" protobuf_key:"bytes,1,opt,name=key" protobuf_val:"bytes,2,opt,name=value"`
+	unknownFields               protoimpl.UnknownFields
+	sizeCache                   protoimpl.SizeCache
+}
+
+func (x *ActionInfo) Reset() {
+	*x = ActionInfo{}
+	mi := &file_github_com_buildbarn_bb_browser_pkg_proto_query_query_proto_msgTypes[2]
+	ms := protoimpl.X.MessageStateOf(protoimpl.Pointer(x))
+	ms.StoreMessageInfo(mi)
+}
+
+func (x *ActionInfo) String() string {
+	return protoimpl.X.MessageStringOf(x)
+}
+
+func (*ActionInfo) ProtoMessage() {}
+
+func (x *ActionInfo) ProtoReflect() protoreflect.Message {
+	mi := &file_github_com_buildbarn_bb_browser_pkg_proto_query_query_proto_msgTypes[2]
+	if x != nil {
+		ms := protoimpl.X.MessageStateOf(protoimpl.Pointer(x))
+		if ms.LoadMessageInfo() == nil {
+			ms.StoreMessageInfo(mi)
+		}
+		return ms
+	}
+	return mi.MessageOf(x)
+}
+
+// Deprecated: Use ActionInfo.ProtoReflect.Descriptor instead.
+func (*ActionInfo) Descriptor() ([]byte, []int) {
+	return file_github_com_buildbarn_bb_browser_pkg_proto_query_query_proto_rawDescGZIP(), []int{2}
+}
+
+func (x *ActionInfo) GetActionDigest() *v2.Digest {
+	if x != nil {
+		return x.ActionDigest
+	}
+	return nil
+}
+
+func (x *ActionInfo) GetIsHistoricalExecuteResponse() bool {
+	if x != nil {
+		return x.IsHistoricalExecuteResponse
+	}
+	return false
+}
+
+func (x *ActionInfo) GetAction() *v2.Action {
+	if x != nil {
+		return x.Action
+	}
+	return nil
+}
+
+func (x *ActionInfo) GetCommand() *CommandInfo {
+	if x != nil {
+		return x.Command
+	}
+	return nil
+}
+
+func (x *ActionInfo) GetExecuteResponse() *v2.ExecuteResponse {
+	if x != nil {
+		return x.ExecuteResponse
+	}
+	return nil
+}
+
+func (x *ActionInfo) GetStdout() *LogInfo {
+	if x != nil {
+		return x.Stdout
+	}
+	return nil
+}
+
+func (x *ActionInfo) GetStderr() *LogInfo {
+	if x != nil {
+		return x.Stderr
+	}
+	return nil
+}
+
+func (x *ActionInfo) GetInputRoot() *DirectoryInfo {
+	if x != nil {
+		return x.InputRoot
+	}
+	return nil
+}
+
+func (x *ActionInfo) GetMissingPaths() []string {
+	if x != nil {
+		return x.MissingPaths
+	}
+	return nil
+}
+
+func (x *ActionInfo) GetPreviousExecutionStats() *PreviousExecutionStatsInfo {
+	if x != nil {
+		return x.PreviousExecutionStats
+	}
+	return nil
+}
+
+func (x *ActionInfo) GetErrors() map[string]*status.Status {
+	if x != nil {
+		return x.Errors
+	}
+	return nil
+}
+
+type CommandInfo struct {
+	state         protoimpl.MessageState `protogen:"open.v1"`
+	Digest        *v2.Digest             `protobuf:"bytes,1,opt,name=digest,proto3" json:"digest,omitempty"`
+	Command       *v2.Command            `protobuf:"bytes,2,opt,name=command,proto3" json:"command,omitempty"`
+	BbClientdPath string                 `protobuf:"bytes,3,opt,name=bb_clientd_path,json=bbClientdPath,proto3" json:"bb_clientd_path,omitempty"`
+	unknownFields protoimpl.UnknownFields
+	sizeCache     protoimpl.SizeCache
+}
+
+func (x *CommandInfo) Reset() {
+	*x = CommandInfo{}
+	mi := &file_github_com_buildbarn_bb_browser_pkg_proto_query_query_proto_msgTypes[3]
+	ms := protoimpl.X.MessageStateOf(protoimpl.Pointer(x))
+	ms.StoreMessageInfo(mi)
+}
+
+func (x *CommandInfo) String() string {
+	return protoimpl.X.MessageStringOf(x)
+}
+
+func (*CommandInfo) ProtoMessage() {}
+
+func (x *CommandInfo) ProtoReflect() protoreflect.Message {
+	mi := &file_github_com_buildbarn_bb_browser_pkg_proto_query_query_proto_msgTypes[3]
+	if x != nil {
+		ms := protoimpl.X.MessageStateOf(protoimpl.Pointer(x))
+		if ms.LoadMessageInfo() == nil {
+			ms.StoreMessageInfo(mi)
+		}
+		return ms
+	}
+	return mi.MessageOf(x)
+}
+
+// Deprecated: Use CommandInfo.ProtoReflect.Descriptor instead.
+func (*CommandInfo) Descriptor() ([]byte, []int) {
+	return file_github_com_buildbarn_bb_browser_pkg_proto_query_query_proto_rawDescGZIP(), []int{3}
+}
+
+func (x *CommandInfo) GetDigest() *v2.Digest {
+	if x != nil {
+		return x.Digest
+	}
+	return nil
+}
+
+func (x *CommandInfo) GetCommand() *v2.Command {
+	if x != nil {
+		return x.Command
+	}
+	return nil
+}
+
+func (x *CommandInfo) GetBbClientdPath() string {
+	if x != nil {
+		return x.BbClientdPath
+	}
+	return ""
+}
+
+type DirectoryInfo struct {
+	state                            protoimpl.MessageState            `protogen:"open.v1"`
+	Digest                           *v2.Digest                        `protobuf:"bytes,1,opt,name=digest,proto3" json:"digest,omitempty"`
+	Directory                        *v2.Directory                     `protobuf:"bytes,2,opt,name=directory,proto3" json:"directory,omitempty"`
+	BbClientdPath                    string                            `protobuf:"bytes,3,opt,name=bb_clientd_path,json=bbClientdPath,proto3" json:"bb_clientd_path,omitempty"`
+	FileSystemAccessProfileReference *FileSystemAccessProfileReference `protobuf:"bytes,4,opt,name=file_system_access_profile_reference,json=fileSystemAccessProfileReference,proto3" json:"file_system_access_profile_reference,omitempty"`
+	PrefetchedNames                  []string                          `protobuf:"bytes,5,rep,name=prefetched_names,json=prefetchedNames,proto3" json:"prefetched_names,omitempty"`
+	unknownFields                    protoimpl.UnknownFields
+	sizeCache                        protoimpl.SizeCache
+}
+
+func (x *DirectoryInfo) Reset() {
+	*x = DirectoryInfo{}
+	mi := &file_github_com_buildbarn_bb_browser_pkg_proto_query_query_proto_msgTypes[4]
+	ms := protoimpl.X.MessageStateOf(protoimpl.Pointer(x))
+	ms.StoreMessageInfo(mi)
+}
+
+func (x *DirectoryInfo) String() string {
+	return protoimpl.X.MessageStringOf(x)
+}
+
+func (*DirectoryInfo) ProtoMessage() {}
+
+func (x *DirectoryInfo) ProtoReflect() protoreflect.Message {
+	mi := &file_github_com_buildbarn_bb_browser_pkg_proto_query_query_proto_msgTypes[4]
+	if x != nil {
+		ms := protoimpl.X.MessageStateOf(protoimpl.Pointer(x))
+		if ms.LoadMessageInfo() == nil {
+			ms.StoreMessageInfo(mi)
+		}
+		return ms
+	}
+	return mi.MessageOf(x)
+}
+
+// Deprecated: Use DirectoryInfo.ProtoReflect.Descriptor instead.
+func (*DirectoryInfo) Descriptor() ([]byte, []int) {
+	return file_github_com_buildbarn_bb_browser_pkg_proto_query_query_proto_rawDescGZIP(), []int{4}
+}
+
+func (x *DirectoryInfo) GetDigest() *v2.Digest {
+	if x != nil {
+		return x.Digest
+	}
+	return nil
+}
+
+func (x *DirectoryInfo) GetDirectory() *v2.Directory {
+	if x != nil {
+		return x.Directory
+	}
+	return nil
+}
+
+func (x *DirectoryInfo) GetBbClientdPath() string {
+	if x != nil {
+		return x.BbClientdPath
+	}
+	return ""
+}
+
+func (x *DirectoryInfo) GetFileSystemAccessProfileReference() *FileSystemAccessProfileReference {
+	if x != nil {
+		return x.FileSystemAccessProfileReference
+	}
+	return nil
+}
+
+func (x *DirectoryInfo) GetPrefetchedNames() []string {
+	if x != nil {
+		return x.PrefetchedNames
+	}
+	return nil
+}
+
+type TreeInfo struct {
+	state           protoimpl.MessageState `protogen:"open.v1"`
+	TreeDigest      *v2.Digest             `protobuf:"bytes,1,opt,name=tree_digest,json=treeDigest,proto3" json:"tree_digest,omitempty"`
+	Path            string                 `protobuf:"bytes,2,opt,name=path,proto3" json:"path,omitempty"`
+	DirectoryDigest *v2.Digest             `protobuf:"bytes,3,opt,name=directory_digest,json=directoryDigest,proto3" json:"directory_digest,omitempty"`
+	Directory       *v2.Directory          `protobuf:"bytes,4,opt,name=directory,proto3" json:"directory,omitempty"`
+	BbClientdPath   string                 `protobuf:"bytes,5,opt,name=bb_clientd_path,json=bbClientdPath,proto3" json:"bb_clientd_path,omitempty"`
+	unknownFields   protoimpl.UnknownFields
+	sizeCache       protoimpl.SizeCache
+}
+
+func (x *TreeInfo) Reset() {
+	*x = TreeInfo{}
+	mi := &file_github_com_buildbarn_bb_browser_pkg_proto_query_query_proto_msgTypes[5]
+	ms := protoimpl.X.MessageStateOf(protoimpl.Pointer(x))
+	ms.StoreMessageInfo(mi)
+}
+
+func (x *TreeInfo) String() string {
+	return protoimpl.X.MessageStringOf(x)
+}
+
+func (*TreeInfo) ProtoMessage() {}
+
+func (x *TreeInfo) ProtoReflect() protoreflect.Message {
+	mi := &file_github_com_buildbarn_bb_browser_pkg_proto_query_query_proto_msgTypes[5]
+	if x != nil {
+		ms := protoimpl.X.MessageStateOf(protoimpl.Pointer(x))
+		if ms.LoadMessageInfo() == nil {
+			ms.StoreMessageInfo(mi)
+		}
+		return ms
+	}
+	return mi.MessageOf(x)
+}
+
+// Deprecated: Use TreeInfo.ProtoReflect.Descriptor instead.
+func (*TreeInfo) Descriptor() ([]byte, []int) {
+	return file_github_com_buildbarn_bb_browser_pkg_proto_query_query_proto_rawDescGZIP(), []int{5}
+}
+
+func (x *TreeInfo) GetTreeDigest() *v2.Digest {
+	if x != nil {
+		return x.TreeDigest
+	}
+	return nil
+}
+
+func (x *TreeInfo) GetPath() string {
+	if x != nil {
+		return x.Path
+	}
+	return ""
+}
+
+func (x *TreeInfo) GetDirectoryDigest() *v2.Digest {
+	if x != nil {
+		return x.DirectoryDigest
+	}
+	return nil
+}
+
+func (x *TreeInfo) GetDirectory() *v2.Directory {
+	if x != nil {
+		return x.Directory
+	}
+	return nil
+}
+
+func (x *TreeInfo) GetBbClientdPath() string {
+	if x != nil {
+		return x.BbClientdPath
+	}
+	return ""
+}
+
+type ActionPathInfo struct {
+	state           protoimpl.MessageState `protogen:"open.v1"`
+	ActionDigest    *v2.Digest             `protobuf:"bytes,1,opt,name=action_digest,json=actionDigest,proto3" json:"action_digest,omitempty"`
+	Path            string                 `protobuf:"bytes,2,opt,name=path,proto3" json:"path,omitempty"`
+	DirectoryDigest *v2.Digest             `protobuf:"bytes,3,opt,name=directory_digest,json=directoryDigest,proto3" json:"directory_digest,omitempty"`
+	Directory       *v2.Directory          `protobuf:"bytes,4,opt,name=directory,proto3" json:"directory,omitempty"`
+	File            *v2.FileNode           `protobuf:"bytes,5,opt,name=file,proto3" json:"file,omitempty"`
+	Symlink         *v2.SymlinkNode        `protobuf:"bytes,6,opt,name=symlink,proto3" json:"symlink,omitempty"`
+	unknownFields   protoimpl.UnknownFields
+	sizeCache       protoimpl.SizeCache
+}
+
+func (x *ActionPathInfo) Reset() {
+	*x = ActionPathInfo{}
+	mi := &file_github_com_buildbarn_bb_browser_pkg_proto_query_query_proto_msgTypes[6]
+	ms := protoimpl.X.MessageStateOf(protoimpl.Pointer(x))
+	ms.StoreMessageInfo(mi)
+}
+
+func (x *ActionPathInfo) String() string {
+	return protoimpl.X.MessageStringOf(x)
+}
+
+func (*ActionPathInfo) ProtoMessage() {}
+
+func (x *ActionPathInfo) ProtoReflect() protoreflect.Message {
+	mi := &file_github_com_buildbarn_bb_browser_pkg_proto_query_query_proto_msgTypes[6]
+	if x != nil {
+		ms := protoimpl.X.MessageStateOf(protoimpl.Pointer(x))
+		if ms.LoadMessageInfo() == nil {
+			ms.StoreMessageInfo(mi)
+		}
+		return ms
+	}
+	return mi.MessageOf(x)
+}
+
+// Deprecated: Use ActionPathInfo.ProtoReflect.Descriptor instead.
+func (*ActionPathInfo) Descriptor() ([]byte, []int) {
+	return file_github_com_buildbarn_bb_browser_pkg_proto_query_query_proto_rawDescGZIP(), []int{6}
+}
+
+func (x *ActionPathInfo) GetActionDigest() *v2.Digest {
+	if x != nil {
+		return x.ActionDigest
+	}
+	return nil
+}
+
+func (x *ActionPathInfo) GetPath() string {
+	if x != nil {
+		return x.Path
+	}
+	return ""
+}
+
+func (x *ActionPathInfo) GetDirectoryDigest() *v2.Digest {
+	if x != nil {
+		return x.DirectoryDigest
+	}
+	return nil
+}
+
+func (x *ActionPathInfo) GetDirectory() *v2.Directory {
+	if x != nil {
+		return x.Directory
+	}
+	return nil
+}
+
+func (x *ActionPathInfo) GetFile() *v2.FileNode {
+	if x != nil {
+		return x.File
+	}
+	return nil
+}
+
+func (x *ActionPathInfo) GetSymlink() *v2.SymlinkNode {
+	if x != nil {
+		return x.Symlink
+	}
+	return nil
+}
+
+type RecursiveListingInfo struct {
+	state             protoimpl.MessageState        `protogen:"open.v1"`
+	Digest            *v2.Digest                    `protobuf:"bytes,1,opt,name=digest,proto3" json:"digest,omitempty"`
+	Entries           []*RecursiveListingInfo_Entry `protobuf:"bytes,2,rep,name=entries,proto3" json:"entries,omitempty"`
+	FileCount         int64                         `protobuf:"varint,3,opt,name=file_count,json=fileCount,proto3" json:"file_count,omitempty"`
+	DirectoryCount    int64                         `protobuf:"varint,4,opt,name=directory_count,json=directoryCount,proto3" json:"directory_count,omitempty"`
+	UniqueSizeBytes   int64                         `protobuf:"varint,5,opt,name=unique_size_bytes,json=uniqueSizeBytes,proto3" json:"unique_size_bytes,omitempty"`
+	TotalSizeBytes    int64                         `protobuf:"varint,6,opt,name=total_size_bytes,json=totalSizeBytes,proto3" json:"total_size_bytes,omitempty"`
+	EntryLimitReached bool                          `protobuf:"varint,7,opt,name=entry_limit_reached,json=entryLimitReached,proto3" json:"entry_limit_reached,omitempty"`
+	unknownFields     protoimpl.UnknownFields
+	sizeCache         protoimpl.SizeCache
+}
+
+func (x *RecursiveListingInfo) Reset() {
+	*x = RecursiveListingInfo{}
+	mi := &file_github_com_buildbarn_bb_browser_pkg_proto_query_query_proto_msgTypes[7]
+	ms := protoimpl.X.MessageStateOf(protoimpl.Pointer(x))
+	ms.StoreMessageInfo(mi)
+}
+
+func (x *RecursiveListingInfo) String() string {
+	return protoimpl.X.MessageStringOf(x)
+}
+
+func (*RecursiveListingInfo) ProtoMessage() {}
+
+func (x *RecursiveListingInfo) ProtoReflect() protoreflect.Message {
+	mi := &file_github_com_buildbarn_bb_browser_pkg_proto_query_query_proto_msgTypes[7]
+	if x != nil {
+		ms := protoimpl.X.MessageStateOf(protoimpl.Pointer(x))
+		if ms.LoadMessageInfo() == nil {
+			ms.StoreMessageInfo(mi)
+		}
+		return ms
+	}
+	return mi.MessageOf(x)
+}
+
+// Deprecated: Use RecursiveListingInfo.ProtoReflect.Descriptor instead.
+func (*RecursiveListingInfo) Descriptor() ([]byte, []int) {
+	return file_github_com_buildbarn_bb_browser_pkg_proto_query_query_proto_rawDescGZIP(), []int{7}
+}
+
+func (x *RecursiveListingInfo) GetDigest() *v2.Digest {
+	if x != nil {
+		return x.Digest
+	}
+	return nil
+}
+
+func (x *RecursiveListingInfo) GetEntries() []*RecursiveListingInfo_Entry {
+	if x != nil {
+		return x.Entries
+	}
+	return nil
+}
+
+func (x *RecursiveListingInfo) GetFileCount() int64 {
+	if x != nil {
+		return x.FileCount
+	}
+	return 0
+}
+
+func (x *RecursiveListingInfo) GetDirectoryCount() int64 {
+	if x != nil {
+		return x.DirectoryCount
+	}
+	return 0
+}
+
+func (x *RecursiveListingInfo) GetUniqueSizeBytes() int64 {
+	if x != nil {
+		return x.UniqueSizeBytes
+	}
+	return 0
+}
+
+func (x *RecursiveListingInfo) GetTotalSizeBytes() int64 {
+	if x != nil {
+		return x.TotalSizeBytes
+	}
+	return 0
+}
+
+func (x *RecursiveListingInfo) GetEntryLimitReached() bool {
+	if x != nil {
+		return x.EntryLimitReached
+	}
+	return false
+}
+
+type SearchInfo struct {
+	state                 protoimpl.MessageState        `protogen:"open.v1"`
+	Digest                *v2.Digest                    `protobuf:"bytes,1,opt,name=digest,proto3" json:"digest,omitempty"`
+	Pattern               string                        `protobuf:"bytes,2,opt,name=pattern,proto3" json:"pattern,omitempty"`
+	Syntax                string                        `protobuf:"bytes,3,opt,name=syntax,proto3" json:"syntax,omitempty"`
+	Results               []*RecursiveListingInfo_Entry `protobuf:"bytes,4,rep,name=results,proto3" json:"results,omitempty"`
+	DirectoriesVisited    int64                         `protobuf:"varint,5,opt,name=directories_visited,json=directoriesVisited,proto3" json:"directories_visited,omitempty"`
+	DirectoryLimitReached bool                          `protobuf:"varint,6,opt,name=directory_limit_reached,json=directoryLimitReached,proto3" json:"directory_limit_reached,omitempty"`
+	ResultLimitReached    bool                          `protobuf:"varint,7,opt,name=result_limit_reached,json=resultLimitReached,proto3" json:"result_limit_reached,omitempty"`
+	unknownFields         protoimpl.UnknownFields
+	sizeCache             protoimpl.SizeCache
+}
+
+func (x *SearchInfo) Reset() {
+	*x = SearchInfo{}
+	mi := &file_github_com_buildbarn_bb_browser_pkg_proto_query_query_proto_msgTypes[8]
+	ms := protoimpl.X.MessageStateOf(protoimpl.Pointer(x))
+	ms.StoreMessageInfo(mi)
+}
+
+func (x *SearchInfo) String() string {
+	return protoimpl.X.MessageStringOf(x)
+}
+
+func (*SearchInfo) ProtoMessage() {}
+
+func (x *SearchInfo) ProtoReflect() protoreflect.Message {
+	mi := &file_github_com_buildbarn_bb_browser_pkg_proto_query_query_proto_msgTypes[8]
+	if x != nil {
+		ms := protoimpl.X.MessageStateOf(protoimpl.Pointer(x))
+		if ms.LoadMessageInfo() == nil {
+			ms.StoreMessageInfo(mi)
+		}
+		return ms
+	}
+	return mi.MessageOf(x)
+}
+
+// Deprecated: Use SearchInfo.ProtoReflect.Descriptor instead.
+func (*SearchInfo) Descriptor() ([]byte, []int) {
+	return file_github_com_buildbarn_bb_browser_pkg_proto_query_query_proto_rawDescGZIP(), []int{8}
+}
+
+func (x *SearchInfo) GetDigest() *v2.Digest {
+	if x != nil {
+		return x.Digest
+	}
+	return nil
+}
+
+func (x *SearchInfo) GetPattern() string {
+	if x != nil {
+		return x.Pattern
+	}
+	return ""
+}
+
+func (x *SearchInfo) GetSyntax() string {
+	if x != nil {
+		return x.Syntax
+	}
+	return ""
+}
+
+func (x *SearchInfo) GetResults() []*RecursiveListingInfo_Entry {
+	if x != nil {
+		return x.Results
+	}
+	return nil
+}
+
+func (x *SearchInfo) GetDirectoriesVisited() int64 {
+	if x != nil {
+		return x.DirectoriesVisited
+	}
+	return 0
+}
+
+func (x *SearchInfo) GetDirectoryLimitReached() bool {
+	if x != nil {
+		return x.DirectoryLimitReached
+	}
+	return false
+}
+
+func (x *SearchInfo) GetResultLimitReached() bool {
+	if x != nil {
+		return x.ResultLimitReached
+	}
+	return false
+}
+
+type GrepInfo struct {
+	state                 protoimpl.MessageState `protogen:"open.v1"`
+	Digest                *v2.Digest             `protobuf:"bytes,1,opt,name=digest,proto3" json:"digest,omitempty"`
+	Pattern               string                 `protobuf:"bytes,2,opt,name=pattern,proto3" json:"pattern,omitempty"`
+	ContextLines          int32                  `protobuf:"varint,3,opt,name=context_lines,json=contextLines,proto3" json:"context_lines,omitempty"`
+	Files                 []*GrepInfo_File       `protobuf:"bytes,4,rep,name=files,proto3" json:"files,omitempty"`
+	FilesScanned          int64                  `protobuf:"varint,5,opt,name=files_scanned,json=filesScanned,proto3" json:"files_scanned,omitempty"`
+	BytesScanned          int64                  `protobuf:"varint,6,opt,name=bytes_scanned,json=bytesScanned,proto3" json:"bytes_scanned,omitempty"`
+	BinaryFilesSkipped    int64                  `protobuf:"varint,7,opt,name=binary_files_skipped,json=binaryFilesSkipped,proto3" json:"binary_files_skipped,omitempty"`
+	SizeLimitReached      bool                   `protobuf:"varint,8,opt,name=size_limit_reached,json=sizeLimitReached,proto3" json:"size_limit_reached,omitempty"`
+	MatchLimitReached     bool                   `protobuf:"varint,9,opt,name=match_limit_reached,json=matchLimitReached,proto3" json:"match_limit_reached,omitempty"`
+	DirectoryLimitReached bool                   `protobuf:"varint,10,opt,name=directory_limit_reached,json=directoryLimitReached,proto3" json:"directory_limit_reached,omitempty"`
+	unknownFields         protoimpl.UnknownFields
+	sizeCache             protoimpl.SizeCache
+}
+
+func (x *GrepInfo) Reset() {
+	*x = GrepInfo{}
+	mi := &file_github_com_buildbarn_bb_browser_pkg_proto_query_query_proto_msgTypes[9]
+	ms := protoimpl.X.MessageStateOf(protoimpl.Pointer(x))
+	ms.StoreMessageInfo(mi)
+}
+
+func (x *GrepInfo) String() string {
+	return protoimpl.X.MessageStringOf(x)
+}
+
+func (*GrepInfo) ProtoMessage() {}
+
+func (x *GrepInfo) ProtoReflect() protoreflect.Message {
+	mi := &file_github_com_buildbarn_bb_browser_pkg_proto_query_query_proto_msgTypes[9]
+	if x != nil {
+		ms := protoimpl.X.MessageStateOf(protoimpl.Pointer(x))
+		if ms.LoadMessageInfo() == nil {
+			ms.StoreMessageInfo(mi)
+		}
+		return ms
+	}
+	return mi.MessageOf(x)
+}
+
+// Deprecated: Use GrepInfo.ProtoReflect.Descriptor instead.
+func (*GrepInfo) Descriptor() ([]byte, []int) {
+	return file_github_com_buildbarn_bb_browser_pkg_proto_query_query_proto_rawDescGZIP(), []int{9}
+}
+
+func (x *GrepInfo) GetDigest() *v2.Digest {
+	if x != nil {
+		return x.Digest
+	}
+	return nil
+}
+
+func (x *GrepInfo) GetPattern() string {
+	if x != nil {
+		return x.Pattern
+	}
+	return ""
+}
+
+func (x *GrepInfo) GetContextLines() int32 {
+	if x != nil {
+		return x.ContextLines
+	}
+	return 0
+}
+
+func (x *GrepInfo) GetFiles() []*GrepInfo_File {
+	if x != nil {
+		return x.Files
+	}
+	return nil
+}
+
+func (x *GrepInfo) GetFilesScanned() int64 {
+	if x != nil {
+		return x.FilesScanned
+	}
+	return 0
+}
+
+func (x *GrepInfo) GetBytesScanned() int64 {
+	if x != nil {
+		return x.BytesScanned
+	}
+	return 0
+}
+
+func (x *GrepInfo) GetBinaryFilesSkipped() int64 {
+	if x != nil {
+		return x.BinaryFilesSkipped
+	}
+	return 0
+}
+
+func (x *GrepInfo) GetSizeLimitReached() bool {
+	if x != nil {
+		return x.SizeLimitReached
+	}
+	return false
+}
+
+func (x *GrepInfo) GetMatchLimitReached() bool {
+	if x != nil {
+		return x.MatchLimitReached
+	}
+	return false
+}
+
+func (x *GrepInfo) GetDirectoryLimitReached() bool {
+	if x != nil {
+		return x.DirectoryLimitReached
+	}
+	return false
+}
+
+type LogInfo struct {
+	state         protoimpl.MessageState `protogen:"open.v1"`
+	Name          string                 `protobuf:"bytes,1,opt,name=name,proto3" json:"name,omitempty"`
+	Digest        *v2.Digest             `protobuf:"bytes,2,opt,name=digest,proto3" json:"digest,omitempty"`
+	TooLarge      bool                   `protobuf:"varint,3,opt,name=too_large,json=tooLarge,proto3" json:"too_large,omitempty"`
+	NotFound      bool                   `protobuf:"varint,4,opt,name=not_found,json=notFound,proto3" json:"not_found,omitempty"`
+	Contents      []byte                 `protobuf:"bytes,5,opt,name=contents,proto3" json:"contents,omitempty"`
+	unknownFields protoimpl.UnknownFields
+	sizeCache     protoimpl.SizeCache
+}
+
+func (x *LogInfo) Reset() {
+	*x = LogInfo{}
+	mi := &file_github_com_buildbarn_bb_browser_pkg_proto_query_query_proto_msgTypes[10]
+	ms := protoimpl.X.MessageStateOf(protoimpl.Pointer(x))
+	ms.StoreMessageInfo(mi)
+}
+
+func (x *LogInfo) String() string {
+	return protoimpl.X.MessageStringOf(x)
+}
+
+func (*LogInfo) ProtoMessage() {}
+
+func (x *LogInfo) ProtoReflect() protoreflect.Message {
+	mi := &file_github_com_buildbarn_bb_browser_pkg_proto_query_query_proto_msgTypes[10]
+	if x != nil {
+		ms := protoimpl.X.MessageStateOf(protoimpl.Pointer(x))
+		if ms.LoadMessageInfo() == nil {
+			ms.StoreMessageInfo(mi)
+		}
+		return ms
+	}
+	return mi.MessageOf(x)
+}
+
+// Deprecated: Use LogInfo.ProtoReflect.Descriptor instead.
+func (*LogInfo) Descriptor() ([]byte, []int) {
+	return file_github_com_buildbarn_bb_browser_pkg_proto_query_query_proto_rawDescGZIP(), []int{10}
+}
+
+func (x *LogInfo) GetName() string {
+	if x != nil {
+		return x.Name
+	}
+	return ""
+}
+
+func (x *LogInfo) GetDigest() *v2.Digest {
+	if x != nil {
+		return x.Digest
+	}
+	return nil
+}
+
+func (x *LogInfo) GetTooLarge() bool {
+	if x != nil {
+		return x.TooLarge
+	}
+	return false
+}
+
+func (x *LogInfo) GetNotFound() bool {
+	if x != nil {
+		return x.NotFound
+	}
+	return false
+}
+
+func (x *LogInfo) GetContents() []byte {
+	if x != nil {
+		return x.Contents
+	}
+	return nil
+}
+
+type LogPageInfo struct {
+	state             protoimpl.MessageState `protogen:"open.v1"`
+	Digest            *v2.Digest             `protobuf:"bytes,1,opt,name=digest,proto3" json:"digest,omitempty"`
+	Offset            int64                  `protobuf:"varint,2,opt,name=offset,proto3" json:"offset,omitempty"`
+	EndOffset         int64                  `protobuf:"varint,3,opt,name=end_offset,json=endOffset,proto3" json:"end_offset,omitempty"`
+	Contents          []byte                 `protobuf:"bytes,4,opt,name=contents,proto3" json:"contents,omitempty"`
+	Pattern           string                 `protobuf:"bytes,5,opt,name=pattern,proto3" json:"pattern,omitempty"`
+	Hunks             []*GrepInfo_Hunk       `protobuf:"bytes,6,rep,name=hunks,proto3" json:"hunks,omitempty"`
+	MatchCount        int64                  `protobuf:"varint,7,opt,name=match_count,json=matchCount,proto3" json:"match_count,omitempty"`
+	MatchLimitReached bool                   `protobuf:"varint,8,opt,name=match_limit_reached,json=matchLimitReached,proto3" json:"match_limit_reached,omitempty"`
+	SizeLimitReached  bool                   `protobuf:"varint,9,opt,name=size_limit_reached,json=sizeLimitReached,proto3" json:"size_limit_reached,omitempty"`
+	unknownFields     protoimpl.UnknownFields
+	sizeCache         protoimpl.SizeCache
+}
+
+func (x *LogPageInfo) Reset() {
+	*x = LogPageInfo{}
+	mi := &file_github_com_buildbarn_bb_browser_pkg_proto_query_query_proto_msgTypes[11]
 	ms := protoimpl.X.MessageStateOf(protoimpl.Pointer(x))
 	ms.StoreMessageInfo(mi)
 }
 
-func (x *FileSystemAccessProfileReference) String() string {
+func (x *LogPageInfo) String() string {
 	return protoimpl.X.MessageStringOf(x)
 }
 
-func (*FileSystemAccessProfileReference) ProtoMessage() {}
+func (*LogPageInfo) ProtoMessage() {}
 
-func (x *FileSystemAccessProfileReference) ProtoReflect() protoreflect.Message {
-	mi := &file_github_com_buildbarn_bb_browser_pkg_proto_query_query_proto_msgTypes[0]
+func (x *LogPageInfo) ProtoReflect() protoreflect.Message {
+	mi := &file_github_com_buildbarn_bb_browser_pkg_proto_query_query_proto_msgTypes[11]
 	if x != nil {
 		ms := protoimpl.X.MessageStateOf(protoimpl.Pointer(x))
 		if ms.LoadMessageInfo() == nil {
@@ -55,33 +1190,521 @@ func (x *FileSystemAccessProfileReference) ProtoReflect() protoreflect.Message {
 	return mi.MessageOf(x)
 }
 
-// Deprecated: Use FileSystemAccessProfileReference.ProtoReflect.Descriptor instead.
-func (*FileSystemAccessProfileReference) Descriptor() ([]byte, []int) {
-	return file_github_com_buildbarn_bb_browser_pkg_proto_query_query_proto_rawDescGZIP(), []int{0}
+// Deprecated: Use LogPageInfo.ProtoReflect.Descriptor instead.
+func (*LogPageInfo) Descriptor() ([]byte, []int) {
+	return file_github_com_buildbarn_bb_browser_pkg_proto_query_query_proto_rawDescGZIP(), []int{11}
 }
 
-func (x *FileSystemAccessProfileReference) GetDigest() *v2.Digest {
+func (x *LogPageInfo) GetDigest() *v2.Digest {
 	if x != nil {
 		return x.Digest
 	}
 	return nil
 }
 
-func (x *FileSystemAccessProfileReference) GetPathHashesBaseHash() uint64 {
+func (x *LogPageInfo) GetOffset() int64 {
 	if x != nil {
-		return x.PathHashesBaseHash
+		return x.Offset
+	}
+	return 0
+}
+
+func (x *LogPageInfo) GetEndOffset() int64 {
+	if x != nil {
+		return x.EndOffset
+	}
+	return 0
+}
+
+func (x *LogPageInfo) GetContents() []byte {
+	if x != nil {
+		return x.Contents
+	}
+	return nil
+}
+
+func (x *LogPageInfo) GetPattern() string {
+	if x != nil {
+		return x.Pattern
+	}
+	return ""
+}
+
+func (x *LogPageInfo) GetHunks() []*GrepInfo_Hunk {
+	if x != nil {
+		return x.Hunks
+	}
+	return nil
+}
+
+func (x *LogPageInfo) GetMatchCount() int64 {
+	if x != nil {
+		return x.MatchCount
+	}
+	return 0
+}
+
+func (x *LogPageInfo) GetMatchLimitReached() bool {
+	if x != nil {
+		return x.MatchLimitReached
+	}
+	return false
+}
+
+func (x *LogPageInfo) GetSizeLimitReached() bool {
+	if x != nil {
+		return x.SizeLimitReached
+	}
+	return false
+}
+
+type PreviousExecutionStatsInfo struct {
+	state               protoimpl.MessageState       `protogen:"open.v1"`
+	ReducedActionDigest *v2.Digest                   `protobuf:"bytes,1,opt,name=reduced_action_digest,json=reducedActionDigest,proto3" json:"reduced_action_digest,omitempty"`
+	Stats               *iscc.PreviousExecutionStats `protobuf:"bytes,2,opt,name=stats,proto3" json:"stats,omitempty"`
+	unknownFields       protoimpl.UnknownFields
+	sizeCache           protoimpl.SizeCache
+}
+
+func (x *PreviousExecutionStatsInfo) Reset() {
+	*x = PreviousExecutionStatsInfo{}
+	mi := &file_github_com_buildbarn_bb_browser_pkg_proto_query_query_proto_msgTypes[12]
+	ms := protoimpl.X.MessageStateOf(protoimpl.Pointer(x))
+	ms.StoreMessageInfo(mi)
+}
+
+func (x *PreviousExecutionStatsInfo) String() string {
+	return protoimpl.X.MessageStringOf(x)
+}
+
+func (*PreviousExecutionStatsInfo) ProtoMessage() {}
+
+func (x *PreviousExecutionStatsInfo) ProtoReflect() protoreflect.Message {
+	mi := &file_github_com_buildbarn_bb_browser_pkg_proto_query_query_proto_msgTypes[12]
+	if x != nil {
+		ms := protoimpl.X.MessageStateOf(protoimpl.Pointer(x))
+		if ms.LoadMessageInfo() == nil {
+			ms.StoreMessageInfo(mi)
+		}
+		return ms
+	}
+	return mi.MessageOf(x)
+}
+
+// Deprecated: Use PreviousExecutionStatsInfo.ProtoReflect.Descriptor instead.
+func (*PreviousExecutionStatsInfo) Descriptor() ([]byte, []int) {
+	return file_github_com_buildbarn_bb_browser_pkg_proto_query_query_proto_rawDescGZIP(), []int{12}
+}
+
+func (x *PreviousExecutionStatsInfo) GetReducedActionDigest() *v2.Digest {
+	if x != nil {
+		return x.ReducedActionDigest
+	}
+	return nil
+}
+
+func (x *PreviousExecutionStatsInfo) GetStats() *iscc.PreviousExecutionStats {
+	if x != nil {
+		return x.Stats
+	}
+	return nil
+}
+
+type RecursiveListingInfo_Entry struct {
+	state         protoimpl.MessageState          `protogen:"open.v1"`
+	Type          RecursiveListingInfo_Entry_Type `protobuf:"varint,1,opt,name=type,proto3,enum=buildbarn.query.RecursiveListingInfo_Entry_Type" json:"type,omitempty"`
+	Path          string                          `protobuf:"bytes,2,opt,name=path,proto3" json:"path,omitempty"`
+	Digest        *v2.Digest                      `protobuf:"bytes,3,opt,name=digest,proto3" json:"digest,omitempty"`
+	IsExecutable  bool                            `protobuf:"varint,4,opt,name=is_executable,json=isExecutable,proto3" json:"is_executable,omitempty"`
+	Target        string                          `protobuf:"bytes,5,opt,name=target,proto3" json:"target,omitempty"`
+	unknownFields protoimpl.UnknownFields
+	sizeCache     protoimpl.SizeCache
+}
+
+func (x *RecursiveListingInfo_Entry) Reset() {
+	*x = RecursiveListingInfo_Entry{}
+	mi := &file_github_com_buildbarn_bb_browser_pkg_proto_query_query_proto_msgTypes[14]
+	ms := protoimpl.X.MessageStateOf(protoimpl.Pointer(x))
+	ms.StoreMessageInfo(mi)
+}
+
+func (x *RecursiveListingInfo_Entry) String() string {
+	return protoimpl.X.MessageStringOf(x)
+}
+
+func (*RecursiveListingInfo_Entry) ProtoMessage() {}
+
+func (x *RecursiveListingInfo_Entry) ProtoReflect() protoreflect.Message {
+	mi := &file_github_com_buildbarn_bb_browser_pkg_proto_query_query_proto_msgTypes[14]
+	if x != nil {
+		ms := protoimpl.X.MessageStateOf(protoimpl.Pointer(x))
+		if ms.LoadMessageInfo() == nil {
+			ms.StoreMessageInfo(mi)
+		}
+		return ms
+	}
+	return mi.MessageOf(x)
+}
+
+// Deprecated: Use RecursiveListingInfo_Entry.ProtoReflect.Descriptor instead.
+func (*RecursiveListingInfo_Entry) Descriptor() ([]byte, []int) {
+	return file_github_com_buildbarn_bb_browser_pkg_proto_query_query_proto_rawDescGZIP(), []int{7, 0}
+}
+
+func (x *RecursiveListingInfo_Entry) GetType() RecursiveListingInfo_Entry_Type {
+	if x != nil {
+		return x.Type
+	}
+	return RecursiveListingInfo_Entry_UNKNOWN
+}
+
+func (x *RecursiveListingInfo_Entry) GetPath() string {
+	if x != nil {
+		return x.Path
+	}
+	return ""
+}
+
+func (x *RecursiveListingInfo_Entry) GetDigest() *v2.Digest {
+	if x != nil {
+		return x.Digest
+	}
+	return nil
+}
+
+func (x *RecursiveListingInfo_Entry) GetIsExecutable() bool {
+	if x != nil {
+		return x.IsExecutable
+	}
+	return false
+}
+
+func (x *RecursiveListingInfo_Entry) GetTarget() string {
+	if x != nil {
+		return x.Target
+	}
+	return ""
+}
+
+type GrepInfo_Line struct {
+	state         protoimpl.MessageState `protogen:"open.v1"`
+	Number        int64                  `protobuf:"varint,1,opt,name=number,proto3" json:"number,omitempty"`
+	Text          string                 `protobuf:"bytes,2,opt,name=text,proto3" json:"text,omitempty"`
+	IsMatch       bool                   `protobuf:"varint,3,opt,name=is_match,json=isMatch,proto3" json:"is_match,omitempty"`
+	unknownFields protoimpl.UnknownFields
+	sizeCache     protoimpl.SizeCache
+}
+
+func (x *GrepInfo_Line) Reset() {
+	*x = GrepInfo_Line{}
+	mi := &file_github_com_buildbarn_bb_browser_pkg_proto_query_query_proto_msgTypes[15]
+	ms := protoimpl.X.MessageStateOf(protoimpl.Pointer(x))
+	ms.StoreMessageInfo(mi)
+}
+
+func (x *GrepInfo_Line) String() string {
+	return protoimpl.X.MessageStringOf(x)
+}
+
+func (*GrepInfo_Line) ProtoMessage() {}
+
+func (x *GrepInfo_Line) ProtoReflect() protoreflect.Message {
+	mi := &file_github_com_buildbarn_bb_browser_pkg_proto_query_query_proto_msgTypes[15]
+	if x != nil {
+		ms := protoimpl.X.MessageStateOf(protoimpl.Pointer(x))
+		if ms.LoadMessageInfo() == nil {
+			ms.StoreMessageInfo(mi)
+		}
+		return ms
+	}
+	return mi.MessageOf(x)
+}
+
+// Deprecated: Use GrepInfo_Line.ProtoReflect.Descriptor instead.
+func (*GrepInfo_Line) Descriptor() ([]byte, []int) {
+	return file_github_com_buildbarn_bb_browser_pkg_proto_query_query_proto_rawDescGZIP(), []int{9, 0}
+}
+
+func (x *GrepInfo_Line) GetNumber() int64 {
+	if x != nil {
+		return x.Number
+	}
+	return 0
+}
+
+func (x *GrepInfo_Line) GetText() string {
+	if x != nil {
+		return x.Text
+	}
+	return ""
+}
+
+func (x *GrepInfo_Line) GetIsMatch() bool {
+	if x != nil {
+		return x.IsMatch
+	}
+	return false
+}
+
+type GrepInfo_Hunk struct {
+	state         protoimpl.MessageState `protogen:"open.v1"`
+	Lines         []*GrepInfo_Line       `protobuf:"bytes,1,rep,name=lines,proto3" json:"lines,omitempty"`
+	unknownFields protoimpl.UnknownFields
+	sizeCache     protoimpl.SizeCache
+}
+
+func (x *GrepInfo_Hunk) Reset() {
+	*x = GrepInfo_Hunk{}
+	mi := &file_github_com_buildbarn_bb_browser_pkg_proto_query_query_proto_msgTypes[16]
+	ms := protoimpl.X.MessageStateOf(protoimpl.Pointer(x))
+	ms.StoreMessageInfo(mi)
+}
+
+func (x *GrepInfo_Hunk) String() string {
+	return protoimpl.X.MessageStringOf(x)
+}
+
+func (*GrepInfo_Hunk) ProtoMessage() {}
+
+func (x *GrepInfo_Hunk) ProtoReflect() protoreflect.Message {
+	mi := &file_github_com_buildbarn_bb_browser_pkg_proto_query_query_proto_msgTypes[16]
+	if x != nil {
+		ms := protoimpl.X.MessageStateOf(protoimpl.Pointer(x))
+		if ms.LoadMessageInfo() == nil {
+			ms.StoreMessageInfo(mi)
+		}
+		return ms
+	}
+	return mi.MessageOf(x)
+}
+
+// Deprecated: Use GrepInfo_Hunk.ProtoReflect.Descriptor instead.
+func (*GrepInfo_Hunk) Descriptor() ([]byte, []int) {
+	return file_github_com_buildbarn_bb_browser_pkg_proto_query_query_proto_rawDescGZIP(), []int{9, 1}
+}
+
+func (x *GrepInfo_Hunk) GetLines() []*GrepInfo_Line {
+	if x != nil {
+		return x.Lines
+	}
+	return nil
+}
+
+type GrepInfo_File struct {
+	state         protoimpl.MessageState `protogen:"open.v1"`
+	Path          string                 `protobuf:"bytes,1,opt,name=path,proto3" json:"path,omitempty"`
+	Digest        *v2.Digest             `protobuf:"bytes,2,opt,name=digest,proto3" json:"digest,omitempty"`
+	Hunks         []*GrepInfo_Hunk       `protobuf:"bytes,3,rep,name=hunks,proto3" json:"hunks,omitempty"`
+	MatchCount    int64                  `protobuf:"varint,4,opt,name=match_count,json=matchCount,proto3" json:"match_count,omitempty"`
+	Error         *status.Status         `protobuf:"bytes,5,opt,name=error,proto3" json:"error,omitempty"`
+	unknownFields protoimpl.UnknownFields
+	sizeCache     protoimpl.SizeCache
+}
+
+func (x *GrepInfo_File) Reset() {
+	*x = GrepInfo_File{}
+	mi := &file_github_com_buildbarn_bb_browser_pkg_proto_query_query_proto_msgTypes[17]
+	ms := protoimpl.X.MessageStateOf(protoimpl.Pointer(x))
+	ms.StoreMessageInfo(mi)
+}
+
+func (x *GrepInfo_File) String() string {
+	return protoimpl.X.MessageStringOf(x)
+}
+
+func (*GrepInfo_File) ProtoMessage() {}
+
+func (x *GrepInfo_File) ProtoReflect() protoreflect.Message {
+	mi := &file_github_com_buildbarn_bb_browser_pkg_proto_query_query_proto_msgTypes[17]
+	if x != nil {
+		ms := protoimpl.X.MessageStateOf(protoimpl.Pointer(x))
+		if ms.LoadMessageInfo() == nil {
+			ms.StoreMessageInfo(mi)
+		}
+		return ms
+	}
+	return mi.MessageOf(x)
+}
+
+// Deprecated: Use GrepInfo_File.ProtoReflect.Descriptor instead.
+func (*GrepInfo_File) Descriptor() ([]byte, []int) {
+	return file_github_com_buildbarn_bb_browser_pkg_proto_query_query_proto_rawDescGZIP(), []int{9, 2}
+}
+
+func (x *GrepInfo_File) GetPath() string {
+	if x != nil {
+		return x.Path
+	}
+	return ""
+}
+
+func (x *GrepInfo_File) GetDigest() *v2.Digest {
+	if x != nil {
+		return x.Digest
+	}
+	return nil
+}
+
+func (x *GrepInfo_File) GetHunks() []*GrepInfo_Hunk {
+	if x != nil {
+		return x.Hunks
+	}
+	return nil
+}
+
+func (x *GrepInfo_File) GetMatchCount() int64 {
+	if x != nil {
+		return x.MatchCount
 	}
 	return 0
 }
 
+func (x *GrepInfo_File) GetError() *status.Status {
+	if x != nil {
+		return x.Error
+	}
+	return nil
+}
+
 var File_github_com_buildbarn_bb_browser_pkg_proto_query_query_proto protoreflect.FileDescriptor
 
 const file_github_com_buildbarn_bb_browser_pkg_proto_query_query_proto_rawDesc = "" +
 	"\n" +
-	";github.com/buildbarn/bb-browser/pkg/proto/query/query.proto\x12\x0fbuildbarn.query\x1a6build/bazel/remote/execution/v2/remote_execution.proto\"\x96\x01\n" +
+	";github.com/buildbarn/bb-browser/pkg/proto/query/query.proto\x12\x0fbuildbarn.query\x1a6build/bazel/remote/execution/v2/remote_execution.proto\x1a9github.com/buildbarn/bb-storage/pkg/proto/iscc/iscc.proto\x1a\x17google/rpc/status.proto\"\x96\x01\n" +
 	" FileSystemAccessProfileReference\x12?\n" +
 	"\x06digest\x18\x01 \x01(\v2'.build.bazel.remote.execution.v2.DigestR\x06digest\x121\n" +
-	"\x15path_hashes_base_hash\x18\x02 \x01(\x04R\x12pathHashesBaseHashB1Z/github.com/buildbarn/bb-browser/pkg/proto/queryb\x06proto3"
+	"\x15path_hashes_base_hash\x18\x02 \x01(\x04R\x12pathHashesBaseHash\"\xdb\x05\n" +
+	"\bResponse\x125\n" +
+	"\x06action\x18\x01 \x01(\v2\x1b.buildbarn.query.ActionInfoH\x00R\x06action\x128\n" +
+	"\acommand\x18\x02 \x01(\v2\x1c.buildbarn.query.CommandInfoH\x00R\acommand\x12>\n" +
+	"\tdirectory\x18\x03 \x01(\v2\x1e.buildbarn.query.DirectoryInfoH\x00R\tdirectory\x12/\n" +
+	"\x04tree\x18\x04 \x01(\v2\x19.buildbarn.query.TreeInfoH\x00R\x04tree\x12g\n" +
+	"\x18previous_execution_stats\x18\x05 \x01(\v2+.buildbarn.query.PreviousExecutionStatsInfoH\x00R\x16previousExecutionStats\x12D\n" +
+	"\faction_input\x18\x06 \x01(\v2\x1f.buildbarn.query.ActionPathInfoH\x00R\vactionInput\x12F\n" +
+	"\raction_output\x18\a \x01(\v2\x1f.buildbarn.query.ActionPathInfoH\x00R\factionOutput\x12T\n" +
+	"\x11recursive_listing\x18\b \x01(\v2%.buildbarn.query.RecursiveListingInfoH\x00R\x10recursiveListing\x125\n" +
+	"\x06search\x18\t \x01(\v2\x1b.buildbarn.query.SearchInfoH\x00R\x06search\x12/\n" +
+	"\x04grep\x18\n" +
+	" \x01(\v2\x19.buildbarn.query.GrepInfoH\x00R\x04grep\x120\n" +
+	"\x03log\x18\v \x01(\v2\x1c.buildbarn.query.LogPageInfoH\x00R\x03logB\x06\n" +
+	"\x04page\"\xb4\x06\n" +
+	"\n" +
+	"ActionInfo\x12L\n" +
+	"\raction_digest\x18\x01 \x01(\v2'.build.bazel.remote.execution.v2.DigestR\factionDigest\x12C\n" +
+	"\x1eis_historical_execute_response\x18\x02 \x01(\bR\x1bisHistoricalExecuteResponse\x12?\n" +
+	"\x06action\x18\x03 \x01(\v2'.build.bazel.remote.execution.v2.ActionR\x06action\x126\n" +
+	"\acommand\x18\x04 \x01(\v2\x1c.buildbarn.query.CommandInfoR\acommand\x12[\n" +
+	"\x10execute_response\x18\x05 \x01(\v20.build.bazel.remote.execution.v2.ExecuteResponseR\x0fexecuteResponse\x120\n" +
+	"\x06stdout\x18\x06 \x01(\v2\x18.buildbarn.query.LogInfoR\x06stdout\x120\n" +
+	"\x06stderr\x18\a \x01(\v2\x18.buildbarn.query.LogInfoR\x06stderr\x12=\n" +
+	"\n" +
+	"input_root\x18\b \x01(\v2\x1e.buildbarn.query.DirectoryInfoR\tinputRoot\x12#\n" +
+	"\rmissing_paths\x18\t \x03(\tR\fmissingPaths\x12e\n" +
+	"\x18previous_execution_stats\x18\n" +
+	" \x01(\v2+.buildbarn.query.PreviousExecutionStatsInfoR\x16previousExecutionStats\x12?\n" +
+	"\x06errors\x18\v \x03(\v2'.buildbarn.query.ActionInfo.ErrorsEntryR\x06errors\x1aM\n" +
+	"\vErrorsEntry\x12\x10\n" +
+	"\x03key\x18\x01 \x01(\tR\x03key\x12(\n" +
+	"\x05value\x18\x02 \x01(\v2\x12.google.rpc.StatusR\x05value:\x028\x01\"\xba\x01\n" +
+	"\vCommandInfo\x12?\n" +
+	"\x06digest\x18\x01 \x01(\v2'.build.bazel.remote.execution.v2.DigestR\x06digest\x12B\n" +
+	"\acommand\x18\x02 \x01(\v2(.build.bazel.remote.execution.v2.CommandR\acommand\x12&\n" +
+	"\x0fbb_clientd_path\x18\x03 \x01(\tR\rbbClientdPath\"\xf1\x02\n" +
+	"\rDirectoryInfo\x12?\n" +
+	"\x06digest\x18\x01 \x01(\v2'.build.bazel.remote.execution.v2.DigestR\x06digest\x12H\n" +
+	"\tdirectory\x18\x02 \x01(\v2*.build.bazel.remote.execution.v2.DirectoryR\tdirectory\x12&\n" +
+	"\x0fbb_clientd_path\x18\x03 \x01(\tR\rbbClientdPath\x12\x81\x01\n" +
+	"$file_system_access_profile_reference\x18\x04 \x01(\v21.buildbarn.query.FileSystemAccessProfileReferenceR fileSystemAccessProfileReference\x12)\n" +
+	"\x10prefetched_names\x18\x05 \x03(\tR\x0fprefetchedNames\"\xae\x02\n" +
+	"\bTreeInfo\x12H\n" +
+	"\vtree_digest\x18\x01 \x01(\v2'.build.bazel.remote.execution.v2.DigestR\n" +
+	"treeDigest\x12\x12\n" +
+	"\x04path\x18\x02 \x01(\tR\x04path\x12R\n" +
+	"\x10directory_digest\x18\x03 \x01(\v2'.build.bazel.remote.execution.v2.DigestR\x0fdirectoryDigest\x12H\n" +
+	"\tdirectory\x18\x04 \x01(\v2*.build.bazel.remote.execution.v2.DirectoryR\tdirectory\x12&\n" +
+	"\x0fbb_clientd_path\x18\x05 \x01(\tR\rbbClientdPath\"\x97\x03\n" +
+	"\x0eActionPathInfo\x12L\n" +
+	"\raction_digest\x18\x01 \x01(\v2'.build.bazel.remote.execution.v2.DigestR\factionDigest\x12\x12\n" +
+	"\x04path\x18\x02 \x01(\tR\x04path\x12R\n" +
+	"\x10directory_digest\x18\x03 \x01(\v2'.build.bazel.remote.execution.v2.DigestR\x0fdirectoryDigest\x12H\n" +
+	"\tdirectory\x18\x04 \x01(\v2*.build.bazel.remote.execution.v2.DirectoryR\tdirectory\x12=\n" +
+	"\x04file\x18\x05 \x01(\v2).build.bazel.remote.execution.v2.FileNodeR\x04file\x12F\n" +
+	"\asymlink\x18\x06 \x01(\v2,.build.bazel.remote.execution.v2.SymlinkNodeR\asymlink\"\x89\x05\n" +
+	"\x14RecursiveListingInfo\x12?\n" +
+	"\x06digest\x18\x01 \x01(\v2'.build.bazel.remote.execution.v2.DigestR\x06digest\x12E\n" +
+	"\aentries\x18\x02 \x03(\v2+.buildbarn.query.RecursiveListingInfo.EntryR\aentries\x12\x1d\n" +
+	"\n" +
+	"file_count\x18\x03 \x01(\x03R\tfileCount\x12'\n" +
+	"\x0fdirectory_count\x18\x04 \x01(\x03R\x0edirectoryCount\x12*\n" +
+	"\x11unique_size_bytes\x18\x05 \x01(\x03R\x0funiqueSizeBytes\x12(\n" +
+	"\x10total_size_bytes\x18\x06 \x01(\x03R\x0etotalSizeBytes\x12.\n" +
+	"\x13entry_limit_reached\x18\a \x01(\bR\x11entryLimitReached\x1a\x9a\x02\n" +
+	"\x05Entry\x12D\n" +
+	"\x04type\x18\x01 \x01(\x0e20.buildbarn.query.RecursiveListingInfo.Entry.TypeR\x04type\x12\x12\n" +
+	"\x04path\x18\x02 \x01(\tR\x04path\x12?\n" +
+	"\x06digest\x18\x03 \x01(\v2'.build.bazel.remote.execution.v2.DigestR\x06digest\x12#\n" +
+	"\ris_executable\x18\x04 \x01(\bR\fisExecutable\x12\x16\n" +
+	"\x06target\x18\x05 \x01(\tR\x06target\"9\n" +
+	"\x04Type\x12\v\n" +
+	"\aUNKNOWN\x10\x00\x12\r\n" +
+	"\tDIRECTORY\x10\x01\x12\b\n" +
+	"\x04FILE\x10\x02\x12\v\n" +
+	"\aSYMLINK\x10\x03\"\xe1\x02\n" +
+	"\n" +
+	"SearchInfo\x12?\n" +
+	"\x06digest\x18\x01 \x01(\v2'.build.bazel.remote.execution.v2.DigestR\x06digest\x12\x18\n" +
+	"\apattern\x18\x02 \x01(\tR\apattern\x12\x16\n" +
+	"\x06syntax\x18\x03 \x01(\tR\x06syntax\x12E\n" +
+	"\aresults\x18\x04 \x03(\v2+.buildbarn.query.RecursiveListingInfo.EntryR\aresults\x12/\n" +
+	"\x13directories_visited\x18\x05 \x01(\x03R\x12directoriesVisited\x126\n" +
+	"\x17directory_limit_reached\x18\x06 \x01(\bR\x15directoryLimitReached\x120\n" +
+	"\x14result_limit_reached\x18\a \x01(\bR\x12resultLimitReached\"\xbe\x06\n" +
+	"\bGrepInfo\x12?\n" +
+	"\x06digest\x18\x01 \x01(\v2'.build.bazel.remote.execution.v2.DigestR\x06digest\x12\x18\n" +
+	"\apattern\x18\x02 \x01(\tR\apattern\x12#\n" +
+	"\rcontext_lines\x18\x03 \x01(\x05R\fcontextLines\x124\n" +
+	"\x05files\x18\x04 \x03(\v2\x1e.buildbarn.query.GrepInfo.FileR\x05files\x12#\n" +
+	"\rfiles_scanned\x18\x05 \x01(\x03R\ffilesScanned\x12#\n" +
+	"\rbytes_scanned\x18\x06 \x01(\x03R\fbytesScanned\x120\n" +
+	"\x14binary_files_skipped\x18\a \x01(\x03R\x12binaryFilesSkipped\x12,\n" +
+	"\x12size_limit_reached\x18\b \x01(\bR\x10sizeLimitReached\x12.\n" +
+	"\x13match_limit_reached\x18\t \x01(\bR\x11matchLimitReached\x126\n" +
+	"\x17directory_limit_reached\x18\n" +
+	" \x01(\bR\x15directoryLimitReached\x1aM\n" +
+	"\x04Line\x12\x16\n" +
+	"\x06number\x18\x01 \x01(\x03R\x06number\x12\x12\n" +
+	"\x04text\x18\x02 \x01(\tR\x04text\x12\x19\n" +
+	"\bis_match\x18\x03 \x01(\bR\aisMatch\x1a<\n" +
+	"\x04Hunk\x124\n" +
+	"\x05lines\x18\x01 \x03(\v2\x1e.buildbarn.query.GrepInfo.LineR\x05lines\x1a\xdc\x01\n" +
+	"\x04File\x12\x12\n" +
+	"\x04path\x18\x01 \x01(\tR\x04path\x12?\n" +
+	"\x06digest\x18\x02 \x01(\v2'.build.bazel.remote.execution.v2.DigestR\x06digest\x124\n" +
+	"\x05hunks\x18\x03 \x03(\v2\x1e.buildbarn.query.GrepInfo.HunkR\x05hunks\x12\x1f\n" +
+	"\vmatch_count\x18\x04 \x01(\x03R\n" +
+	"matchCount\x12(\n" +
+	"\x05error\x18\x05 \x01(\v2\x12.google.rpc.StatusR\x05error\"\xb4\x01\n" +
+	"\aLogInfo\x12\x12\n" +
+	"\x04name\x18\x01 \x01(\tR\x04name\x12?\n" +
+	"\x06digest\x18\x02 \x01(\v2'.build.bazel.remote.execution.v2.DigestR\x06digest\x12\x1b\n" +
+	"\ttoo_large\x18\x03 \x01(\bR\btooLarge\x12\x1b\n" +
+	"\tnot_found\x18\x04 \x01(\bR\bnotFound\x12\x1a\n" +
+	"\bcontents\x18\x05 \x01(\fR\bcontents\"\xf0\x02\n" +
+	"\vLogPageInfo\x12?\n" +
+	"\x06digest\x18\x01 \x01(\v2'.build.bazel.remote.execution.v2.DigestR\x06digest\x12\x16\n" +
+	"\x06offset\x18\x02 \x01(\x03R\x06offset\x12\x1d\n" +
+	"\n" +
+	"end_offset\x18\x03 \x01(\x03R\tendOffset\x12\x1a\n" +
+	"\bcontents\x18\x04 \x01(\fR\bcontents\x12\x18\n" +
+	"\apattern\x18\x05 \x01(\tR\apattern\x124\n" +
+	"\x05hunks\x18\x06 \x03(\v2\x1e.buildbarn.query.GrepInfo.HunkR\x05hunks\x12\x1f\n" +
+	"\vmatch_count\x18\a \x01(\x03R\n" +
+	"matchCount\x12.\n" +
+	"\x13match_limit_reached\x18\b \x01(\bR\x11matchLimitReached\x12,\n" +
+	"\x12size_limit_reached\x18\t \x01(\bR\x10sizeLimitReached\"\xb7\x01\n" +
+	"\x1aPreviousExecutionStatsInfo\x12[\n" +
+	"\x15reduced_action_digest\x18\x01 \x01(\v2'.build.bazel.remote.execution.v2.DigestR\x13reducedActionDigest\x12<\n" +
+	"\x05stats\x18\x02 \x01(\v2&.buildbarn.iscc.PreviousExecutionStatsR\x05statsB1Z/github.com/buildbarn/bb-browser/pkg/proto/queryb\x06proto3"
 
 var (
 	file_github_com_buildbarn_bb_browser_pkg_proto_query_query_proto_rawDescOnce sync.Once
@@ -95,18 +1718,96 @@ func file_github_com_buildbarn_bb_browser_pkg_proto_query_query_proto_rawDescGZI
 	return file_github_com_buildbarn_bb_browser_pkg_proto_query_query_proto_rawDescData
 }
 
-var file_github_com_buildbarn_bb_browser_pkg_proto_query_query_proto_msgTypes = make([]protoimpl.MessageInfo, 1)
+var file_github_com_buildbarn_bb_browser_pkg_proto_query_query_proto_enumTypes = make([]protoimpl.EnumInfo, 1)
+var file_github_com_buildbarn_bb_browser_pkg_proto_query_query_proto_msgTypes = make([]protoimpl.MessageInfo, 18)
 var file_github_com_buildbarn_bb_browser_pkg_proto_query_query_proto_goTypes = []any{
-	(*FileSystemAccessProfileReference)(nil), // 0: buildbarn.query.FileSystemAccessProfileReference
-	(*v2.Digest)(nil),                        // 1: build.bazel.remote.execution.v2.Digest
+	(RecursiveListingInfo_Entry_Type)(0),     // 0: buildbarn.query.RecursiveListingInfo.Entry.Type
+	(*FileSystemAccessProfileReference)(nil), // 1: buildbarn.query.FileSystemAccessProfileReference
+	(*Response)(nil),                         // 2: buildbarn.query.Response
+	(*ActionInfo)(nil),                       // 3: buildbarn.query.ActionInfo
+	(*CommandInfo)(nil),                      // 4: buildbarn.query.CommandInfo
+	(*DirectoryInfo)(nil),                    // 5: buildbarn.query.DirectoryInfo
+	(*TreeInfo)(nil),                         // 6: buildbarn.query.TreeInfo
+	(*ActionPathInfo)(nil),                   // 7: buildbarn.query.ActionPathInfo
+	(*RecursiveListingInfo)(nil),             // 8: buildbarn.query.RecursiveListingInfo
+	(*SearchInfo)(nil),                       // 9: buildbarn.query.SearchInfo
+	(*GrepInfo)(nil),                         // 10: buildbarn.query.GrepInfo
+	(*LogInfo)(nil),                          // 11: buildbarn.query.LogInfo
+	(*LogPageInfo)(nil),                      // 12: buildbarn.query.LogPageInfo
+	(*PreviousExecutionStatsInfo)(nil),       // 13: buildbarn.query.PreviousExecutionStatsInfo
+	nil,                                      // 14: buildbarn.query.ActionInfo.ErrorsEntry
+	(*RecursiveListingInfo_Entry)(nil),       // 15: buildbarn.query.RecursiveListingInfo.Entry
+	(*GrepInfo_Line)(nil),                    // 16: buildbarn.query.GrepInfo.Line
+	(*GrepInfo_Hunk)(nil),                    // 17: buildbarn.query.GrepInfo.Hunk
+	(*GrepInfo_File)(nil),                    // 18: buildbarn.query.GrepInfo.File
+	(*v2.Digest)(nil),                        // 19: build.bazel.remote.execution.v2.Digest
+	(*v2.Action)(nil),                        // 20: build.bazel.remote.execution.v2.Action
+	(*v2.ExecuteResponse)(nil),               // 21: build.bazel.remote.execution.v2.ExecuteResponse
+	(*v2.Command)(nil),                       // 22: build.bazel.remote.execution.v2.Command
+	(*v2.Directory)(nil),                     // 23: build.bazel.remote.execution.v2.Directory
+	(*v2.FileNode)(nil),                      // 24: build.bazel.remote.execution.v2.FileNode
+	(*v2.SymlinkNode)(nil),                   // 25: build.bazel.remote.execution.v2.SymlinkNode
+	(*iscc.PreviousExecutionStats)(nil),      // 26: buildbarn.iscc.PreviousExecutionStats
+	(*status.Status)(nil),                    // 27: google.rpc.Status
 }
 var file_github_com_buildbarn_bb_browser_pkg_proto_query_query_proto_depIdxs = []int32{
-	1, // 0: buildbarn.query.FileSystemAccessProfileReference.digest:type_name -> build.bazel.remote.execution.v2.Digest
-	1, // [1:1] is the sub-list for method output_type
-	1, // [1:1] is the sub-list for method input_type
-	1, // [1:1] is the sub-list for extension type_name
-	1, // [1:1] is the sub-list for extension extendee
-	0, // [0:1] is the sub-list for field type_name
+	19, // 0: buildbarn.query.FileSystemAccessProfileReference.digest:type_name -> build.bazel.remote.execution.v2.Digest
+	3,  // 1: buildbarn.query.Response.action:type_name -> buildbarn.query.ActionInfo
+	4,  // 2: buildbarn.query.Response.command:type_name -> buildbarn.query.CommandInfo
+	5,  // 3: buildbarn.query.Response.directory:type_name -> buildbarn.query.DirectoryInfo
+	6,  // 4: buildbarn.query.Response.tree:type_name -> buildbarn.query.TreeInfo
+	13, // 5: buildbarn.query.Response.previous_execution_stats:type_name -> buildbarn.query.PreviousExecutionStatsInfo
+	7,  // 6: buildbarn.query.Response.action_input:type_name -> buildbarn.query.ActionPathInfo
+	7,  // 7: buildbarn.query.Response.action_output:type_name -> buildbarn.query.ActionPathInfo
+	8,  // 8: buildbarn.query.Response.recursive_listing:type_name -> buildbarn.query.RecursiveListingInfo
+	9,  // 9: buildbarn.query.Response.search:type_name -> buildbarn.query.SearchInfo
+	10, // 10: buildbarn.query.Response.grep:type_name -> buildbarn.query.GrepInfo
+	12, // 11: buildbarn.query.Response.log:type_name -> buildbarn.query.LogPageInfo
+	19, // 12: buildbarn.query.ActionInfo.action_digest:type_name -> build.bazel.remote.execution.v2.Digest
+	20, // 13: buildbarn.query.ActionInfo.action:type_name -> build.bazel.remote.execution.v2.Action
+	4,  // 14: buildbarn.query.ActionInfo.command:type_name -> buildbarn.query.CommandInfo
+	21, // 15: buildbarn.query.ActionInfo.execute_response:type_name -> build.bazel.remote.execution.v2.ExecuteResponse
+	11, // 16: buildbarn.query.ActionInfo.stdout:type_name -> buildbarn.query.LogInfo
+	11, // 17: buildbarn.query.ActionInfo.stderr:type_name -> buildbarn.query.LogInfo
+	5,  // 18: buildbarn.query.ActionInfo.input_root:type_name -> buildbarn.query.DirectoryInfo
+	13, // 19: buildbarn.query.ActionInfo.previous_execution_stats:type_name -> buildbarn.query.PreviousExecutionStatsInfo
+	14, // 20: buildbarn.query.ActionInfo.errors:type_name -> buildbarn.query.ActionInfo.ErrorsEntry
+	19, // 21: buildbarn.query.CommandInfo.digest:type_name -> build.bazel.remote.execution.v2.Digest
+	22, // 22: buildbarn.query.CommandInfo.command:type_name -> build.bazel.remote.execution.v2.Command
+	19, // 23: buildbarn.query.DirectoryInfo.digest:type_name -> build.bazel.remote.execution.v2.Digest
+	23, // 24: buildbarn.query.DirectoryInfo.directory:type_name -> build.bazel.remote.execution.v2.Directory
+	1,  // 25: buildbarn.query.DirectoryInfo.file_system_access_profile_reference:type_name -> buildbarn.query.FileSystemAccessProfileReference
+	19, // 26: buildbarn.query.TreeInfo.tree_digest:type_name -> build.bazel.remote.execution.v2.Digest
+	19, // 27: buildbarn.query.TreeInfo.directory_digest:type_name -> build.bazel.remote.execution.v2.Digest
+	23, // 28: buildbarn.query.TreeInfo.directory:type_name -> build.bazel.remote.execution.v2.Directory
+	19, // 29: buildbarn.query.ActionPathInfo.action_digest:type_name -> build.bazel.remote.execution.v2.Digest
+	19, // 30: buildbarn.query.ActionPathInfo.directory_digest:type_name -> build.bazel.remote.execution.v2.Digest
+	23, // 31: buildbarn.query.ActionPathInfo.directory:type_name -> build.bazel.remote.execution.v2.Directory
+	24, // 32: buildbarn.query.ActionPathInfo.file:type_name -> build.bazel.remote.execution.v2.FileNode
+	25, // 33: buildbarn.query.ActionPathInfo.symlink:type_name -> build.bazel.remote.execution.v2.SymlinkNode
+	19, // 34: buildbarn.query.RecursiveListingInfo.digest:type_name -> build.bazel.remote.execution.v2.Digest
+	15, // 35: buildbarn.query.RecursiveListingInfo.entries:type_name -> buildbarn.query.RecursiveListingInfo.Entry
+	19, // 36: buildbarn.query.SearchInfo.digest:type_name -> build.bazel.remote.execution.v2.Digest
+	15, // 37: buildbarn.query.SearchInfo.results:type_name -> buildbarn.query.RecursiveListingInfo.Entry
+	19, // 38: buildbarn.query.GrepInfo.digest:type_name -> build.bazel.remote.execution.v2.Digest
+	18, // 39: buildbarn.query.GrepInfo.files:type_name -> buildbarn.query.GrepInfo.File
+	19, // 40: buildbarn.query.LogInfo.digest:type_name -> build.bazel.remote.execution.v2.Digest
+	19, // 41: buildbarn.query.LogPageInfo.digest:type_name -> build.bazel.remote.execution.v2.Digest
+	17, // 42: buildbarn.query.LogPageInfo.hunks:type_name -> buildbarn.query.GrepInfo.Hunk
+	19, // 43: buildbarn.query.PreviousExecutionStatsInfo.reduced_action_digest:type_name -> build.bazel.remote.execution.v2.Digest
+	26, // 44: buildbarn.query.PreviousExecutionStatsInfo.stats:type_name -> buildbarn.iscc.PreviousExecutionStats
+	27, // 45: buildbarn.query.ActionInfo.ErrorsEntry.value:type_name -> google.rpc.Status
+	0,  // 46: buildbarn.query.RecursiveListingInfo.Entry.type:type_name -> buildbarn.query.RecursiveListingInfo.Entry.Type
+	19, // 47: buildbarn.query.RecursiveListingInfo.Entry.digest:type_name -> build.bazel.remote.execution.v2.Digest
+	16, // 48: buildbarn.query.GrepInfo.Hunk.lines:type_name -> buildbarn.query.GrepInfo.Line
+	19, // 49: buildbarn.query.GrepInfo.File.digest:type_name -> build.bazel.remote.execution.v2.Digest
+	17, // 50: buildbarn.query.GrepInfo.File.hunks:type_name -> buildbarn.query.GrepInfo.Hunk
+	27, // 51: buildbarn.query.GrepInfo.File.error:type_name -> google.rpc.Status
+	52, // [52:52] is the sub-list for method output_type
+	52, // [52:52] is the sub-list for method input_type
+	52, // [52:52] is the sub-list for extension type_name
+	52, // [52:52] is the sub-list for extension extendee
+	0,  // [0:52] is the sub-list for field type_name
 }
 
 func init() { file_github_com_buildbarn_bb_browser_pkg_proto_query_query_proto_init() }
@@ -114,18 +1815,32 @@ func file_github_com_buildbarn_bb_browser_pkg_proto_query_query_proto_init() {
 	if File_github_com_buildbarn_bb_browser_pkg_proto_query_query_proto != nil {
 		return
 	}
+	file_github_com_buildbarn_bb_browser_pkg_proto_query_query_proto_msgTypes[1].OneofWrappers = []any{
+		(*Response_Action)(nil),
+		(*Response_Command)(nil),
+		(*Response_Directory)(nil),
+		(*Response_Tree)(nil),
+		(*Response_PreviousExecutionStats)(nil),
+		(*Response_ActionInput)(nil),
+		(*Response_ActionOutput)(nil),
+		(*Response_RecursiveListing)(nil),
+		(*Response_Search)(nil),
+		(*Response_Grep)(nil),
+		(*Response_Log)(nil),
+	}
 	type x struct{}
 	out := protoimpl.TypeBuilder{
 		File: protoimpl.DescBuilder{
 			GoPackagePath: reflect.TypeOf(x{}).PkgPath(),
 			RawDescriptor: unsafe.Slice(unsafe.StringData(file_github_com_buildbarn_bb_browser_pkg_proto_query_query_proto_rawDesc), len(file_github_com_buildbarn_bb_browser_pkg_proto_query_query_proto_rawDesc)),
-			NumEnums:      0,
-			NumMessages:   1,
+			NumEnums:      1,
+			NumMessages:   18,
 			NumExtensions: 0,
 			NumServices:   0,
 		},
 		GoTypes:           file_github_com_buildbarn_bb_browser_pkg_proto_query_query_proto_goTypes,
 		DependencyIndexes: file_github_com_buildbarn_bb_browser_pkg_proto_query_query_proto_depIdxs,
+		EnumInfos:         file_github_com_buildbarn_bb_browser_pkg_proto_query_query_proto_enumTypes,
 		MessageInfos:      file_github_com_buildbarn_bb_browser_pkg_proto_query_query_proto_msgTypes,
 	}.Build()
 	File_github_com_buildbarn_bb_browser_pkg_proto_query_query_proto = out.File